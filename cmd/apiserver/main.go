@@ -1,18 +1,37 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
+	"fmt"
 	"github.com/BurntSushi/toml"
+	"github.com/bolshagin/xsolla-be-2020/internal/acquirer"
 	"github.com/bolshagin/xsolla-be-2020/internal/apiserver"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
+const shutdownTimeout = 30 * time.Second
+
 var (
 	configPath string
 )
 
 func init() {
 	flag.StringVar(&configPath, "config-path", "configs/config.toml", "path to config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [migrate up|down [steps]|version] [user create email [role [merchant_id]]] [merchant ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 }
 
 func main() {
@@ -24,8 +43,271 @@ func main() {
 		log.Fatal(err)
 	}
 
-	s := apiserver.New(config)
+	st := sqlstore.New(config.Store)
+	if err := st.Open(config.Store.ConnectionString()); err != nil {
+		log.Fatal(err)
+	}
+	defer st.Close()
+
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(st, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "user" {
+		if err := user(st, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "merchant" {
+		if err := merchant(st, config, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if config.Store.AutoMigrate {
+		if err := st.MigrateUp(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	acq, err := acquirer.New(config.Acquirer)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := apiserver.New(config, st, acq)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+
 	if err := s.Start(); err != nil {
 		log.Fatal(err)
 	}
+	<-done
+}
+
+func migrate(st *sqlstore.Store, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		if err := st.MigrateUp(); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+		if err := st.MigrateDown(steps); err != nil {
+			return err
+		}
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate command %q", cmd)
+	}
+
+	version, err := st.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	log.Printf("schema version %d (latest %d)", version, sqlstore.LatestSchemaVersion())
+	return nil
+}
+
+// Создает пользователя API статистики. Пароль читается из стандартного ввода,
+// чтобы не оставлять его в истории команд
+func user(st *sqlstore.Store, args []string) error {
+	if len(args) < 2 || len(args) > 4 || args[0] != "create" {
+		return fmt.Errorf("usage: user create email [admin|merchant|analyst|support] [merchant_id]")
+	}
+
+	role := model.RoleAdmin
+	if len(args) > 2 {
+		role = model.Role(args[2])
+	}
+
+	// Пользователь привязывается к существующему мерчанту и видит только его сессии.
+	// Для роли merchant привязка обязательна
+	var merchantID uint
+	if len(args) == 4 {
+		m, err := findMerchant(st, args[3])
+		if err != nil {
+			return err
+		}
+		merchantID = m.MerchantID
+	}
+
+	fmt.Fprint(os.Stderr, "password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	u := &model.User{
+		Email:      args[1],
+		Role:       role,
+		MerchantID: merchantID,
+		Password:   strings.TrimRight(password, "\r\n"),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := st.User().Create(u); err != nil {
+		return err
+	}
+
+	log.Printf("user %d %s with role %s created", u.UserID, u.Email, u.Role)
+	return nil
+}
+
+// Управляет мерчантами, их API-ключами и секретами подписи уведомлений.
+// Значение ключа выводится только при выпуске, в БД хранится его хеш
+func merchant(st *sqlstore.Store, config *apiserver.Config, args []string) error {
+	usage := fmt.Errorf("usage: merchant create name [callback_url [session_ttl]] | merchant key create|list merchant_id | merchant key revoke prefix | merchant secret rotate merchant_id")
+
+	if len(args) >= 2 && len(args) <= 4 && args[0] == "create" {
+		m := &model.Merchant{Name: args[1], CreatedAt: time.Now().UTC()}
+		if len(args) > 2 {
+			if !apiserver.IsCallbackURL(args[2]) {
+				return fmt.Errorf("invalid callback url %q", args[2])
+			}
+			if !config.Webhooks.AllowPrivateNetworks && !apiserver.IsPublicURL(args[2]) {
+				return fmt.Errorf("callback url %q points to a private network address", args[2])
+			}
+			m.CallbackURL = args[2]
+		}
+		if len(args) > 3 {
+			ttl, err := time.ParseDuration(args[3])
+			if err != nil {
+				return err
+			}
+			if ttl > config.MaxSessionTTL.Duration {
+				return fmt.Errorf("session ttl %v exceeds max_session_ttl %v", ttl, config.MaxSessionTTL.Duration)
+			}
+			m.SessionTTL = ttl
+		}
+
+		if err := st.Merchant().Create(m); err != nil {
+			return err
+		}
+		log.Printf("merchant %d %s created", m.MerchantID, m.Name)
+		log.Printf("webhook secret for merchant %d: %s", m.MerchantID, m.WebhookSecret)
+
+		return createKey(st, m)
+	}
+
+	if len(args) == 3 && args[0] == "secret" && args[1] == "rotate" {
+		m, err := findMerchant(st, args[2])
+		if err != nil {
+			return err
+		}
+		return rotateSecret(st, m)
+	}
+
+	if len(args) != 3 || args[0] != "key" {
+		return usage
+	}
+
+	switch args[1] {
+	case "create":
+		m, err := findMerchant(st, args[2])
+		if err != nil {
+			return err
+		}
+		return createKey(st, m)
+	case "list":
+		m, err := findMerchant(st, args[2])
+		if err != nil {
+			return err
+		}
+
+		keys, err := st.Merchant().FindKeys(m)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			status := "active"
+			if !k.IsActive() {
+				status = "revoked " + k.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%s\t%s\t%s\n", k.Prefix, k.CreatedAt.Format(time.RFC3339), status)
+		}
+		return nil
+	case "revoke":
+		k, err := st.Merchant().FindKey(args[2])
+		if err != nil {
+			return err
+		}
+		if err := st.Merchant().RevokeKey(k, time.Now().UTC()); err != nil {
+			return err
+		}
+		log.Printf("api key %s of merchant %d revoked", k.Prefix, k.MerchantID)
+		return nil
+	}
+
+	return usage
+}
+
+func findMerchant(st *sqlstore.Store, id string) (*model.Merchant, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merchant id %q", id)
+	}
+	return st.Merchant().Find(uint(n))
+}
+
+func createKey(st *sqlstore.Store, m *model.Merchant) error {
+	k, value, err := model.NewAPIKey(m.MerchantID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := st.Merchant().CreateKey(k); err != nil {
+		return err
+	}
+
+	log.Printf("api key %s for merchant %d created, it is shown only once", k.Prefix, m.MerchantID)
+	fmt.Println(value)
+	return nil
+}
+
+// Новый секрет сразу заменяет старый, в том числе для повторных попыток
+// уже поставленных в очередь уведомлений
+func rotateSecret(st *sqlstore.Store, m *model.Merchant) error {
+	secret, err := model.NewWebhookSecret()
+	if err != nil {
+		return err
+	}
+
+	m.WebhookSecret = secret
+	if err := st.Merchant().UpdateWebhookSecret(m); err != nil {
+		return err
+	}
+
+	log.Printf("webhook secret for merchant %d rotated", m.MerchantID)
+	fmt.Println(secret)
+	return nil
 }
@@ -10,4 +10,5 @@ require (
 	github.com/gorilla/mux v1.7.4
 	github.com/sirupsen/logrus v1.6.0
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 )
@@ -0,0 +1,48 @@
+package acquirer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+)
+
+const ProviderMock = "mock"
+
+var (
+	ErrUnknownAuthorization = errors.New("unknown authorization")
+	ErrInvalidAmount        = errors.New("amount exceeds the authorized amount")
+)
+
+type Acquirer interface {
+	Authorize(req *AuthorizeRequest) (*Result, error)
+	Capture(authorizationID string, amount model.Money) (*Result, error)
+	Void(authorizationID string) (*Result, error)
+	Refund(authorizationID string, amount model.Money) (*Result, error)
+}
+
+// Создает эквайер, выбранный в конфиге
+func New(config *Config) (Acquirer, error) {
+	switch config.Provider {
+	case ProviderMock:
+		return NewMock(config), nil
+	case "":
+		return nil, errors.New("acquirer provider is not configured")
+	}
+	return nil, fmt.Errorf("unknown acquirer provider %q", config.Provider)
+}
+
+type AuthorizeRequest struct {
+	Reference  string
+	CardNumber string
+	Date       string
+	Code       string
+	Amount     model.Money
+}
+
+// Результат операции у эквайера. Отказ (Approved == false) не является
+// ошибкой: ошибка означает, что операцию не удалось выполнить вовсе
+type Result struct {
+	Approved        bool
+	AuthorizationID string
+	DeclineReason   string
+}
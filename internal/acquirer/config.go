@@ -0,0 +1,19 @@
+package acquirer
+
+// Provider выбирает реализацию эквайера. Пока доступен только тестовый
+// эквайер mock, и он подключается лишь при явном указании в конфиге
+type Config struct {
+	Provider     string            `toml:"provider"`
+	DeclineCards map[string]string `toml:"decline_cards"`
+	MaxAmount    int64             `toml:"max_amount"`
+}
+
+func NewConfig() *Config {
+	return &Config{
+		DeclineCards: map[string]string{
+			"4000000000000002": "card_declined",
+			"4000000000009995": "insufficient_funds",
+			"4000000000000069": "expired_card",
+		},
+	}
+}
@@ -0,0 +1,106 @@
+package acquirer
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/google/uuid"
+	"regexp"
+	"sync"
+)
+
+var notNumberRegexp = regexp.MustCompile("[^0-9]+")
+
+type authorization struct {
+	amount   model.Money
+	captured int64
+	refunded int64
+	voided   bool
+}
+
+// Эквайер для локальной разработки и тестов: одобряет все операции,
+// кроме платежей тестовыми картами из DeclineCards и сумм больше MaxAmount
+type Mock struct {
+	config         *Config
+	mu             sync.Mutex
+	authorizations map[string]*authorization
+}
+
+func NewMock(config *Config) *Mock {
+	return &Mock{
+		config:         config,
+		authorizations: make(map[string]*authorization),
+	}
+}
+
+func (m *Mock) Authorize(req *AuthorizeRequest) (*Result, error) {
+	card := notNumberRegexp.ReplaceAllString(req.CardNumber, "")
+	if reason, ok := m.config.DeclineCards[card]; ok {
+		return &Result{DeclineReason: reason}, nil
+	}
+
+	if m.config.MaxAmount > 0 && req.Amount.Minor > m.config.MaxAmount {
+		return &Result{DeclineReason: "amount_limit_exceeded"}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := uuid.New().String()
+	m.authorizations[id] = &authorization{amount: req.Amount}
+
+	return &Result{Approved: true, AuthorizationID: id}, nil
+}
+
+func (m *Mock) Capture(authorizationID string, amount model.Money) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	auth, ok := m.authorizations[authorizationID]
+	if !ok {
+		return nil, ErrUnknownAuthorization
+	}
+
+	if auth.voided {
+		return &Result{AuthorizationID: authorizationID, DeclineReason: "authorization_voided"}, nil
+	}
+
+	if auth.captured+amount.Minor > auth.amount.Minor {
+		return nil, ErrInvalidAmount
+	}
+
+	auth.captured += amount.Minor
+	return &Result{Approved: true, AuthorizationID: authorizationID}, nil
+}
+
+func (m *Mock) Void(authorizationID string) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	auth, ok := m.authorizations[authorizationID]
+	if !ok {
+		return nil, ErrUnknownAuthorization
+	}
+
+	if auth.captured > 0 {
+		return &Result{AuthorizationID: authorizationID, DeclineReason: "authorization_captured"}, nil
+	}
+
+	auth.voided = true
+	return &Result{Approved: true, AuthorizationID: authorizationID}, nil
+}
+
+func (m *Mock) Refund(authorizationID string, amount model.Money) (*Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	auth, ok := m.authorizations[authorizationID]
+	if !ok {
+		return nil, ErrUnknownAuthorization
+	}
+
+	if auth.refunded+amount.Minor > auth.captured {
+		return nil, ErrInvalidAmount
+	}
+
+	auth.refunded += amount.Minor
+	return &Result{Approved: true, AuthorizationID: authorizationID}, nil
+}
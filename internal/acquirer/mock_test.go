@@ -0,0 +1,120 @@
+package acquirer_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/internal/acquirer"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Тестирование авторизации платежа тестовым эквайером
+func TestMock_Authorize(t *testing.T) {
+	config := acquirer.NewConfig()
+	config.MaxAmount = 100000
+
+	testCases := []struct {
+		name       string
+		cardNumber string
+		amount     int64
+		approved   bool
+		reason     string
+	}{
+		{
+			name:       "approved",
+			cardNumber: "4111 1111 1111 1111",
+			amount:     10000,
+			approved:   true,
+		},
+		{
+			name:       "declined card",
+			cardNumber: "4000 0000 0000 0002",
+			amount:     10000,
+			reason:     "card_declined",
+		},
+		{
+			name:       "insufficient funds",
+			cardNumber: "4000000000009995",
+			amount:     10000,
+			reason:     "insufficient_funds",
+		},
+		{
+			name:       "amount limit",
+			cardNumber: "4111 1111 1111 1111",
+			amount:     100001,
+			reason:     "amount_limit_exceeded",
+		},
+	}
+
+	m := acquirer.NewMock(config)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := m.Authorize(&acquirer.AuthorizeRequest{
+				CardNumber: tc.cardNumber,
+				Amount:     model.Money{Minor: tc.amount, Currency: "RUB"},
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.approved, res.Approved)
+			assert.Equal(t, tc.reason, res.DeclineReason)
+			if tc.approved {
+				assert.NotEmpty(t, res.AuthorizationID)
+			}
+		})
+	}
+}
+
+// Тестирование списания, отмены и возврата по авторизации
+func TestMock_CaptureVoidRefund(t *testing.T) {
+	m := acquirer.NewMock(acquirer.NewConfig())
+	amount := model.Money{Minor: 10000, Currency: "RUB"}
+
+	_, err := m.Capture("unknown", amount)
+	assert.Equal(t, acquirer.ErrUnknownAuthorization, err)
+
+	auth, err := m.Authorize(&acquirer.AuthorizeRequest{CardNumber: "4111111111111111", Amount: amount})
+	assert.NoError(t, err)
+
+	_, err = m.Refund(auth.AuthorizationID, amount)
+	assert.Equal(t, acquirer.ErrInvalidAmount, err)
+
+	res, err := m.Capture(auth.AuthorizationID, amount)
+	assert.NoError(t, err)
+	assert.True(t, res.Approved)
+
+	res, err = m.Void(auth.AuthorizationID)
+	assert.NoError(t, err)
+	assert.False(t, res.Approved)
+
+	res, err = m.Refund(auth.AuthorizationID, model.Money{Minor: 4000, Currency: "RUB"})
+	assert.NoError(t, err)
+	assert.True(t, res.Approved)
+
+	_, err = m.Refund(auth.AuthorizationID, amount)
+	assert.Equal(t, acquirer.ErrInvalidAmount, err)
+
+	auth, err = m.Authorize(&acquirer.AuthorizeRequest{CardNumber: "4111111111111111", Amount: amount})
+	assert.NoError(t, err)
+
+	res, err = m.Void(auth.AuthorizationID)
+	assert.NoError(t, err)
+	assert.True(t, res.Approved)
+
+	res, err = m.Capture(auth.AuthorizationID, amount)
+	assert.NoError(t, err)
+	assert.False(t, res.Approved)
+}
+
+// Тестирование выбора эквайера по конфигу
+func TestNew(t *testing.T) {
+	config := acquirer.NewConfig()
+	_, err := acquirer.New(config)
+	assert.Error(t, err)
+
+	config.Provider = "bank"
+	_, err = acquirer.New(config)
+	assert.Error(t, err)
+
+	config.Provider = acquirer.ProviderMock
+	acq, err := acquirer.New(config)
+	assert.NoError(t, err)
+	assert.IsType(t, &acquirer.Mock{}, acq)
+}
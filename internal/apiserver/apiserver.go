@@ -1,41 +1,141 @@
 package apiserver
 
 import (
+	"context"
+	"expvar"
 	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/internal/acquirer"
+	"github.com/bolshagin/xsolla-be-2020/internal/webhook"
+	"github.com/bolshagin/xsolla-be-2020/model"
 	"github.com/bolshagin/xsolla-be-2020/store"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
 )
 
 type APIServer struct {
-	config *Config
-	logger *logrus.Logger
-	router *mux.Router
-	store  *store.Store
+	config   *Config
+	logger   *logrus.Logger
+	router   *mux.Router
+	store    store.Store
+	acquirer acquirer.Acquirer
+	webhooks *webhook.Worker
+	events   *store.InProcessPublisher
+	relay    *store.Relay
+	keys     *keyring
+	keysErr  error
+	server   *http.Server
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
 }
 
-func New(config *Config) *APIServer {
-	return &APIServer{
-		config: config,
-		logger: logrus.New(),
-		router: mux.NewRouter(),
+// Эквайер передается снаружи, чтобы сервер не зависел от конкретного
+// шлюза: в main он выбирается по конфигу, в тестах подставляется свой
+func New(config *Config, st store.Store, acq acquirer.Acquirer) *APIServer {
+	s := &APIServer{
+		config:   config,
+		logger:   logrus.New(),
+		router:   mux.NewRouter(),
+		store:    st,
+		acquirer: acq,
+		events:   store.NewInProcessPublisher(),
 	}
+
+	s.webhooks = webhook.NewWorker(st.Webhook(), st.Merchant(), config.Webhooks.Secret, s.logger)
+	s.webhooks.MaxAttempts = config.Webhooks.MaxAttempts
+	s.webhooks.Backoff = config.Webhooks.RetryBackoff.Duration
+	s.webhooks.MaxBackoff = config.Webhooks.MaxBackoff.Duration
+	s.webhooks.Interval = config.Webhooks.PollInterval.Duration
+	s.webhooks.SetTimeout(config.Webhooks.Timeout.Duration)
+	s.webhooks.AllowPrivateNetworks = config.Webhooks.AllowPrivateNetworks
+
+	s.relay = store.NewRelay(st.Outbox(), s.events)
+	s.relay.Interval = config.OutboxInterval.Duration
+	s.relay.OnError = func(err error) {
+		s.logger.Error(err)
+	}
+	s.events.Subscribe(s.logEvent)
+	s.events.Subscribe(s.enqueueWebhook)
+
+	s.keys, s.keysErr = newKeyring(config.JWT)
+	if s.keysErr != nil {
+		s.keys = &keyring{}
+	} else if len(config.JWT.Keys) == 0 {
+		s.logger.Warn("jwt keys are not configured, tokens will be invalidated on restart")
+	}
+
+	s.server = &http.Server{
+		Addr:    config.BindAddr,
+		Handler: s,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	s.configureRouter()
+
+	return s
 }
 
 func (s *APIServer) Start() error {
 	if err := s.configureLogger(); err != nil {
 		return err
 	}
-	s.configureRouter()
+	if s.keysErr != nil {
+		return s.keysErr
+	}
+
+	s.logger.Info("starting api server")
+
+	s.goBackground(s.webhooks.Run)
+	s.goBackground(s.relay.Run)
+	s.goBackground(s.runSweeper)
 
-	if err := s.configureStore(); err != nil {
+	if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
+	return nil
+}
 
-	s.logger.Info("starting api server")
+// Останавливает прием запросов, дожидается завершения текущих
+// и фоновых задач, но не дольше, чем позволяет ctx
+func (s *APIServer) Shutdown(ctx context.Context) error {
+	s.logger.Info("shutting down api server")
+
+	err := s.server.Shutdown(ctx)
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (s *APIServer) goBackground(run func(ctx context.Context)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		run(s.ctx)
+	}()
+}
+
+// Издатель событий сессий из outbox, на который можно подписать
+// собственные обработчики до вызова Start
+func (s *APIServer) Events() *store.InProcessPublisher {
+	return s.events
+}
 
-	return http.ListenAndServe(s.config.BindAddr, s.router)
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
 }
 
 func (s *APIServer) configureLogger() error {
@@ -47,29 +147,25 @@ func (s *APIServer) configureLogger() error {
 	return nil
 }
 
-func (s *APIServer) configureStore() error {
-	st := store.New(s.config.Store)
-	cs := getConnectionString(s.config)
-
-	if err := st.Open(cs); err != nil {
-		return err
-	}
-	s.store = st
+func (s *APIServer) logEvent(e *model.OutboxEvent) error {
+	s.logger.Debug(fmt.Sprintf("event %v %v for session %v", e.EventID, e.EventType, e.SessionToken))
 	return nil
 }
 
 func (s *APIServer) configureRouter() {
-	s.router.HandleFunc("/session", s.handleSessionsCreate()).Methods("POST")
-	s.router.HandleFunc("/pay", s.handlePayment()).Methods("POST")
-	s.router.HandleFunc("/stat", checkJWTToken(s, s.handleSessionsStats())).Methods("GET")
-	s.router.HandleFunc("/get-token", s.handleTokenCreate()).Methods("GET")
-}
-
-func getConnectionString(config *Config) string {
-	return fmt.Sprintf(
-		"%s:%s@/%s?parseTime=true",
-		config.Store.User,
-		config.Store.Password,
-		config.Store.DBName,
-	)
+	s.router.HandleFunc("/session", checkAPIKey(s, withIdempotency(s, "session", s.handleSessionsCreate()))).Methods("POST")
+	s.router.HandleFunc("/session/{token}", s.handleSessionGet()).Methods("GET")
+	s.router.HandleFunc("/session/{token}/cancel", checkJWTToken(s, requireRole(s, manageRoles, s.handleSessionCancel()))).Methods("POST")
+	s.router.HandleFunc("/session/{token}/capture", checkJWTToken(s, requireRole(s, manageRoles, s.handleSessionCapture()))).Methods("POST")
+	s.router.HandleFunc("/session/{token}/void", checkJWTToken(s, requireRole(s, manageRoles, s.handleSessionVoid()))).Methods("POST")
+	s.router.HandleFunc("/session/{token}/refund", checkJWTToken(s, requireRole(s, refundRoles, s.handleSessionRefund()))).Methods("POST")
+	s.router.HandleFunc("/session/{token}/webhooks", checkJWTToken(s, requireRole(s, readRoles, s.handleSessionWebhooks()))).Methods("GET")
+	s.router.HandleFunc("/pay", withIdempotency(s, "pay", s.handlePayment())).Methods("POST")
+	s.router.HandleFunc("/stat", checkJWTToken(s, requireRole(s, statsRoles, s.handleSessionsStats()))).Methods("GET")
+	s.router.HandleFunc("/stat/summary", checkJWTToken(s, requireRole(s, statsRoles, s.handleSessionsSummary()))).Methods("GET")
+	s.router.HandleFunc("/auth/login", s.handleLogin()).Methods("POST")
+	s.router.HandleFunc("/auth/refresh", s.handleRefresh()).Methods("POST")
+	s.router.HandleFunc("/auth/logout", checkJWTToken(s, s.handleLogout())).Methods("POST")
+	s.router.HandleFunc("/.well-known/jwks.json", s.handleJWKS()).Methods("GET")
+	s.router.HandleFunc("/debug/vars", checkJWTToken(s, requireRole(s, adminRoles, expvar.Handler().ServeHTTP))).Methods("GET")
 }
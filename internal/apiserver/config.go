@@ -1,17 +1,132 @@
 package apiserver
 
-import "github.com/bolshagin/xsolla-be-2020/store"
+import (
+	"github.com/bolshagin/xsolla-be-2020/internal/acquirer"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"strings"
+	"time"
+)
 
 type Config struct {
-	BindAddr string `toml:"bind_addr"`
-	LogLevel string `toml:"log_level"`
+	BindAddr        string   `toml:"bind_addr"`
+	LogLevel        string   `toml:"log_level"`
+	Currencies      []string `toml:"currencies"`
+	DefaultCurrency string   `toml:"default_currency"`
+
+	SessionTTL        Duration `toml:"session_ttl"`
+	MaxSessionTTL     Duration `toml:"max_session_ttl"`
+	AuthorizationTTL  Duration `toml:"authorization_ttl"`
+	ProcessingTimeout Duration `toml:"processing_timeout"`
+	IdempotencyTTL    Duration `toml:"idempotency_ttl"`
+	OutboxInterval    Duration `toml:"outbox_interval"`
+	OutboxRetention   Duration `toml:"outbox_retention"`
+	SweepInterval     Duration `toml:"sweep_interval"`
+	AccessTokenTTL    Duration `toml:"access_token_ttl"`
+	RefreshTokenTTL   Duration `toml:"refresh_token_ttl"`
+	StatsMaxRange     Duration `toml:"stats_max_range"`
+
 	Store    *store.Config
+	Acquirer *acquirer.Config
+	Webhooks *WebhookConfig
+	JWT      *JWTConfig
+	Export   *ExportConfig
+}
+
+// Уведомления мерчанта о результате платежа. URL используется для сессий,
+// при создании которых не передан собственный callback_url. Уведомления
+// подписываются секретом мерчанта, Secret - только для сессий без мерчанта
+type WebhookConfig struct {
+	URL          string   `toml:"url"`
+	Secret       string   `toml:"secret"`
+	Timeout      Duration `toml:"timeout"`
+	MaxAttempts  int      `toml:"max_attempts"`
+	RetryBackoff Duration `toml:"retry_backoff"`
+	MaxBackoff   Duration `toml:"max_backoff"`
+	PollInterval Duration `toml:"poll_interval"`
+
+	AllowPrivateNetworks bool `toml:"allow_private_networks"`
+}
+
+// Ключи JWT. Токены подписываются ключом signing_key, а проверяются любым
+// из keys: при ротации новый ключ становится ключом подписи, а старый
+// остается в списке, пока не истекут выданные им токены
+type JWTConfig struct {
+	SigningKey string    `toml:"signing_key"`
+	Keys       []*JWTKey `toml:"keys"`
+}
+
+// Для HS256 задается secret, для RS256 и ES256 ключи в формате PEM строкой
+// или путем к файлу. Ключ только с открытой частью используется для проверки
+type JWTKey struct {
+	ID             string `toml:"kid"`
+	Algorithm      string `toml:"algorithm"`
+	Secret         string `toml:"secret"`
+	PrivateKey     string `toml:"private_key"`
+	PrivateKeyFile string `toml:"private_key_file"`
+	PublicKey      string `toml:"public_key"`
+	PublicKeyFile  string `toml:"public_key_file"`
+}
+
+// Выгрузка статистики в CSV: колонки и разделитель по умолчанию,
+// если они не переданы в запросе
+type ExportConfig struct {
+	Columns   []string `toml:"columns"`
+	Delimiter string   `toml:"delimiter"`
 }
 
 func NewConfig() *Config {
 	return &Config{
-		BindAddr: ":8080",
-		LogLevel: "debug",
+		BindAddr:        ":8080",
+		LogLevel:        "debug",
+		Currencies:      []string{"RUB", "USD", "EUR"},
+		DefaultCurrency: model.DefaultCurrency,
+
+		SessionTTL:        Duration{model.DefaultSessionTTL},
+		MaxSessionTTL:     Duration{24 * time.Hour},
+		AuthorizationTTL:  Duration{7 * 24 * time.Hour},
+		ProcessingTimeout: Duration{10 * time.Minute},
+		IdempotencyTTL:    Duration{24 * time.Hour},
+		OutboxInterval:    Duration{time.Second},
+		OutboxRetention:   Duration{7 * 24 * time.Hour},
+		SweepInterval:     Duration{time.Minute},
+		AccessTokenTTL:    Duration{15 * time.Minute},
+		RefreshTokenTTL:   Duration{30 * 24 * time.Hour},
+		StatsMaxRange:     Duration{366 * 24 * time.Hour},
+
 		Store:    store.NewConfig(),
+		Acquirer: acquirer.NewConfig(),
+		Webhooks: &WebhookConfig{
+			Timeout:      Duration{10 * time.Second},
+			MaxAttempts:  8,
+			RetryBackoff: Duration{10 * time.Second},
+			MaxBackoff:   Duration{time.Hour},
+			PollInterval: Duration{5 * time.Second},
+		},
+		JWT: &JWTConfig{},
+		Export: &ExportConfig{
+			Columns:   []string{"created_at", "amount", "currency", "purpose", "status", "closed_at"},
+			Delimiter: ";",
+		},
+	}
+}
+
+func (c *Config) isAllowedCurrency(currency string) bool {
+	for _, allowed := range c.Currencies {
+		if strings.EqualFold(allowed, currency) {
+			return true
+		}
 	}
+	return false
+}
+
+// Длительность в конфиге задается строкой в формате time.ParseDuration, например "15m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
 }
@@ -0,0 +1,165 @@
+package apiserver
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const csvContentType = "text/csv"
+
+var (
+	errInvalidFormat    = errors.New("format must be json or csv")
+	errInvalidDelimiter = errors.New("delimiter must be a single character other than a quote or a line break")
+)
+
+// Колонки выгрузки статистики в CSV
+var csvColumns = map[string]func(s *model.Session) string{
+	"merchant_id": func(s *model.Session) string {
+		return strconv.FormatUint(uint64(s.MerchantID), 10)
+	},
+	"amount": func(s *model.Session) string {
+		return s.Amount.String()
+	},
+	"currency": func(s *model.Session) string {
+		return s.Amount.Currency
+	},
+	"purpose": func(s *model.Session) string {
+		return s.Purpose
+	},
+	"status": func(s *model.Session) string {
+		return string(s.Status)
+	},
+	"capture_mode": func(s *model.Session) string {
+		return string(s.CaptureMode)
+	},
+	"decline_reason": func(s *model.Session) string {
+		return s.DeclineReason
+	},
+	"created_at": func(s *model.Session) string {
+		return s.CreatedAt.UTC().Format(time.RFC3339)
+	},
+	"expires_at": func(s *model.Session) string {
+		return s.ExpiresAt.UTC().Format(time.RFC3339)
+	},
+	"closed_at": func(s *model.Session) string {
+		if s.ClosedAt == nil {
+			return ""
+		}
+		return s.ClosedAt.UTC().Format(time.RFC3339)
+	},
+}
+
+type csvExport struct {
+	columns   []string
+	delimiter rune
+}
+
+// Статистика выгружается в CSV по параметру format=csv или по заголовку
+// Accept: text/csv. Явно переданный format важнее заголовка
+func wantsCSV(r *http.Request) (bool, error) {
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		return true, nil
+	case "json":
+		return false, nil
+	case "":
+	default:
+		return false, errInvalidFormat
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == csvContentType {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Колонки и разделитель передаются параметрами columns и delimiter,
+// без них используются значения из секции [export] конфига
+func (s *APIServer) parseExport(r *http.Request) (*csvExport, error) {
+	e := &csvExport{columns: append([]string(nil), s.config.Export.Columns...)}
+	if value := r.URL.Query().Get("columns"); value != "" {
+		e.columns = strings.Split(value, ",")
+	}
+	for i, column := range e.columns {
+		e.columns[i] = strings.TrimSpace(column)
+		if _, ok := csvColumns[e.columns[i]]; !ok {
+			return nil, fmt.Errorf("unknown csv column %q", e.columns[i])
+		}
+	}
+
+	delimiter := s.config.Export.Delimiter
+	if value, ok := r.URL.Query()["delimiter"]; ok {
+		delimiter = value[0]
+	}
+	if utf8.RuneCountInString(delimiter) != 1 {
+		return nil, errInvalidDelimiter
+	}
+	e.delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	if !validDelimiter(e.delimiter) {
+		return nil, errInvalidDelimiter
+	}
+
+	return e, nil
+}
+
+// Повторяет проверку разделителя в encoding/csv: недопустимый разделитель
+// csv.Writer отвергает уже после отправки заголовков ответа
+func validDelimiter(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+// Строки пишутся в ответ по мере чтения из хранилища, поэтому размер
+// выгрузки не ограничен памятью сервера. Ошибку, возникшую после начала
+// передачи, клиенту уже не сообщить: ответ обрывается, а ошибка пишется в лог
+func (s *APIServer) exportStats(w http.ResponseWriter, r *http.Request, q *store.StatsQuery, e *csvExport) {
+	w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="stat.csv"`)
+
+	writer := csv.NewWriter(w)
+	writer.Comma = e.delimiter
+
+	record := make([]string, len(e.columns))
+	if err := writer.Write(e.columns); err != nil {
+		s.logger.Error(err)
+		return
+	}
+
+	rows := 0
+	err := s.store.Session().StreamStats(s.scope(r), q, func(session *model.Session) error {
+		for i, column := range e.columns {
+			record[i] = csvColumns[column](session)
+		}
+		rows++
+		return writer.Write(record)
+	})
+
+	// Пока в ответ не записано ни одной строки, заголовок CSV лежит
+	// в буфере и ошибку хранилища еще можно вернуть обычным ответом
+	if err != nil && rows == 0 {
+		s.logger.Error(err)
+		w.Header().Del("Content-Type")
+		w.Header().Del("Content-Disposition")
+		s.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		s.logger.Error(err)
+	}
+}
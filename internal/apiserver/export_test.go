@@ -0,0 +1,68 @@
+package apiserver_test
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Тестирование выгрузки статистики в CSV
+func Test_StatsExportCSV(t *testing.T) {
+	s, st := newTestServer(t)
+	createSessionWith(t, s, `{"amount":10,"purpose":"first; with delimiter"}`)
+	createSessionWith(t, s, `{"amount":25.50,"currency":"USD","purpose":"second \"quoted\""}`)
+
+	token := "Bearer " + getToken(t, s, st)
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	data := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v","sort":"amount","limit":1}`, today, tomorrow))
+
+	read := func(body string, delimiter rune) [][]string {
+		reader := csv.NewReader(strings.NewReader(body))
+		reader.Comma = delimiter
+		records, err := reader.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	rec := doRequest(s, http.MethodGet, "/stat", data, map[string]string{"Authorization": token, "Accept": "text/csv"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	records := read(rec.Body.String(), ';')
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, []string{"created_at", "amount", "currency", "purpose", "status", "closed_at"}, records[0])
+		assert.Equal(t, []string{"10.00", "RUB", "first; with delimiter", "created", ""}, records[1][1:])
+		assert.Equal(t, []string{"25.50", "USD", `second "quoted"`, "created", ""}, records[2][1:])
+	}
+
+	rec = doRequest(s, http.MethodGet, "/stat?format=csv&columns=purpose,amount&delimiter=%09", data, map[string]string{"Authorization": token})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, [][]string{
+		{"purpose", "amount"},
+		{"first; with delimiter", "10.00"},
+		{`second "quoted"`, "25.50"},
+	}, read(rec.Body.String(), '\t'))
+
+	// Явно запрошенный json важнее заголовка Accept
+	rec = doRequest(s, http.MethodGet, "/stat?format=json", data, map[string]string{"Authorization": token, "Accept": "text/csv"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Disposition"))
+
+	for _, target := range []string{
+		"/stat?format=xml",
+		"/stat?format=csv&columns=amount,card_number",
+		"/stat?format=csv&delimiter=%22",
+		"/stat?format=csv&delimiter=%00",
+		"/stat?format=csv&delimiter=%3B%3B",
+	} {
+		rec := doRequest(s, http.MethodGet, target, data, map[string]string{"Authorization": token})
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
@@ -1,41 +1,90 @@
 package apiserver
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/internal/acquirer"
 	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
+const (
+	declineAcquirerError     = "acquirer_error"
+	declineProcessingTimeout = "processing_timeout"
+)
+
+type ctxKey int8
+
+const (
+	ctxKeyUser ctxKey = iota
+	ctxKeyClaims
+	ctxKeyMerchant
+)
+
+type tokenClaims struct {
+	Role model.Role `json:"role"`
+	jwt.StandardClaims
+}
+
 var (
-	zeroDate             = time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC)
-	sessDuration float64 = 60 * 15
-	layout               = "2006-01-02"
-	secretKey            = []byte("secretKey")
+	layout = "2006-01-02"
 )
 
 var (
-	errSessionExpired       = errors.New("session expired")
-	errSessionAlreadyClosed = errors.New("session already closed")
-	errTooLongPurpose       = errors.New("purpose must be less than 210 symbols")
-	errInvalidCardNum       = errors.New("invalid card number")
-	errInvalidCardDate      = errors.New("invalid card date")
-	errInvalidCardCode      = errors.New("invalid card code")
-	errNotAuthorized        = errors.New("not authorized")
-	errNotValidToken        = errors.New("not valid jwt-token")
-	errTokenIsExpired       = errors.New("jwt-token is expired")
-	errCantHandleToken      = errors.New("cant handle jwt-token")
+	errSessionExpired           = errors.New("session expired")
+	errSessionAlreadyClosed     = errors.New("session already closed")
+	errPaymentInProgress        = errors.New("session payment is already in progress")
+	errSessionCancelled         = errors.New("session cancelled by merchant")
+	errNothingToRefund          = errors.New("session is already fully refunded")
+	errPaymentDeclined          = errors.New("payment declined")
+	errRefundDeclined           = errors.New("refund declined")
+	errAcquirerFailed           = errors.New("acquirer is unavailable")
+	errInvalidCaptureMode       = errors.New("capture mode must be auto or manual")
+	errInvalidCallbackURL       = errors.New("callback url must be an absolute http or https url")
+	errPrivateCallbackURL       = errors.New("callback url must not point to a private network address")
+	errInvalidExpiresIn         = errors.New("expires_in must be positive and not exceed the maximum session ttl")
+	errSessionNotAuthorized     = errors.New("session is not authorized")
+	errCaptureInProgress        = errors.New("session capture is already in progress")
+	errAuthorizationExpired     = errors.New("authorization expired")
+	errCaptureExceedsAmount     = errors.New("capture exceeds the authorized amount")
+	errCaptureDeclined          = errors.New("capture declined")
+	errVoidDeclined             = errors.New("void declined")
+	errTooLongPurpose           = errors.New("purpose must be less than 210 symbols")
+	errCurrencyNotAllowed       = errors.New("currency is not supported")
+	errInvalidCardNum           = errors.New("invalid card number")
+	errInvalidCardDate          = errors.New("invalid card date")
+	errInvalidCardCode          = errors.New("invalid card code")
+	errNotAuthorized            = errors.New("not authorized")
+	errIncorrectEmailOrPassword = errors.New("incorrect email or password")
+	errNotValidToken            = errors.New("not valid jwt-token")
+	errTokenIsExpired           = errors.New("jwt-token is expired")
+	errCantHandleToken          = errors.New("cant handle jwt-token")
+	errTokenIsRevoked           = errors.New("jwt-token is revoked")
+	errInvalidRefreshToken      = errors.New("refresh token is invalid or expired")
+	errNoPeriod                 = errors.New("date_begin and date_end are required")
+	errInvalidDate              = errors.New("date must be in YYYY-MM-DD or RFC 3339 format")
+	errInvalidPeriod            = errors.New("date_begin must precede date_end")
+	errPeriodTooLong            = errors.New("period exceeds the maximum stats range")
 )
 
 func (s *APIServer) handleSessionsCreate() http.HandlerFunc {
 	type request struct {
-		Amount  float64 `json:"amount"`
-		Purpose string  `json:"purpose"`
+		Amount      json.Number       `json:"amount"`
+		Currency    string            `json:"currency"`
+		Purpose     string            `json:"purpose"`
+		CaptureMode model.CaptureMode `json:"capture_mode"`
+		CallbackURL string            `json:"callback_url"`
+		ExpiresIn   *int64            `json:"expires_in"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -52,13 +101,79 @@ func (s *APIServer) handleSessionsCreate() http.HandlerFunc {
 			return
 		}
 
+		currency := strings.ToUpper(strings.TrimSpace(req.Currency))
+		if currency == "" {
+			currency = strings.ToUpper(s.config.DefaultCurrency)
+		}
+
+		if !IsCurrencyCode(currency) || !s.config.isAllowedCurrency(currency) {
+			s.logger.Error(errCurrencyNotAllowed)
+			s.error(w, r, http.StatusBadRequest, errCurrencyNotAllowed)
+			return
+		}
+
+		amount, err := model.ParseMoney(req.Amount.String(), currency)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.CaptureMode == "" {
+			req.CaptureMode = model.CaptureAuto
+		}
+
+		if !req.CaptureMode.IsValid() {
+			s.logger.Error(errInvalidCaptureMode)
+			s.error(w, r, http.StatusBadRequest, errInvalidCaptureMode)
+			return
+		}
+
+		merchant := r.Context().Value(ctxKeyMerchant).(*model.Merchant)
+
+		ttl := s.config.SessionTTL.Duration
+		if merchant.SessionTTL > 0 {
+			ttl = merchant.SessionTTL
+		}
+		// Настройка мерчанта могла быть сохранена до уменьшения предела
+		if ttl > s.config.MaxSessionTTL.Duration {
+			s.logger.Warn(fmt.Sprintf("session ttl %v of merchant %v exceeds the maximum, using %v", ttl, merchant.MerchantID, s.config.MaxSessionTTL.Duration))
+			ttl = s.config.MaxSessionTTL.Duration
+		}
+		// Граница проверяется в секундах до перевода в Duration,
+		// иначе большое значение переполнит int64 и станет отрицательным
+		if req.ExpiresIn != nil {
+			if *req.ExpiresIn <= 0 || *req.ExpiresIn > int64(s.config.MaxSessionTTL.Duration/time.Second) {
+				s.logger.Error(errInvalidExpiresIn)
+				s.error(w, r, http.StatusBadRequest, errInvalidExpiresIn)
+				return
+			}
+			ttl = time.Duration(*req.ExpiresIn) * time.Second
+		}
+
+		if req.CallbackURL != "" && !IsCallbackURL(req.CallbackURL) {
+			s.logger.Error(errInvalidCallbackURL)
+			s.error(w, r, http.StatusBadRequest, errInvalidCallbackURL)
+			return
+		}
+
+		if req.CallbackURL != "" && !s.config.Webhooks.AllowPrivateNetworks && !IsPublicURL(req.CallbackURL) {
+			s.logger.Error(errPrivateCallbackURL)
+			s.error(w, r, http.StatusBadRequest, errPrivateCallbackURL)
+			return
+		}
+
 		session := &model.Session{
-			Amount:  req.Amount,
-			Purpose: req.Purpose,
+			MerchantID:  merchant.MerchantID,
+			Amount:      amount,
+			Purpose:     req.Purpose,
+			CaptureMode: req.CaptureMode,
+			CallbackURL: req.CallbackURL,
 		}
 
 		session.SessionToken = uuid.New().String()
 		session.CreatedAt = s.now()
+		session.ExpiresAt = session.CreatedAt.Add(ttl)
 
 		if err := s.store.Session().Create(session); err != nil {
 			s.logger.Error(err)
@@ -86,22 +201,43 @@ func (s *APIServer) handlePayment() http.HandlerFunc {
 			return
 		}
 
-		session, err := s.store.Session().FindByToken(req.SessionToken)
+		session, err := s.store.Session().FindByToken(store.AllMerchants(), req.SessionToken)
 		if err != nil {
 			s.logger.Error(err)
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.error(w, r, findErrorCode(err), err)
+			return
+		}
+
+		if session.Status == model.StatusExpired {
+			s.logger.Error(fmt.Sprintf("session token %v expired", session.SessionToken))
+			s.error(w, r, http.StatusBadRequest, errSessionExpired)
+			return
+		}
+
+		if session.Status == model.StatusCancelled {
+			s.logger.Error(errSessionCancelled)
+			s.error(w, r, http.StatusBadRequest, errSessionCancelled)
+			return
+		}
+
+		// Сессия не закрыта, но ее оплату уже выполняет другой запрос
+		if session.Status == model.StatusProcessing {
+			s.logger.Error(errPaymentInProgress)
+			s.error(w, r, http.StatusConflict, errPaymentInProgress)
 			return
 		}
 
-		if !isZeroDate(session.ClosedAt) {
+		if !session.Status.IsOpen() {
 			s.logger.Error(errSessionAlreadyClosed)
 			s.error(w, r, http.StatusBadRequest, errSessionAlreadyClosed)
 			return
 		}
 
 		closedAt := s.now()
-		delta := closedAt.Sub(session.CreatedAt)
-		if delta.Seconds() > sessDuration {
+		if isExpired(session, closedAt) {
+			if err := s.store.Session().UpdateStatus(session, model.StatusExpired, closedAt); err != nil {
+				s.logger.Error(err)
+			}
 			s.logger.Error(fmt.Sprintf("session token %v expired", session.SessionToken))
 			s.error(w, r, http.StatusBadRequest, errSessionExpired)
 			return
@@ -125,9 +261,69 @@ func (s *APIServer) handlePayment() http.HandlerFunc {
 			return
 		}
 
-		if err := s.store.Session().CommitSession(session, closedAt); err != nil {
+		if err := s.store.Session().UpdateStatus(session, model.StatusProcessing, closedAt); err != nil {
 			s.logger.Error(err)
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.statusError(w, r, err)
+			return
+		}
+
+		result, err := s.acquirer.Authorize(&acquirer.AuthorizeRequest{
+			Reference:  session.SessionToken,
+			CardNumber: req.CardNumber,
+			Date:       req.Date,
+			Code:       req.Code,
+			Amount:     session.Amount,
+		})
+		if err == nil && result.Approved {
+			authorizedAt := s.now()
+			session.AuthorizationID = result.AuthorizationID
+			session.AuthorizedAt = &authorizedAt
+			if session.CaptureMode != model.CaptureManual {
+				result, err = s.acquirer.Capture(session.AuthorizationID, session.Amount)
+				if err != nil || !result.Approved {
+					s.voidAuthorization(session)
+				}
+			}
+		}
+
+		if err != nil {
+			s.logger.Error(err)
+			session.DeclineReason = declineAcquirerError
+			if err := s.store.Session().UpdateStatus(session, model.StatusDeclined, s.now()); err != nil {
+				s.logger.Error(err)
+			}
+			s.error(w, r, http.StatusBadGateway, errAcquirerFailed)
+			return
+		}
+
+		if !result.Approved {
+			session.DeclineReason = result.DeclineReason
+			if err := s.store.Session().UpdateStatus(session, model.StatusDeclined, s.now()); err != nil {
+				s.logger.Error(err)
+				s.statusError(w, r, err)
+				return
+			}
+			s.logger.Info(fmt.Sprintf("payment for session %v declined: %v", session.SessionToken, result.DeclineReason))
+			s.declined(w, r, errPaymentDeclined, result.DeclineReason)
+			return
+		}
+
+		if session.CaptureMode == model.CaptureManual {
+			if err := s.store.Session().UpdateStatus(session, model.StatusAuthorized, s.now()); err != nil {
+				s.logger.Error(err)
+				s.statusError(w, r, err)
+				return
+			}
+
+			s.logger.Info(fmt.Sprintf("session %v authorized", session.SessionToken))
+			s.respond(w, r, http.StatusOK, map[string]string{"payment": "authorized"})
+			return
+		}
+
+		session.Captured = session.Amount
+		if err := s.store.Session().CommitSession(session, s.now()); err != nil {
+			s.logger.Error(err)
+			s.statusError(w, r, err)
 			return
 		}
 
@@ -136,102 +332,670 @@ func (s *APIServer) handlePayment() http.HandlerFunc {
 	}
 }
 
-func (s *APIServer) handleSessionsStats() http.HandlerFunc {
+func (s *APIServer) handleSessionGet() http.HandlerFunc {
+	type response struct {
+		SessionToken   string              `json:"session_token"`
+		Amount         model.Money         `json:"amount"`
+		Currency       string              `json:"currency"`
+		Purpose        string              `json:"purpose"`
+		Status         model.SessionStatus `json:"status"`
+		CaptureMode    model.CaptureMode   `json:"capture_mode"`
+		CapturedAmount model.Money         `json:"captured_amount"`
+		DeclineReason  string              `json:"decline_reason,omitempty"`
+		CreatedAt      time.Time           `json:"created_at"`
+		AuthorizedAt   *time.Time          `json:"authorized_at,omitempty"`
+		ClosedAt       *time.Time          `json:"closed_at,omitempty"`
+		ExpiresAt      time.Time           `json:"expires_at"`
+		ExpiresIn      int64               `json:"expires_in"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+
+		session, err := s.store.Session().FindByToken(store.AllMerchants(), token)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, findErrorCode(err), err)
+			return
+		}
+
+		now := s.now()
+		if session.Status.IsOpen() && isExpired(session, now) {
+			if err := s.store.Session().UpdateStatus(session, model.StatusExpired, now); err != nil {
+				s.logger.Error(err)
+			}
+		}
+		s.expireAuthorization(session, now)
+
+		var expiresIn int64
+		if session.Status.IsOpen() && session.ExpiresAt.After(now) {
+			expiresIn = int64(session.ExpiresAt.Sub(now).Seconds())
+		}
+
+		s.respond(w, r, http.StatusOK, &response{
+			SessionToken:   session.SessionToken,
+			Amount:         session.Amount,
+			Currency:       session.Amount.Currency,
+			Purpose:        session.Purpose,
+			Status:         session.Status,
+			CaptureMode:    session.CaptureMode,
+			CapturedAmount: session.Captured,
+			DeclineReason:  session.DeclineReason,
+			CreatedAt:      session.CreatedAt,
+			AuthorizedAt:   session.AuthorizedAt,
+			ClosedAt:       session.ClosedAt,
+			ExpiresAt:      session.ExpiresAt,
+			ExpiresIn:      expiresIn,
+		})
+	}
+}
+
+func (s *APIServer) handleSessionCancel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+
+		session, err := s.store.Session().FindByToken(s.scope(r), token)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, findErrorCode(err), err)
+			return
+		}
+
+		now := s.now()
+		if session.Status.IsOpen() && isExpired(session, now) {
+			if err := s.store.Session().UpdateStatus(session, model.StatusExpired, now); err != nil {
+				s.logger.Error(err)
+			}
+			s.logger.Error(fmt.Sprintf("session token %v expired", session.SessionToken))
+			s.error(w, r, http.StatusBadRequest, errSessionExpired)
+			return
+		}
+
+		if err := s.store.Session().UpdateStatus(session, model.StatusCancelled, now); err != nil {
+			s.logger.Error(err)
+			s.statusError(w, r, err)
+			return
+		}
+
+		s.logger.Info(fmt.Sprintf("session %v cancelled", session.SessionToken))
+		s.respond(w, r, http.StatusOK, map[string]string{"status": string(session.Status)})
+	}
+}
+
+func (s *APIServer) handleSessionRefund() http.HandlerFunc {
 	type request struct {
-		DateBegin string `json:"date_begin"`
-		DateEnd   string `json:"date_end"`
+		Amount json.Number `json:"amount"`
+	}
+
+	type response struct {
+		RefundID      uint                `json:"refund_id"`
+		Amount        model.Money         `json:"amount"`
+		Currency      string              `json:"currency"`
+		RefundedTotal model.Money         `json:"refunded_total"`
+		Status        model.SessionStatus `json:"status"`
+		CreatedAt     time.Time           `json:"created_at"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
 			s.logger.Error(err)
 			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		dateB, dateE, err := s.parseDates(req.DateBegin, req.DateEnd)
+		session, err := s.store.Session().FindByToken(s.scope(r), mux.Vars(r)["token"])
 		if err != nil {
 			s.logger.Error(err)
-			s.error(w, r, http.StatusBadRequest, err)
+			s.error(w, r, findErrorCode(err), err)
+			return
+		}
+
+		refunds, err := s.store.Refund().FindBySession(session)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		var reserved int64
+		for _, rf := range refunds {
+			if rf.IsReserved() {
+				reserved += rf.Amount.Minor
+			}
+		}
+
+		// Без суммы возвращается весь оставшийся остаток
+		amount := model.Money{Minor: session.Captured.Minor - reserved, Currency: session.Amount.Currency}
+		if req.Amount != "" {
+			amount, err = model.ParseMoney(req.Amount.String(), session.Amount.Currency)
+			if err != nil {
+				s.logger.Error(err)
+				s.error(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if amount.Minor <= 0 {
+			s.logger.Error(errNothingToRefund)
+			s.error(w, r, http.StatusBadRequest, errNothingToRefund)
+			return
+		}
+
+		// Остаток проверяется в хранилище под блокировкой сессии: возврат
+		// резервируется до обращения к эквайеру, поэтому параллельный
+		// запрос не сможет отправить эквайеру сумму сверх остатка
+		refund := &model.Refund{
+			Amount:    amount,
+			CreatedAt: s.now(),
+		}
+
+		if err := s.store.Refund().Create(session, refund); err != nil {
+			s.logger.Error(err)
+			switch err {
+			case store.ErrSessionNotPaid, store.ErrRefundExceedsAmount:
+				s.error(w, r, http.StatusBadRequest, err)
+			default:
+				s.error(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		// Сессии, оплаченные до подключения эквайера, не имеют авторизации,
+		// возврат по ним только фиксируется в хранилище
+		if session.AuthorizationID != "" {
+			result, err := s.acquirer.Refund(session.AuthorizationID, amount)
+			if err != nil {
+				s.logger.Error(err)
+				s.failRefund(refund)
+				s.error(w, r, http.StatusBadGateway, errAcquirerFailed)
+				return
+			}
+			if !result.Approved {
+				s.logger.Info(fmt.Sprintf("refund for session %v declined: %v", session.SessionToken, result.DeclineReason))
+				s.failRefund(refund)
+				s.declined(w, r, errRefundDeclined, result.DeclineReason)
+				return
+			}
+		}
+
+		// Эквайер уже вернул деньги, поэтому незавершенный возврат остается
+		// в статусе pending для сверки, а не освобождает сумму
+		if err := s.store.Refund().Complete(session, refund); err != nil {
+			s.logger.Error(fmt.Sprintf("refund %v of session %v approved by acquirer but not completed: %v", refund.RefundID, session.SessionToken, err))
+			s.error(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		var sessions []model.Session
-		sessions, err = s.store.Session().GetStats(dateB, dateE)
+		refunds, err = s.store.Refund().FindBySession(session)
 		if err != nil {
 			s.logger.Error(err)
 			s.error(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		s.respond(w, r, http.StatusOK, sessions)
+		total := model.Money{Currency: refund.Amount.Currency}
+		for _, rf := range refunds {
+			if rf.Status == model.RefundSucceeded {
+				total.Minor += rf.Amount.Minor
+			}
+		}
+
+		s.logger.Info(fmt.Sprintf("refund %v of session %v created", refund.RefundID, session.SessionToken))
+		s.respond(w, r, http.StatusCreated, &response{
+			RefundID:      refund.RefundID,
+			Amount:        refund.Amount,
+			Currency:      refund.Amount.Currency,
+			RefundedTotal: total,
+			Status:        session.Status,
+			CreatedAt:     refund.CreatedAt,
+		})
 	}
 }
 
-func (s *APIServer) handleTokenCreate() http.HandlerFunc {
+func (s *APIServer) handleSessionCapture() http.HandlerFunc {
+	type request struct {
+		Amount json.Number `json:"amount"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		claims := &jwt.MapClaims{
-			"exp":  time.Now().Add(time.Hour * time.Duration(1)).Unix(),
-			"user": "Default User",
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		session, ok := s.findAuthorized(w, r)
+		if !ok {
+			return
+		}
+
+		// Без суммы списывается вся авторизованная сумма
+		amount := session.Amount
+		if req.Amount != "" {
+			var err error
+			amount, err = model.ParseMoney(req.Amount.String(), session.Amount.Currency)
+			if err != nil {
+				s.logger.Error(err)
+				s.error(w, r, http.StatusBadRequest, err)
+				return
+			}
 		}
 
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenS, err := token.SignedString(secretKey)
+		if amount.Minor > session.Amount.Minor {
+			s.logger.Error(errCaptureExceedsAmount)
+			s.error(w, r, http.StatusBadRequest, errCaptureExceedsAmount)
+			return
+		}
+
+		// Сессия захватывается до обращения к эквайеру: параллельный
+		// запрос получит конфликт и не спишет деньги второй раз
+		if err := s.store.Session().UpdateStatus(session, model.StatusCapturing, s.now()); err != nil {
+			s.logger.Error(err)
+			s.statusError(w, r, err)
+			return
+		}
+
+		result, err := s.acquirer.Capture(session.AuthorizationID, amount)
 		if err != nil {
+			s.logger.Error(err)
+			s.releaseCapture(session)
+			s.error(w, r, http.StatusBadGateway, errAcquirerFailed)
+			return
+		}
+
+		if !result.Approved {
+			s.logger.Info(fmt.Sprintf("capture for session %v declined: %v", session.SessionToken, result.DeclineReason))
+			s.releaseCapture(session)
+			s.declined(w, r, errCaptureDeclined, result.DeclineReason)
+			return
+		}
+
+		session.Captured = amount
+		if err := s.store.Session().CommitSession(session, s.now()); err != nil {
+			s.logger.Error(err)
+			s.statusError(w, r, err)
+			return
+		}
+
+		s.logger.Info(fmt.Sprintf("session %v captured %v", session.SessionToken, amount))
+		s.respond(w, r, http.StatusOK, map[string]interface{}{
+			"status":          session.Status,
+			"captured_amount": session.Captured,
+		})
+	}
+}
+
+func (s *APIServer) handleSessionVoid() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, ok := s.findAuthorized(w, r)
+		if !ok {
+			return
+		}
+
+		result, err := s.acquirer.Void(session.AuthorizationID)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadGateway, errAcquirerFailed)
+			return
+		}
+
+		if !result.Approved {
+			s.logger.Info(fmt.Sprintf("void for session %v declined: %v", session.SessionToken, result.DeclineReason))
+			s.declined(w, r, errVoidDeclined, result.DeclineReason)
+			return
+		}
+
+		if err := s.store.Session().UpdateStatus(session, model.StatusVoided, s.now()); err != nil {
+			s.logger.Error(err)
+			s.statusError(w, r, err)
+			return
+		}
+
+		s.logger.Info(fmt.Sprintf("session %v voided", session.SessionToken))
+		s.respond(w, r, http.StatusOK, map[string]string{"status": string(session.Status)})
+	}
+}
+
+// Возвращает сессию в authorized, если эквайер не списал деньги
+func (s *APIServer) releaseCapture(session *model.Session) {
+	if err := s.store.Session().UpdateStatus(session, model.StatusAuthorized, s.now()); err != nil {
+		s.logger.Error(fmt.Sprintf("session %v is left in capturing: %v", session.SessionToken, err))
+	}
+}
+
+// Освобождает сумму возврата, который эквайер отклонил или не выполнил
+func (s *APIServer) failRefund(refund *model.Refund) {
+	if err := s.store.Refund().Fail(refund); err != nil {
+		s.logger.Error(err)
+	}
+}
+
+// Находит сессию по токену из пути и проверяет, что по ней есть
+// действующая авторизация. При ошибке ответ уже отправлен клиенту
+func (s *APIServer) findAuthorized(w http.ResponseWriter, r *http.Request) (*model.Session, bool) {
+	session, err := s.store.Session().FindByToken(s.scope(r), mux.Vars(r)["token"])
+	if err != nil {
+		s.logger.Error(err)
+		s.error(w, r, findErrorCode(err), err)
+		return nil, false
+	}
+
+	if s.expireAuthorization(session, s.now()) {
+		s.logger.Error(errAuthorizationExpired)
+		s.error(w, r, http.StatusBadRequest, errAuthorizationExpired)
+		return nil, false
+	}
+
+	if session.Status == model.StatusCapturing {
+		s.logger.Error(errCaptureInProgress)
+		s.error(w, r, http.StatusConflict, errCaptureInProgress)
+		return nil, false
+	}
+
+	if session.Status != model.StatusAuthorized {
+		s.logger.Error(errSessionNotAuthorized)
+		s.error(w, r, http.StatusBadRequest, errSessionNotAuthorized)
+		return nil, false
+	}
+
+	return session, true
+}
+
+// Отменяет авторизацию у эквайера, чтобы средства покупателя не оставались
+// заблокированными. Идентификатор авторизации остается в сессии для сверки,
+// если отмена не прошла
+func (s *APIServer) voidAuthorization(session *model.Session) {
+	result, err := s.acquirer.Void(session.AuthorizationID)
+	if err != nil {
+		s.logger.Error(fmt.Sprintf("void of authorization %v for session %v failed: %v", session.AuthorizationID, session.SessionToken, err))
+		return
+	}
+	if !result.Approved {
+		s.logger.Error(fmt.Sprintf("void of authorization %v for session %v declined: %v", session.AuthorizationID, session.SessionToken, result.DeclineReason))
+	}
+}
+
+// Просроченная авторизация отменяется у эквайера, а сессия переводится в expired
+func (s *APIServer) expireAuthorization(session *model.Session, now time.Time) bool {
+	if session.Status != model.StatusAuthorized || session.AuthorizedAt == nil {
+		return false
+	}
+
+	if now.Sub(*session.AuthorizedAt) <= s.config.AuthorizationTTL.Duration {
+		return false
+	}
+
+	if err := s.closeAuthorization(session, now); err != nil {
+		s.logger.Error(err)
+	}
+
+	return true
+}
+
+func (s *APIServer) closeAuthorization(session *model.Session, now time.Time) error {
+	s.voidAuthorization(session)
+
+	return s.store.Session().UpdateStatus(session, model.StatusExpired, now)
+}
+
+func (s *APIServer) handleLogin() http.HandlerFunc {
+	type request struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		u, err := s.store.User().FindByEmail(req.Email)
+		if err != nil && err != store.ErrNoUser {
 			s.logger.Error(err)
 			s.error(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		s.logger.Info(fmt.Sprintf("create jwt token %v", tokenS))
-		s.respond(w, r, http.StatusCreated, map[string]string{"jwt_token": tokenS})
+		if u == nil || !u.ComparePassword(req.Password) {
+			s.logger.Error(errIncorrectEmailOrPassword)
+			s.error(w, r, http.StatusUnauthorized, errIncorrectEmailOrPassword)
+			return
+		}
+
+		s.logger.Info(fmt.Sprintf("user %v logged in", u.UserID))
+		s.issueTokens(w, r, u)
 	}
 }
 
-func checkJWTToken(s *APIServer, next http.HandlerFunc) http.HandlerFunc {
+// Выдает новую пару токенов по токену обновления. Токен обновления
+// одноразовый: при использовании он отзывается, а повторное предъявление
+// отозванного токена считается утечкой и отзывает все токены пользователя
+func (s *APIServer) handleRefresh() http.HandlerFunc {
+	type request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		tokenH := r.Header.Get("Authorization")
-		s.logger.Info(fmt.Sprintf("try authorizate with token header `%v`", tokenH))
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
 
-		auth := strings.SplitN(tokenH, " ", 2)
-		if len(auth) != 2 {
-			s.logger.Error(errNotAuthorized)
-			s.error(w, r, http.StatusUnauthorized, errNotAuthorized)
+		now := s.now()
+		t, err := s.store.Token().FindRefresh(model.HashToken(req.RefreshToken))
+		if err != nil {
+			s.logger.Error(err)
+			if err == store.ErrNoRefreshToken {
+				s.error(w, r, http.StatusUnauthorized, errInvalidRefreshToken)
+				return
+			}
+			s.error(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		claims := &jwt.MapClaims{
-			"exp":  time.Now().Add(time.Hour * time.Duration(1)).Unix(),
-			"user": "Default User",
+		if t.RevokedAt != nil {
+			s.logger.Warn(fmt.Sprintf("revoked refresh token %v reused, revoking all tokens of user %v", t.TokenID, t.UserID))
+			if err := s.store.Token().RevokeUserRefresh(t.UserID, now); err != nil {
+				s.logger.Error(err)
+			}
 		}
 
-		var tokenS = auth[1]
-		token, err := jwt.ParseWithClaims(tokenS, claims, func(token *jwt.Token) (interface{}, error) {
-			return secretKey, nil
-		})
+		if !t.IsActive(now) {
+			s.logger.Error(errInvalidRefreshToken)
+			s.error(w, r, http.StatusUnauthorized, errInvalidRefreshToken)
+			return
+		}
 
-		if token.Valid {
-			next(w, r)
-		} else if ve, ok := err.(*jwt.ValidationError); ok {
-			if ve.Errors&jwt.ValidationErrorMalformed != 0 {
-				s.logger.Error(errNotValidToken)
-				s.error(w, r, http.StatusUnauthorized, errNotValidToken)
+		u, err := s.store.User().Find(t.UserID)
+		if err != nil {
+			s.logger.Error(err)
+			if err == store.ErrNoUser {
+				s.error(w, r, http.StatusUnauthorized, errInvalidRefreshToken)
 				return
-			} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
-				s.logger.Error(errTokenIsExpired)
-				s.error(w, r, http.StatusUnauthorized, errTokenIsExpired)
+			}
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := s.store.Token().RevokeRefresh(t, now); err != nil {
+			s.logger.Error(err)
+			if err == store.ErrRefreshTokenRevoked || err == store.ErrNoRefreshToken {
+				s.error(w, r, http.StatusUnauthorized, errInvalidRefreshToken)
 				return
-			} else {
-				s.logger.Error(errCantHandleToken)
-				s.error(w, r, http.StatusUnauthorized, errCantHandleToken)
+			}
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.logger.Info(fmt.Sprintf("user %v refreshed tokens", u.UserID))
+		s.issueTokens(w, r, u)
+	}
+}
+
+// Отзывает токен доступа, с которым выполнен запрос, и переданный
+// в теле токен обновления
+func (s *APIServer) handleLogout() http.HandlerFunc {
+	type request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		u := r.Context().Value(ctxKeyUser).(*model.User)
+		claims := r.Context().Value(ctxKeyClaims).(*tokenClaims)
+
+		if err := s.store.Token().Revoke(claims.Id, time.Unix(claims.ExpiresAt, 0).UTC()); err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if req.RefreshToken != "" {
+			t, err := s.store.Token().FindRefresh(model.HashToken(req.RefreshToken))
+			if err != nil && err != store.ErrNoRefreshToken {
+				s.logger.Error(err)
+				s.error(w, r, http.StatusInternalServerError, err)
 				return
 			}
-		} else {
+
+			// Чужой или уже отозванный токен обновления не считается ошибкой
+			if t != nil && t.UserID == u.UserID {
+				if err := s.store.Token().RevokeRefresh(t, s.now()); err != nil && err != store.ErrRefreshTokenRevoked {
+					s.logger.Error(err)
+					s.error(w, r, http.StatusInternalServerError, err)
+					return
+				}
+			}
+		}
+
+		s.logger.Info(fmt.Sprintf("user %v logged out", u.UserID))
+		s.respond(w, r, http.StatusNoContent, nil)
+	}
+}
+
+// Подписывает токен доступа и сохраняет новый токен обновления пользователя
+func (s *APIServer) issueTokens(w http.ResponseWriter, r *http.Request, u *model.User) {
+	now := s.now()
+	claims := &tokenClaims{
+		Role: u.Role,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   strconv.FormatUint(uint64(u.UserID), 10),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.config.AccessTokenTTL.Duration).Unix(),
+		},
+	}
+
+	tokenS, err := s.keys.sign(claims)
+	if err != nil {
+		s.logger.Error(err)
+		s.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	refresh, refreshS, err := model.NewRefreshToken(u.UserID, now, s.config.RefreshTokenTTL.Duration)
+	if err != nil {
+		s.logger.Error(err)
+		s.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := s.store.Token().CreateRefresh(refresh); err != nil {
+		s.logger.Error(err)
+		s.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respond(w, r, http.StatusOK, map[string]interface{}{
+		"jwt_token":     tokenS,
+		"refresh_token": refreshS,
+		"expires_in":    int64(s.config.AccessTokenTTL.Seconds()),
+	})
+}
+
+func checkJWTToken(s *APIServer, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+		if len(auth) != 2 {
+			s.logger.Error(errNotAuthorized)
+			s.error(w, r, http.StatusUnauthorized, errNotAuthorized)
+			return
+		}
+
+		claims := &tokenClaims{}
+		token, err := jwt.ParseWithClaims(auth[1], claims, s.keys.keyFunc)
+
+		if err != nil || !token.Valid {
+			if ve, ok := err.(*jwt.ValidationError); ok {
+				if ve.Errors&jwt.ValidationErrorMalformed != 0 {
+					s.logger.Error(errNotValidToken)
+					s.error(w, r, http.StatusUnauthorized, errNotValidToken)
+					return
+				} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
+					s.logger.Error(errTokenIsExpired)
+					s.error(w, r, http.StatusUnauthorized, errTokenIsExpired)
+					return
+				}
+			}
 			s.logger.Error(errCantHandleToken)
 			s.error(w, r, http.StatusUnauthorized, errCantHandleToken)
 			return
 		}
+
+		if claims.Id == "" {
+			s.logger.Error(errNotValidToken)
+			s.error(w, r, http.StatusUnauthorized, errNotValidToken)
+			return
+		}
+
+		revoked, err := s.store.Token().IsRevoked(claims.Id)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if revoked {
+			s.logger.Error(errTokenIsRevoked)
+			s.error(w, r, http.StatusUnauthorized, errTokenIsRevoked)
+			return
+		}
+
+		// Токен действует, только пока существует пользователь из subject
+		id, err := strconv.ParseUint(claims.Subject, 10, 32)
+		if err != nil {
+			s.logger.Error(errNotValidToken)
+			s.error(w, r, http.StatusUnauthorized, errNotValidToken)
+			return
+		}
+
+		u, err := s.store.User().Find(uint(id))
+		if err != nil {
+			s.logger.Error(err)
+			if err == store.ErrNoUser {
+				s.error(w, r, http.StatusUnauthorized, errNotAuthorized)
+				return
+			}
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.logger.Info(fmt.Sprintf("authorized user %v", u.UserID))
+		ctx := context.WithValue(r.Context(), ctxKeyUser, u)
+		ctx = context.WithValue(ctx, ctxKeyClaims, claims)
+		next(w, r.WithContext(ctx))
 	}
 }
 
@@ -251,21 +1015,75 @@ func (s *APIServer) now() time.Time {
 	return time.Now().In(loc)
 }
 
-func (s *APIServer) parseDates(begin, end string) (time.Time, time.Time, error) {
-	dateB, err := time.Parse(layout, begin)
+// Период статистики полуоткрытый: начало входит в него, конец нет.
+// Дата без времени в date_end означает конец этого дня, поэтому
+// день окончания попадает в период целиком
+func (s *APIServer) parseDates(begin, end string, loc *time.Location) (time.Time, time.Time, error) {
+	if begin == "" || end == "" {
+		return time.Time{}, time.Time{}, errNoPeriod
+	}
+
+	dateB, _, err := parseDate(begin, loc)
 	if err != nil {
-		return zeroDate, zeroDate, err
+		return time.Time{}, time.Time{}, err
 	}
-	dateE, err := time.Parse(layout, end)
+	dateE, dateOnly, err := parseDate(end, loc)
 	if err != nil {
-		return zeroDate, zeroDate, err
+		return time.Time{}, time.Time{}, err
+	}
+	if dateOnly {
+		dateE = dateE.AddDate(0, 0, 1)
 	}
+
+	if !dateB.Before(dateE) {
+		return time.Time{}, time.Time{}, errInvalidPeriod
+	}
+	if max := s.config.StatsMaxRange.Duration; max > 0 && dateE.Sub(dateB) > max {
+		return time.Time{}, time.Time{}, errPeriodTooLong
+	}
+
 	return dateB, dateE, nil
 }
 
-func isZeroDate(t time.Time) bool {
-	if t.Equal(zeroDate) {
-		return true
+// Дата принимается в формате RFC 3339 или 2006-01-02. Дата без времени
+// отсчитывается от полуночи по местному времени loc
+func parseDate(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+		return t, true, nil
 	}
-	return false
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, errInvalidDate
+	}
+	return t, false, nil
+}
+
+func (s *APIServer) declined(w http.ResponseWriter, r *http.Request, err error, reason string) {
+	s.respond(w, r, http.StatusPaymentRequired, map[string]string{
+		"error":          err.Error(),
+		"decline_reason": reason,
+	})
+}
+
+func (s *APIServer) statusError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case store.ErrSessionConflict:
+		s.error(w, r, http.StatusConflict, err)
+	case store.ErrInvalidTransition:
+		s.error(w, r, http.StatusBadRequest, errSessionAlreadyClosed)
+	default:
+		s.error(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func findErrorCode(err error) int {
+	if err == store.ErrNoSession {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func isExpired(session *model.Session, now time.Time) bool {
+	return now.After(session.ExpiresAt)
 }
@@ -3,52 +3,421 @@ package apiserver_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/internal/acquirer"
+	"github.com/bolshagin/xsolla-be-2020/internal/apiserver"
 	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 )
 
 var (
-	client     = http.Client{Timeout: 5 * time.Second}
-	session    = &model.Session{}
-	amount     = 100.0
+	amount     = "100.10"
 	purpose    = "test"
 	cardNumber = "4111 1111 1111 1111"
 	cardCode   = "325"
 	cardDate   = "12/23"
+
+	userEmail    = "admin@example.org"
+	userPassword = "password"
+	jwtSecret    = "secret"
+	apiKey       = "sk_000000000001_test-secret"
 )
 
+// Вспомогательная функция для создания сервера с хранилищем в памяти
+func newTestServer(t *testing.T) (*apiserver.APIServer, *teststore.Store) {
+	t.Helper()
+	st := teststore.New()
+	newTestMerchant(t, st)
+	return newServer(newTestConfig(), st), st
+}
+
+// Вспомогательная функция для создания сервера с тестовым эквайером
+func newServer(config *apiserver.Config, st store.Store) *apiserver.APIServer {
+	return apiserver.New(config, st, acquirer.NewMock(config.Acquirer))
+}
+
+// Вспомогательная функция, публикующая накопленные события outbox
+// подписчикам сервера так же, как фоновое реле
+func drainEvents(t *testing.T, s *apiserver.APIServer, st store.Store) {
+	t.Helper()
+	if _, err := store.NewRelay(st.Outbox(), s.Events()).Drain(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Вспомогательная функция для создания тестового мерчанта с API-ключом apiKey,
+// мерчант создается при первом вызове
+func newTestMerchant(t *testing.T, st store.Store) *model.Merchant {
+	t.Helper()
+
+	prefix, _ := model.APIKeyPrefix(apiKey)
+	if key, err := st.Merchant().FindKey(prefix); err == nil {
+		m, err := st.Merchant().Find(key.MerchantID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	m := &model.Merchant{Name: "test", CreatedAt: time.Now().UTC()}
+	if err := st.Merchant().Create(m); err != nil {
+		t.Fatal(err)
+	}
+
+	key := &model.APIKey{MerchantID: m.MerchantID, Prefix: prefix, KeyHash: model.HashToken(apiKey), CreatedAt: m.CreatedAt}
+	if err := st.Merchant().CreateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// Конфиг тестового сервера с известным ключом подписи JWT
+func newTestConfig() *apiserver.Config {
+	config := apiserver.NewConfig()
+	config.JWT = &apiserver.JWTConfig{
+		Keys: []*apiserver.JWTKey{{ID: "test", Algorithm: "HS256", Secret: jwtSecret}},
+	}
+	return config
+}
+
+// Вспомогательная функция для выполнения запроса к серверу
+func doRequest(s http.Handler, method, target string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewBuffer(body))
+	req.Header.Set("Content-type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+// Вспомогательная функция для создания платежной сессии через /session
+func createSession(t *testing.T, s http.Handler) *model.Session {
+	t.Helper()
+	return createSessionWith(t, s, fmt.Sprintf(`{"amount":%v,"purpose":"%v"}`, amount, purpose))
+}
+
+// Вспомогательная функция для создания платежной сессии с произвольными параметрами
+func createSessionWith(t *testing.T, s http.Handler, payload string) *model.Session {
+	t.Helper()
+
+	rec := doRequest(s, http.MethodPost, "/session", []byte(payload), map[string]string{"X-Api-Key": apiKey})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("unexpected status %v: %v", rec.Code, rec.Body.String())
+	}
+
+	session := &model.Session{}
+	if err := json.NewDecoder(rec.Body).Decode(session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// Вспомогательная функция для получения JWT-токена администратора через /auth/login
+func getToken(t *testing.T, s http.Handler, st store.Store) string {
+	t.Helper()
+	return getTokenWithRole(t, s, st, model.RoleAdmin)
+}
+
+type tokenResponse struct {
+	JWTToken     string `json:"jwt_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Вспомогательная функция для получения JWT-токена пользователя с переданной ролью,
+// пользователь создается при первом вызове
+func getTokenWithRole(t *testing.T, s http.Handler, st store.Store, role model.Role) string {
+	t.Helper()
+
+	email := fmt.Sprintf("%v@example.org", role)
+	if _, err := st.User().FindByEmail(email); err == store.ErrNoUser {
+		u := &model.User{Email: email, Role: role, Password: userPassword}
+		if role == model.RoleMerchant {
+			u.MerchantID = newTestMerchant(t, st).MerchantID
+		}
+		if err := st.User().Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := []byte(fmt.Sprintf(`{"email":"%v","password":"%v"}`, email, userPassword))
+	rec := doRequest(s, http.MethodPost, "/auth/login", data, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v: %v", rec.Code, rec.Body.String())
+	}
+
+	resp := &tokenResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.JWTToken
+}
+
 // Тестирование обработчика эндпойнта /session
 // который используется для создании платежной сессии
 func Test_HandleSessionCreate(t *testing.T) {
-	data := []byte(fmt.Sprintf(`{"amount":%v,"purpose":"%v"}`, amount, purpose))
-
-	req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/session", bytes.NewBuffer(data))
-	req.Header.Set("Content-type", "application/json")
+	s, _ := newTestServer(t)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Error(err)
-		return
+	testCases := []struct {
+		name         string
+		payload      string
+		expectedCode int
+	}{
+		{
+			name:         "valid",
+			payload:      fmt.Sprintf(`{"amount":%v,"purpose":"%v"}`, amount, purpose),
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "invalid json",
+			payload:      `{"amount":`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "allowed currency",
+			payload:      fmt.Sprintf(`{"amount":%v,"currency":"usd","purpose":"%v"}`, amount, purpose),
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "not allowed currency",
+			payload:      fmt.Sprintf(`{"amount":%v,"currency":"GBP","purpose":"%v"}`, amount, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid currency",
+			payload:      fmt.Sprintf(`{"amount":%v,"currency":"RUBLE","purpose":"%v"}`, amount, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "zero amount",
+			payload:      fmt.Sprintf(`{"amount":0,"purpose":"%v"}`, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "negative amount",
+			payload:      fmt.Sprintf(`{"amount":-1,"purpose":"%v"}`, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "over precision amount",
+			payload:      fmt.Sprintf(`{"amount":0.001,"purpose":"%v"}`, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "missing amount",
+			payload:      fmt.Sprintf(`{"purpose":"%v"}`, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "too long purpose",
+			payload:      fmt.Sprintf(`{"amount":%v,"purpose":"%0211d"}`, amount, 0),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "custom ttl",
+			payload:      fmt.Sprintf(`{"amount":%v,"purpose":"%v","expires_in":3600}`, amount, purpose),
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "zero ttl",
+			payload:      fmt.Sprintf(`{"amount":%v,"purpose":"%v","expires_in":0}`, amount, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "ttl above maximum",
+			payload:      fmt.Sprintf(`{"amount":%v,"purpose":"%v","expires_in":86401}`, amount, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "ttl overflowing to negative",
+			payload:      fmt.Sprintf(`{"amount":%v,"purpose":"%v","expires_in":9223372037}`, amount, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "ttl overflowing to positive",
+			payload:      fmt.Sprintf(`{"amount":%v,"purpose":"%v","expires_in":18446744074}`, amount, purpose),
+			expectedCode: http.StatusBadRequest,
+		},
 	}
-	defer req.Body.Close()
 
-	if err := json.NewDecoder(resp.Body).Decode(session); err != nil {
-		t.Error(err)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(s, http.MethodPost, "/session", []byte(tc.payload), map[string]string{"X-Api-Key": apiKey})
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
 	}
 
-	assert.Equal(t, http.StatusCreated, resp.StatusCode)
-	assert.Equal(t, amount, session.Amount)
+	session := createSession(t, s)
+	assert.Equal(t, amount, session.Amount.String())
+	assert.Equal(t, "RUB", session.Amount.Currency)
 	assert.Equal(t, purpose, session.Purpose)
-	assert.NotNil(t, session.SessionToken)
+	assert.NotEmpty(t, session.SessionToken)
+	assert.Equal(t, 15*time.Minute, session.ExpiresAt.Sub(session.CreatedAt))
+
+	session = createSessionWith(t, s, fmt.Sprintf(`{"amount":%v,"purpose":"%v","expires_in":3600}`, amount, purpose))
+	assert.Equal(t, time.Hour, session.ExpiresAt.Sub(session.CreatedAt))
 }
 
 // Тестирование обработчика эндпойнта /pay
 // который используется для выполнения оплаты
 func Test_HandlePayment(t *testing.T) {
+	s, _ := newTestServer(t)
+	session := createSession(t, s)
+
+	payload := func(cardNumber, cardCode, cardDate string) []byte {
+		return []byte(fmt.Sprintf(
+			`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+			session.SessionToken,
+			cardNumber,
+			cardCode,
+			cardDate,
+		))
+	}
+
+	rec := doRequest(s, http.MethodPost, "/pay", payload(cardNumber, "12", cardDate), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/pay", payload(cardNumber, cardCode, "13/23"), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/pay", payload("4111 1111 1111 1112", cardCode, cardDate), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/pay", payload(cardNumber, cardCode, cardDate), nil)
+
+	type response struct {
+		Payment string `json:"payment"`
+	}
+
+	r := &response{}
+	if err := json.NewDecoder(rec.Body).Decode(r); err != nil {
+		t.Error(err)
+	}
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "successful", r.Payment)
+
+	rec = doRequest(s, http.MethodPost, "/pay", payload(cardNumber, cardCode, cardDate), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/pay", []byte(`{"session_token":"unknown"}`), nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// Тестирование отказа эквайера при оплате платежной сессии
+func Test_HandlePaymentDeclined(t *testing.T) {
+	s, _ := newTestServer(t)
+	session := createSession(t, s)
+
+	data := []byte(fmt.Sprintf(
+		`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+		session.SessionToken,
+		"4000 0000 0000 9995",
+		cardCode,
+		cardDate,
+	))
+
+	rec := doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusPaymentRequired, rec.Code)
+	assert.JSONEq(t, `{"error":"payment declined","decline_reason":"insufficient_funds"}`, rec.Body.String())
+
+	rec = doRequest(s, http.MethodGet, "/session/"+session.SessionToken, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"declined"`)
+	assert.Contains(t, rec.Body.String(), `"decline_reason":"insufficient_funds"`)
+
+	rec = doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// Эквайер, который авторизует платежи, но не может их списать
+type failingCapture struct {
+	*acquirer.Mock
+	err    error
+	voided []string
+}
+
+func (a *failingCapture) Capture(authorizationID string, amount model.Money) (*acquirer.Result, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &acquirer.Result{AuthorizationID: authorizationID, DeclineReason: "capture_failed"}, nil
+}
+
+func (a *failingCapture) Void(authorizationID string) (*acquirer.Result, error) {
+	a.voided = append(a.voided, authorizationID)
+	return a.Mock.Void(authorizationID)
+}
+
+// Тестирование отмены авторизации, если автоматическое списание не прошло
+func Test_HandlePaymentCaptureFailed(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		expectedCode int
+		reason       string
+	}{
+		{name: "acquirer error", err: errors.New("connection reset"), expectedCode: http.StatusBadGateway, reason: "acquirer_error"},
+		{name: "capture declined", expectedCode: http.StatusPaymentRequired, reason: "capture_failed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := teststore.New()
+			newTestMerchant(t, st)
+			config := newTestConfig()
+			acq := &failingCapture{Mock: acquirer.NewMock(config.Acquirer), err: tc.err}
+			s := apiserver.New(config, st, acq)
+			session := createSession(t, s)
+
+			data := []byte(fmt.Sprintf(
+				`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+				session.SessionToken,
+				cardNumber,
+				cardCode,
+				cardDate,
+			))
+			rec := doRequest(s, http.MethodPost, "/pay", data, nil)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+
+			stored, err := st.Session().FindByToken(store.AllMerchants(), session.SessionToken)
+			assert.NoError(t, err)
+			assert.Equal(t, model.StatusDeclined, stored.Status)
+			assert.Equal(t, tc.reason, stored.DeclineReason)
+			assert.NotEmpty(t, stored.AuthorizationID)
+			assert.Equal(t, []string{stored.AuthorizationID}, acq.voided)
+		})
+	}
+}
+
+// Тестирование оплаты просроченной платежной сессии
+func Test_HandlePaymentExpired(t *testing.T) {
+	st := teststore.New()
+	newTestMerchant(t, st)
+	s := newServer(apiserver.NewConfig(), st)
+
+	session := &model.Session{
+		SessionToken: "ca197d71-142c-4bef-abd8-65f0bdd53f0b",
+		Amount:       model.Money{Minor: 10000, Currency: model.DefaultCurrency},
+		Purpose:      purpose,
+		CreatedAt:    time.Now().UTC().Add(-20 * time.Minute),
+	}
+	if err := st.Session().Create(session); err != nil {
+		t.Fatal(err)
+	}
+
 	data := []byte(fmt.Sprintf(
 		`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
 		session.SessionToken,
@@ -57,25 +426,775 @@ func Test_HandlePayment(t *testing.T) {
 		cardDate,
 	))
 
-	req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/pay", bytes.NewBuffer(data))
-	req.Header.Set("Content-type", "application/json")
+	rec := doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "session expired")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		t.Error(err)
-		return
+	session, err := st.Session().FindByToken(store.AllMerchants(), session.SessionToken)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StatusExpired, session.Status)
+
+	rec = doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "session expired")
+}
+
+// Тестирование обработчика эндпойнта /session/{token}
+// который используется для получения статуса платежной сессии
+func Test_HandleSessionGet(t *testing.T) {
+	st := teststore.New()
+	newTestMerchant(t, st)
+	s := newServer(apiserver.NewConfig(), st)
+	session := createSession(t, s)
+
+	type response struct {
+		Status    model.SessionStatus `json:"status"`
+		Amount    json.Number         `json:"amount"`
+		Currency  string              `json:"currency"`
+		ClosedAt  *time.Time          `json:"closed_at"`
+		ExpiresIn int64               `json:"expires_in"`
+	}
+
+	rec := doRequest(s, http.MethodGet, "/session/unknown", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/session/"+session.SessionToken, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := &response{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, model.StatusCreated, resp.Status)
+	assert.Equal(t, json.Number(amount), resp.Amount)
+	assert.Equal(t, "RUB", resp.Currency)
+	assert.Nil(t, resp.ClosedAt)
+	assert.True(t, resp.ExpiresIn > 0 && resp.ExpiresIn <= 15*60)
+
+	expired := &model.Session{
+		SessionToken: "ca197d71-142c-4bef-abd8-65f0bdd53f0b",
+		Amount:       model.Money{Minor: 10000, Currency: model.DefaultCurrency},
+		Purpose:      purpose,
+		CreatedAt:    time.Now().UTC().Add(-20 * time.Minute),
+	}
+	if err := st.Session().Create(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = doRequest(s, http.MethodGet, "/session/"+expired.SessionToken, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp = &response{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, model.StatusExpired, resp.Status)
+	assert.NotNil(t, resp.ClosedAt)
+	assert.Equal(t, int64(0), resp.ExpiresIn)
+}
+
+// Тестирование обработчика эндпойнта /session/{token}/cancel
+// который используется для отмены платежной сессии мерчантом
+func Test_HandleSessionCancel(t *testing.T) {
+	s, st := newTestServer(t)
+	session := createSession(t, s)
+	target := "/session/" + session.SessionToken + "/cancel"
+
+	rec := doRequest(s, http.MethodPost, target, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+
+	rec = doRequest(s, http.MethodPost, "/session/unknown/cancel", nil, auth)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, target, nil, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"cancelled"`)
+
+	rec = doRequest(s, http.MethodPost, target, nil, auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	data := []byte(fmt.Sprintf(
+		`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+		session.SessionToken,
+		cardNumber,
+		cardCode,
+		cardDate,
+	))
+	rec = doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "session cancelled")
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	stat := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v"}`, today, tomorrow))
+	rec = doRequest(s, http.MethodGet, "/stat", stat, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"cancelled_count":1`)
+	assert.Contains(t, rec.Body.String(), `"status":"cancelled"`)
+}
+
+// Вспомогательная функция для оплаты платежной сессии через /pay
+func paySession(t *testing.T, s http.Handler, session *model.Session) {
+	t.Helper()
+
+	data := []byte(fmt.Sprintf(
+		`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+		session.SessionToken,
+		cardNumber,
+		cardCode,
+		cardDate,
+	))
+	rec := doRequest(s, http.MethodPost, "/pay", data, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v: %v", rec.Code, rec.Body.String())
 	}
-	defer req.Body.Close()
+}
+
+// Тестирование обработчика эндпойнта /session/{token}/refund
+// который используется для полного и частичного возврата платежа
+func Test_HandleSessionRefund(t *testing.T) {
+	s, st := newTestServer(t)
+	session := createSession(t, s)
+	target := "/session/" + session.SessionToken + "/refund"
+
+	rec := doRequest(s, http.MethodPost, target, []byte(`{"amount":30}`), nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+
+	rec = doRequest(s, http.MethodPost, target, []byte(`{"amount":30}`), auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "session is not paid")
+
+	paySession(t, s, session)
 
 	type response struct {
-		Payment string `json:"payment"`
+		Amount        json.Number         `json:"amount"`
+		RefundedTotal json.Number         `json:"refunded_total"`
+		Status        model.SessionStatus `json:"status"`
 	}
 
-	r := &response{}
-	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
-		t.Error(err)
+	rec = doRequest(s, http.MethodPost, target, []byte(`{"amount":30}`), auth)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	resp := &response{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
 	}
+	assert.Equal(t, response{Amount: "30.00", RefundedTotal: "30.00", Status: model.StatusPaid}, *resp)
 
-	assert.Equal(t, http.StatusOK, resp.StatusCode)
-	assert.Equal(t, "successful", r.Payment)
+	rec = doRequest(s, http.MethodPost, target, []byte(`{"amount":80}`), auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "refund exceeds the paid amount")
+
+	rec = doRequest(s, http.MethodPost, target, []byte(`{"amount":-1}`), auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, target, nil, auth)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	resp = &response{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, response{Amount: "70.10", RefundedTotal: "100.10", Status: model.StatusRefunded}, *resp)
+
+	rec = doRequest(s, http.MethodPost, target, nil, auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	stat := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v"}`, today, tomorrow))
+	rec = doRequest(s, http.MethodGet, "/stat", stat, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"paid_amount":100.10`)
+	assert.Contains(t, rec.Body.String(), `"refunded_amount":100.10`)
+	assert.Contains(t, rec.Body.String(), `"net_amount":0.00`)
+}
+
+// Эквайер, который считает возвраты и отклоняет их по требованию
+type countingRefunds struct {
+	*acquirer.Mock
+	mu      sync.Mutex
+	calls   int
+	decline bool
+}
+
+func (a *countingRefunds) Refund(authorizationID string, amount model.Money) (*acquirer.Result, error) {
+	a.mu.Lock()
+	a.calls++
+	decline := a.decline
+	a.mu.Unlock()
+
+	if decline {
+		return &acquirer.Result{AuthorizationID: authorizationID, DeclineReason: "refund_not_allowed"}, nil
+	}
+	return a.Mock.Refund(authorizationID, amount)
+}
+
+// Тестирование параллельных возвратов: остаток резервируется до обращения
+// к эквайеру, поэтому эквайер получает только один полный возврат
+func Test_HandleSessionRefundConcurrent(t *testing.T) {
+	st := teststore.New()
+	newTestMerchant(t, st)
+	config := newTestConfig()
+	acq := &countingRefunds{Mock: acquirer.NewMock(config.Acquirer)}
+	s := apiserver.New(config, st, acq)
+
+	session := createSession(t, s)
+	paySession(t, s, session)
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+	target := "/session/" + session.SessionToken + "/refund"
+
+	// Отклоненный возврат не занимает остаток
+	acq.decline = true
+	rec := doRequest(s, http.MethodPost, target, nil, auth)
+	assert.Equal(t, http.StatusPaymentRequired, rec.Code)
+	acq.decline = false
+
+	const n = 10
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- doRequest(s, http.MethodPost, target, []byte(fmt.Sprintf(`{"amount":%v}`, amount)), auth).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	var created int
+	for code := range codes {
+		if code == http.StatusCreated {
+			created++
+			continue
+		}
+		assert.Equal(t, http.StatusBadRequest, code)
+	}
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 2, acq.calls)
+
+	stored, err := st.Session().FindByToken(store.AllMerchants(), session.SessionToken)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StatusRefunded, stored.Status)
+
+	refunds, err := st.Refund().FindBySession(stored)
+	assert.NoError(t, err)
+	if assert.Len(t, refunds, 2) {
+		assert.Equal(t, model.RefundFailed, refunds[0].Status)
+		assert.Equal(t, model.RefundSucceeded, refunds[1].Status)
+	}
+}
+
+type countingCaptures struct {
+	*acquirer.Mock
+	mu      sync.Mutex
+	calls   int
+	decline bool
+}
+
+func (a *countingCaptures) Capture(authorizationID string, amount model.Money) (*acquirer.Result, error) {
+	a.mu.Lock()
+	a.calls++
+	decline := a.decline
+	a.mu.Unlock()
+
+	if decline {
+		return &acquirer.Result{AuthorizationID: authorizationID, DeclineReason: "capture_not_allowed"}, nil
+	}
+	return a.Mock.Capture(authorizationID, amount)
+}
+
+// Тестирование параллельных частичных списаний: сессия захватывается
+// до обращения к эквайеру, поэтому эквайер получает только одно списание
+func Test_HandleSessionCaptureConcurrent(t *testing.T) {
+	st := teststore.New()
+	newTestMerchant(t, st)
+	config := newTestConfig()
+	acq := &countingCaptures{Mock: acquirer.NewMock(config.Acquirer)}
+	s := apiserver.New(config, st, acq)
+
+	session := createSessionWith(t, s, fmt.Sprintf(`{"amount":%v,"purpose":"%v","capture_mode":"manual"}`, amount, purpose))
+	paySession(t, s, session)
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+	target := "/session/" + session.SessionToken + "/capture"
+
+	// Отклоненное списание возвращает сессию в authorized
+	acq.decline = true
+	rec := doRequest(s, http.MethodPost, target, []byte(`{"amount":50}`), auth)
+	assert.Equal(t, http.StatusPaymentRequired, rec.Code)
+	acq.decline = false
+
+	stored, err := st.Session().FindByToken(store.AllMerchants(), session.SessionToken)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StatusAuthorized, stored.Status)
+
+	const n = 10
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- doRequest(s, http.MethodPost, target, []byte(`{"amount":50}`), auth).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	var captured int
+	for code := range codes {
+		if code == http.StatusOK {
+			captured++
+			continue
+		}
+		// Запрос, пришедший после списания, видит сессию уже оплаченной
+		assert.Contains(t, []int{http.StatusConflict, http.StatusBadRequest}, code)
+	}
+	assert.Equal(t, 1, captured)
+	assert.Equal(t, 2, acq.calls)
+
+	stored, err = st.Session().FindByToken(store.AllMerchants(), session.SessionToken)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StatusPaid, stored.Status)
+	assert.Equal(t, int64(5000), stored.Captured.Minor)
+}
+
+// Тестирование двухстадийной оплаты: авторизация через /pay
+// и последующее списание через /session/{token}/capture
+func Test_HandleSessionCapture(t *testing.T) {
+	s, st := newTestServer(t)
+	session := createSessionWith(t, s, fmt.Sprintf(`{"amount":%v,"purpose":"%v","capture_mode":"manual"}`, amount, purpose))
+	assert.Equal(t, model.CaptureManual, session.CaptureMode)
+
+	rec := doRequest(s, http.MethodPost, "/session", []byte(`{"amount":1,"capture_mode":"later"}`), map[string]string{"X-Api-Key": apiKey})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+	target := "/session/" + session.SessionToken + "/capture"
+
+	rec = doRequest(s, http.MethodPost, target, nil, auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "session is not authorized")
+
+	data := []byte(fmt.Sprintf(
+		`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+		session.SessionToken,
+		cardNumber,
+		cardCode,
+		cardDate,
+	))
+	rec = doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"payment":"authorized"}`, rec.Body.String())
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	stat := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v"}`, today, tomorrow))
+	rec = doRequest(s, http.MethodGet, "/stat", stat, auth)
+	assert.Contains(t, rec.Body.String(), `"authorized_count":1`)
+	assert.Contains(t, rec.Body.String(), `"authorized_amount":100.10`)
+
+	rec = doRequest(s, http.MethodPost, target, []byte(`{"amount":200}`), auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, target, []byte(`{"amount":50}`), auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"paid","captured_amount":50.00}`, rec.Body.String())
+
+	rec = doRequest(s, http.MethodPost, target, nil, auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/refund", []byte(`{"amount":60}`), auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/stat", stat, auth)
+	assert.Contains(t, rec.Body.String(), `"authorized_count":0`)
+	assert.Contains(t, rec.Body.String(), `"paid_amount":50.00`)
+}
+
+// Тестирование отмены авторизации через /session/{token}/void
+func Test_HandleSessionVoid(t *testing.T) {
+	s, st := newTestServer(t)
+	session := createSessionWith(t, s, fmt.Sprintf(`{"amount":%v,"purpose":"%v","capture_mode":"manual"}`, amount, purpose))
+	paySession(t, s, session)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+
+	rec := doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/void", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/void", nil, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status":"voided"}`, rec.Body.String())
+
+	rec = doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/capture", nil, auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// Тестирование автоматического истечения авторизации
+func Test_HandleSessionAuthorizationExpired(t *testing.T) {
+	config := apiserver.NewConfig()
+	config.AuthorizationTTL.Duration = time.Nanosecond
+	st := teststore.New()
+	newTestMerchant(t, st)
+	s := newServer(config, st)
+
+	session := createSessionWith(t, s, fmt.Sprintf(`{"amount":%v,"purpose":"%v","capture_mode":"manual"}`, amount, purpose))
+	paySession(t, s, session)
+	time.Sleep(time.Millisecond)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+
+	rec := doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/capture", nil, auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "authorization expired")
+
+	rec = doRequest(s, http.MethodGet, "/session/"+session.SessionToken, nil, nil)
+	assert.Contains(t, rec.Body.String(), `"status":"expired"`)
+}
+
+type blockingAuthorize struct {
+	*acquirer.Mock
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (a *blockingAuthorize) Authorize(req *acquirer.AuthorizeRequest) (*acquirer.Result, error) {
+	a.entered <- struct{}{}
+	<-a.release
+	return a.Mock.Authorize(req)
+}
+
+// Тестирование одновременной оплаты одной платежной сессии:
+// пока первый платеж обрабатывается эквайером, остальные получают
+// конфликт, а после оплаты сессия считается закрытой
+func Test_HandlePaymentConcurrent(t *testing.T) {
+	st := teststore.New()
+	newTestMerchant(t, st)
+	config := newTestConfig()
+	acq := &blockingAuthorize{
+		Mock:    acquirer.NewMock(config.Acquirer),
+		entered: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	s := apiserver.New(config, st, acq)
+	session := createSession(t, s)
+
+	data := []byte(fmt.Sprintf(
+		`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+		session.SessionToken,
+		cardNumber,
+		cardCode,
+		cardDate,
+	))
+
+	first := make(chan int, 1)
+	go func() {
+		first <- doRequest(s, http.MethodPost, "/pay", data, nil).Code
+	}()
+	<-acq.entered
+
+	const n = 20
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- doRequest(s, http.MethodPost, "/pay", data, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		assert.Equal(t, http.StatusConflict, code)
+	}
+
+	close(acq.release)
+	assert.Equal(t, http.StatusOK, <-first)
+
+	rec := doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "session already closed")
+}
+
+// Тестирование обработчика эндпойнта /stat
+// который используется для получения статистики по сессиям
+func Test_HandleSessionsStats(t *testing.T) {
+	s, st := newTestServer(t)
+	createSession(t, s)
+	createSession(t, s)
+	rec := doRequest(s, http.MethodPost, "/session", []byte(`{"amount":5,"currency":"EUR","purpose":"test"}`), map[string]string{"X-Api-Key": apiKey})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	data := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v"}`, today, tomorrow))
+
+	rec = doRequest(s, http.MethodGet, "/stat", data, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+
+	rec = doRequest(s, http.MethodGet, "/stat", []byte(`{"date_begin":"18.07.2020"}`), auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/stat", data, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	type total struct {
+		Currency string      `json:"currency"`
+		Count    int         `json:"count"`
+		Amount   json.Number `json:"amount"`
+	}
+
+	resp := &struct {
+		Totals   []total         `json:"totals"`
+		Sessions []model.Session `json:"sessions"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, resp.Sessions, 3)
+	assert.Equal(t, []total{
+		{Currency: "EUR", Count: 1, Amount: "5.00"},
+		{Currency: "RUB", Count: 2, Amount: "200.20"},
+	}, resp.Totals)
+}
+
+// Тестирование обработчика эндпойнта /auth/login
+// который используется для получения JWT-токена по логину и паролю
+func Test_HandleLogin(t *testing.T) {
+	s, st := newTestServer(t)
+
+	u := &model.User{Email: userEmail, Role: model.RoleAdmin, Password: userPassword}
+	if err := st.User().Create(u); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name         string
+		payload      string
+		expectedCode int
+	}{
+		{
+			name:         "valid",
+			payload:      fmt.Sprintf(`{"email":"%v","password":"%v"}`, userEmail, userPassword),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "email in other case",
+			payload:      fmt.Sprintf(`{"email":"ADMIN@example.org","password":"%v"}`, userPassword),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "wrong password",
+			payload:      fmt.Sprintf(`{"email":"%v","password":"wrong password"}`, userEmail),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "unknown user",
+			payload:      fmt.Sprintf(`{"email":"unknown@example.org","password":"%v"}`, userPassword),
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "invalid json",
+			payload:      `{"email":`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(s, http.MethodPost, "/auth/login", []byte(tc.payload), nil)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+
+	rec := doRequest(s, http.MethodGet, "/get-token", nil, nil)
+	assert.NotEqual(t, http.StatusCreated, rec.Code)
+
+	token := getToken(t, s, st)
+	claims := &jwt.StandardClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token, claims)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(u.UserID), claims.Subject)
+
+	target := "/session/unknown/webhooks"
+	rec = doRequest(s, http.MethodGet, target, nil, map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Токен пользователя, которого нет в хранилище, не принимается
+	other, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+		Id:        "other",
+		Subject:   "100",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(jwtSecret))
+	assert.NoError(t, err)
+
+	rec = doRequest(s, http.MethodGet, target, nil, map[string]string{"Authorization": "Bearer " + other})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+// Вспомогательная функция для входа администратора с получением пары токенов
+func login(t *testing.T, s http.Handler) *tokenResponse {
+	t.Helper()
+
+	data := []byte(fmt.Sprintf(`{"email":"%v","password":"%v"}`, userEmail, userPassword))
+	rec := doRequest(s, http.MethodPost, "/auth/login", data, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v: %v", rec.Code, rec.Body.String())
+	}
+
+	resp := &tokenResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// Тестирование обработчика эндпойнта /auth/refresh
+// который используется для обновления пары токенов
+func Test_HandleRefresh(t *testing.T) {
+	s, st := newTestServer(t)
+
+	u := &model.User{Email: userEmail, Role: model.RoleAdmin, Password: userPassword}
+	if err := st.User().Create(u); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := login(t, s)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	assert.Equal(t, int64((15 * time.Minute).Seconds()), tokens.ExpiresIn)
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		return doRequest(s, http.MethodPost, "/auth/refresh", []byte(fmt.Sprintf(`{"refresh_token":"%v"}`, token)), nil)
+	}
+
+	rec := refresh(tokens.RefreshToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	renewed := &tokenResponse{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(renewed))
+	assert.NotEqual(t, tokens.RefreshToken, renewed.RefreshToken)
+
+	rec = doRequest(s, http.MethodGet, "/session/unknown/webhooks", nil, map[string]string{"Authorization": "Bearer " + renewed.JWTToken})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = refresh("unknown")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Повторное использование токена обновления отзывает все токены пользователя
+	rec = refresh(tokens.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = refresh(renewed.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/auth/refresh", []byte(`{"refresh_token":`), nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// Тестирование обработчика эндпойнта /auth/logout
+// который используется для отзыва токенов
+func Test_HandleLogout(t *testing.T) {
+	s, st := newTestServer(t)
+
+	u := &model.User{Email: userEmail, Role: model.RoleAdmin, Password: userPassword}
+	if err := st.User().Create(u); err != nil {
+		t.Fatal(err)
+	}
+
+	target := "/session/unknown/webhooks"
+	tokens := login(t, s)
+	other := login(t, s)
+	auth := map[string]string{"Authorization": "Bearer " + tokens.JWTToken}
+
+	rec := doRequest(s, http.MethodPost, "/auth/logout", []byte(fmt.Sprintf(`{"refresh_token":"%v"}`, tokens.RefreshToken)), auth)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, target, nil, auth)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Токены другого входа продолжают действовать
+	rec = doRequest(s, http.MethodGet, target, nil, map[string]string{"Authorization": "Bearer " + other.JWTToken})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/auth/logout", nil, map[string]string{"Authorization": "Bearer " + other.JWTToken})
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/auth/refresh", []byte(fmt.Sprintf(`{"refresh_token":"%v"}`, other.RefreshToken)), nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/auth/refresh", []byte(fmt.Sprintf(`{"refresh_token":"%v"}`, tokens.RefreshToken)), nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/auth/logout", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+// Тестирование проверки прав ролей на закрытых эндпойнтах
+func Test_RoleAccess(t *testing.T) {
+	s, st := newTestServer(t)
+
+	endpoints := []struct {
+		method  string
+		target  string
+		allowed []model.Role
+	}{
+		{http.MethodPost, "/session/unknown/cancel", []model.Role{model.RoleAdmin, model.RoleMerchant}},
+		{http.MethodPost, "/session/unknown/capture", []model.Role{model.RoleAdmin, model.RoleMerchant}},
+		{http.MethodPost, "/session/unknown/void", []model.Role{model.RoleAdmin, model.RoleMerchant}},
+		{http.MethodPost, "/session/unknown/refund", []model.Role{model.RoleAdmin, model.RoleMerchant, model.RoleSupport}},
+		{http.MethodGet, "/session/unknown/webhooks", []model.Role{model.RoleAdmin, model.RoleMerchant, model.RoleSupport, model.RoleAnalyst}},
+		{http.MethodGet, "/stat", []model.Role{model.RoleAdmin, model.RoleAnalyst, model.RoleMerchant}},
+		{http.MethodGet, "/stat/summary", []model.Role{model.RoleAdmin, model.RoleAnalyst, model.RoleMerchant}},
+	}
+
+	roles := []model.Role{model.RoleAdmin, model.RoleMerchant, model.RoleAnalyst, model.RoleSupport}
+	for _, role := range roles {
+		auth := map[string]string{"Authorization": "Bearer " + getTokenWithRole(t, s, st, role)}
+
+		for _, e := range endpoints {
+			allowed := false
+			for _, r := range e.allowed {
+				allowed = allowed || r == role
+			}
+
+			t.Run(fmt.Sprintf("%v %v %v", role, e.method, e.target), func(t *testing.T) {
+				rec := doRequest(s, e.method, e.target, nil, auth)
+				if allowed {
+					assert.NotEqual(t, http.StatusForbidden, rec.Code)
+				} else {
+					assert.Equal(t, http.StatusForbidden, rec.Code)
+				}
+			})
+		}
+	}
+
+	// Права проверяются по роли из хранилища, а не из токена
+	u, err := st.User().FindByEmail("analyst@example.org")
+	assert.NoError(t, err)
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &struct {
+		Role model.Role `json:"role"`
+		jwt.StandardClaims
+	}{
+		Role: model.RoleAdmin,
+		StandardClaims: jwt.StandardClaims{
+			Id:        "forged",
+			Subject:   fmt.Sprint(u.UserID),
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}).SignedString([]byte(jwtSecret))
+	assert.NoError(t, err)
+
+	rec := doRequest(s, http.MethodPost, "/session/unknown/cancel", nil, map[string]string{"Authorization": "Bearer " + forged})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
 }
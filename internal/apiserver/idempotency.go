@@ -0,0 +1,139 @@
+package apiserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotentReplayed   = "Idempotent-Replayed"
+	maxIdempotencyKeyLen = 255
+)
+
+var (
+	errIdempotencyKeyTooLong  = errors.New("idempotency key must be at most 255 symbols")
+	errIdempotencyKeyReused   = errors.New("idempotency key was already used with a different request")
+	errIdempotencyKeyInFlight = errors.New("request with this idempotency key is still in progress")
+)
+
+type recordingWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Повторный запрос с тем же Idempotency-Key и телом получает сохраненный
+// ответ первого запроса вместо повторного выполнения обработчика.
+// Ключи разных мерчантов и разных платежных сессий не пересекаются
+func withIdempotency(s *APIServer, scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLen {
+			s.logger.Error(errIdempotencyKeyTooLong)
+			s.error(w, r, http.StatusBadRequest, errIdempotencyKeyTooLong)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.Sum256(body)
+		now := s.now()
+		record := &model.IdempotencyKey{
+			Scope:       idempotencyScope(scope, r, body),
+			Key:         key,
+			RequestHash: hex.EncodeToString(hash[:]),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.config.IdempotencyTTL.Duration),
+		}
+
+		existing, err := s.store.Idempotency().Reserve(record)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				s.logger.Error(errIdempotencyKeyReused)
+				s.error(w, r, http.StatusUnprocessableEntity, errIdempotencyKeyReused)
+			case !existing.IsCompleted():
+				s.logger.Error(errIdempotencyKeyInFlight)
+				s.error(w, r, http.StatusConflict, errIdempotencyKeyInFlight)
+			default:
+				s.logger.Info(fmt.Sprintf("replay response for idempotency key %v", key))
+				w.Header().Set(idempotentReplayed, "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write(existing.Response)
+			}
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w, code: http.StatusOK}
+		next(rw, r)
+
+		// Ответ с ошибкой сервера не сохраняется, чтобы клиент мог повторить запрос
+		if rw.code >= http.StatusInternalServerError {
+			if err := s.store.Idempotency().Delete(record); err != nil {
+				s.logger.Error(err)
+			}
+			return
+		}
+
+		record.StatusCode = rw.code
+		record.Response = rw.body.Bytes()
+		if err := s.store.Idempotency().Complete(record); err != nil {
+			s.logger.Error(err)
+		}
+	}
+}
+
+// У /pay нет мерчанта в контексте, поэтому ключи разделяются по токену
+// сессии из тела запроса: разные плательщики могут прислать один ключ
+func idempotencyScope(scope string, r *http.Request, body []byte) string {
+	if m, ok := r.Context().Value(ctxKeyMerchant).(*model.Merchant); ok {
+		return fmt.Sprintf("%v/%v", scope, m.MerchantID)
+	}
+
+	req := struct {
+		SessionToken string `json:"session_token"`
+	}{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return scope
+	}
+	if _, err := uuid.Parse(req.SessionToken); err != nil {
+		return scope
+	}
+	return fmt.Sprintf("%v/%v", scope, req.SessionToken)
+}
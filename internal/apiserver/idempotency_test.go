@@ -0,0 +1,102 @@
+package apiserver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/internal/apiserver"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Тестирование повторного создания сессии с тем же Idempotency-Key
+func Test_IdempotentSessionCreate(t *testing.T) {
+	s, _ := newTestServer(t)
+	data := []byte(fmt.Sprintf(`{"amount":%v,"purpose":"%v"}`, amount, purpose))
+	headers := map[string]string{"Idempotency-Key": "create-1", "X-Api-Key": apiKey}
+
+	first := doRequest(s, http.MethodPost, "/session", data, headers)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	second := doRequest(s, http.MethodPost, "/session", data, headers)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+
+	other := doRequest(s, http.MethodPost, "/session", data, map[string]string{"Idempotency-Key": "create-2", "X-Api-Key": apiKey})
+	assert.Equal(t, http.StatusCreated, other.Code)
+	assert.NotEqual(t, first.Body.String(), other.Body.String())
+
+	changed := []byte(fmt.Sprintf(`{"amount":1,"purpose":"%v"}`, purpose))
+	rec := doRequest(s, http.MethodPost, "/session", changed, headers)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/session", data, map[string]string{"Idempotency-Key": strings.Repeat("k", 256), "X-Api-Key": apiKey})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// Тестирование повторной оплаты с тем же Idempotency-Key
+func Test_IdempotentPayment(t *testing.T) {
+	s, _ := newTestServer(t)
+	session := createSession(t, s)
+
+	data := []byte(fmt.Sprintf(
+		`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+		session.SessionToken,
+		cardNumber,
+		cardCode,
+		cardDate,
+	))
+	headers := map[string]string{"Idempotency-Key": "pay-1"}
+
+	rec := doRequest(s, http.MethodPost, "/pay", data, headers)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/pay", data, headers)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"payment":"successful"}`, rec.Body.String())
+
+	rec = doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Ключи разных плательщиков не пересекаются
+	other := createSession(t, s)
+	data = []byte(fmt.Sprintf(
+		`{"session_token":"%v","card_number":"%v","code":"%v","date":"%v"}`,
+		other.SessionToken,
+		cardNumber,
+		cardCode,
+		cardDate,
+	))
+	rec = doRequest(s, http.MethodPost, "/pay", data, headers)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+}
+
+// Тестирование истечения срока действия Idempotency-Key
+func Test_IdempotencyKeyExpired(t *testing.T) {
+	config := apiserver.NewConfig()
+	config.IdempotencyTTL.Duration = time.Nanosecond
+	st := teststore.New()
+	newTestMerchant(t, st)
+	s := newServer(config, st)
+
+	data := []byte(fmt.Sprintf(`{"amount":%v,"purpose":"%v"}`, amount, purpose))
+	headers := map[string]string{"Idempotency-Key": "create-1", "X-Api-Key": apiKey}
+
+	first := &model.Session{}
+	rec := doRequest(s, http.MethodPost, "/session", data, headers)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(first))
+
+	time.Sleep(time.Millisecond)
+
+	second := &model.Session{}
+	rec = doRequest(s, http.MethodPost, "/session", data, headers)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(second))
+	assert.NotEqual(t, first.SessionToken, second.SessionToken)
+}
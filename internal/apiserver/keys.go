@@ -0,0 +1,259 @@
+package apiserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const ephemeralKeyID = "ephemeral"
+
+var (
+	errUnknownKeyID         = errors.New("token is signed with an unknown key")
+	errNoSigningKey         = errors.New("jwt signing key is not configured")
+	errUnsupportedAlgorithm = errors.New("jwt key algorithm must be HS256, RS256 or ES256")
+)
+
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// Набор ключей JWT: токены подписываются одним ключом, а проверяются
+// любым известным по kid, поэтому при ротации ранее выданные токены
+// продолжают действовать, пока старый ключ остается в конфиге
+type keyring struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+}
+
+func newKeyring(config *JWTConfig) (*keyring, error) {
+	k := &keyring{keys: make(map[string]*signingKey)}
+
+	// Без настроенных ключей токены подписываются случайным секретом
+	// и перестают действовать после перезапуска сервера
+	if len(config.Keys) == 0 {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		k.signing = &signingKey{id: ephemeralKeyID, method: jwt.SigningMethodHS256, private: secret, public: secret}
+		k.keys[ephemeralKeyID] = k.signing
+		return k, nil
+	}
+
+	for _, c := range config.Keys {
+		key, err := loadKey(c)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := k.keys[key.id]; ok {
+			return nil, fmt.Errorf("duplicate jwt key %v", key.id)
+		}
+		k.keys[key.id] = key
+	}
+
+	id := config.SigningKey
+	if id == "" && len(config.Keys) == 1 {
+		id = config.Keys[0].ID
+	}
+
+	signing, ok := k.keys[id]
+	if !ok {
+		return nil, errNoSigningKey
+	}
+	if signing.private == nil {
+		return nil, fmt.Errorf("jwt key %v has no private key and can not be used for signing", id)
+	}
+	k.signing = signing
+
+	return k, nil
+}
+
+func loadKey(c *JWTKey) (*signingKey, error) {
+	if c.ID == "" {
+		return nil, errors.New("jwt key must have a kid")
+	}
+
+	privatePEM, err := readPEM(c.PrivateKey, c.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := readPEM(c.PublicKey, c.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{id: c.ID}
+	switch strings.ToUpper(c.Algorithm) {
+	case "HS256":
+		if c.Secret == "" {
+			return nil, fmt.Errorf("jwt key %v: secret is required for HS256", c.ID)
+		}
+		key.method = jwt.SigningMethodHS256
+		key.private = []byte(c.Secret)
+		key.public = key.private
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+		if privatePEM != nil {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %v: %v", c.ID, err)
+			}
+			key.private = private
+			key.public = &private.PublicKey
+		} else if publicPEM != nil {
+			public, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %v: %v", c.ID, err)
+			}
+			key.public = public
+		}
+	case "ES256":
+		key.method = jwt.SigningMethodES256
+		var public *ecdsa.PublicKey
+		if privatePEM != nil {
+			private, err := jwt.ParseECPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %v: %v", c.ID, err)
+			}
+			key.private = private
+			public = &private.PublicKey
+		} else if publicPEM != nil {
+			public, err = jwt.ParseECPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %v: %v", c.ID, err)
+			}
+		}
+		if public != nil {
+			if public.Curve != elliptic.P256() {
+				return nil, fmt.Errorf("jwt key %v: ES256 requires a P-256 key", c.ID)
+			}
+			key.public = public
+		}
+	default:
+		return nil, fmt.Errorf("jwt key %v: %v", c.ID, errUnsupportedAlgorithm)
+	}
+
+	if key.public == nil {
+		return nil, fmt.Errorf("jwt key %v: private or public key is required", c.ID)
+	}
+
+	return key, nil
+}
+
+// Ключ задается в конфиге либо строкой в формате PEM, либо путем к файлу
+func readPEM(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+func (k *keyring) sign(claims jwt.Claims) (string, error) {
+	if k.signing == nil {
+		return "", errNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.id
+	return token.SignedString(k.signing.private)
+}
+
+// Токен без kid проверяется текущим ключом подписи. Алгоритм токена должен
+// совпадать с алгоритмом ключа, иначе открытый ключ RS256 можно было бы
+// использовать как секрет HS256
+func (k *keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	key := k.signing
+	if kid, ok := token.Header["kid"].(string); ok {
+		key = k.keys[kid]
+	}
+	if key == nil {
+		return nil, errUnknownKeyID
+	}
+
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errNotValidToken
+	}
+	return key.public, nil
+}
+
+type jsonWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// Открытые ключи в формате JWK (RFC 7517). Симметричные ключи HS256
+// не публикуются
+func (k *keyring) jwks() []jsonWebKey {
+	keys := make([]jsonWebKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		jwk := jsonWebKey{KeyID: key.id, Algorithm: key.method.Alg(), Use: "sig"}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encodeBase64URL(public.N.Bytes())
+			jwk.E = encodeBase64URL(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = public.Curve.Params().Name
+			jwk.X = encodeBase64URL(padBytes(public.X.Bytes(), size))
+			jwk.Y = encodeBase64URL(padBytes(public.Y.Bytes(), size))
+		default:
+			continue
+		}
+
+		keys = append(keys, jwk)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].KeyID < keys[j].KeyID
+	})
+
+	return keys
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Координаты точки EC в JWK имеют фиксированную длину
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+func (s *APIServer) handleJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		s.respond(w, r, http.StatusOK, map[string]interface{}{"keys": s.keys.jwks()})
+	}
+}
@@ -0,0 +1,206 @@
+package apiserver_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/bolshagin/xsolla-be-2020/internal/apiserver"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Вспомогательная функция для генерации ключей ES256 в формате PEM
+func generateECKey(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: private})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+}
+
+// Вспомогательная функция для генерации ключа RS256 в формате PEM
+func generateRSAKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func newKeysServer(t *testing.T, st *teststore.Store, jwtConfig *apiserver.JWTConfig) *apiserver.APIServer {
+	t.Helper()
+
+	config := apiserver.NewConfig()
+	config.JWT = jwtConfig
+	return newServer(config, st)
+}
+
+// Тестирование подписи токенов асимметричными ключами и эндпойнта /.well-known/jwks.json
+func Test_JWKS(t *testing.T) {
+	ecPrivate, _ := generateECKey(t)
+	dir, err := ioutil.TempDir("", "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaFile := filepath.Join(dir, "rsa.pem")
+	if err := ioutil.WriteFile(rsaFile, []byte(generateRSAKey(t)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	st := teststore.New()
+	s := newKeysServer(t, st, &apiserver.JWTConfig{
+		SigningKey: "es-1",
+		Keys: []*apiserver.JWTKey{
+			{ID: "es-1", Algorithm: "ES256", PrivateKey: ecPrivate},
+			{ID: "rs-1", Algorithm: "RS256", PrivateKeyFile: rsaFile},
+			{ID: "hs-1", Algorithm: "HS256", Secret: jwtSecret},
+		},
+	})
+
+	token := getToken(t, s, st)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &jwt.StandardClaims{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ES256", parsed.Method.Alg())
+	assert.Equal(t, "es-1", parsed.Header["kid"])
+
+	rec := doRequest(s, http.MethodGet, "/session/unknown/webhooks", nil, map[string]string{"Authorization": "Bearer " + token})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, "/.well-known/jwks.json", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := struct {
+		Keys []map[string]string `json:"keys"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	if assert.Len(t, resp.Keys, 2) {
+		assert.Equal(t, "es-1", resp.Keys[0]["kid"])
+		assert.Equal(t, "EC", resp.Keys[0]["kty"])
+		assert.Equal(t, "P-256", resp.Keys[0]["crv"])
+		assert.NotEmpty(t, resp.Keys[0]["x"])
+		assert.Equal(t, "rs-1", resp.Keys[1]["kid"])
+		assert.Equal(t, "RSA", resp.Keys[1]["kty"])
+		assert.Equal(t, "AQAB", resp.Keys[1]["e"])
+	}
+}
+
+// Тестирование ротации ключей: токены, подписанные прежним ключом,
+// принимаются, пока его открытая часть остается в конфиге
+func Test_KeyRotation(t *testing.T) {
+	oldPrivate, oldPublic := generateECKey(t)
+	newPrivate, _ := generateECKey(t)
+
+	st := teststore.New()
+	before := newKeysServer(t, st, &apiserver.JWTConfig{
+		Keys: []*apiserver.JWTKey{{ID: "old", Algorithm: "ES256", PrivateKey: oldPrivate}},
+	})
+	after := newKeysServer(t, st, &apiserver.JWTConfig{
+		SigningKey: "new",
+		Keys: []*apiserver.JWTKey{
+			{ID: "new", Algorithm: "ES256", PrivateKey: newPrivate},
+			{ID: "old", Algorithm: "ES256", PublicKey: oldPublic},
+		},
+	})
+
+	target := "/session/unknown/webhooks"
+	oldToken := getToken(t, before, st)
+	rec := doRequest(after, http.MethodGet, target, nil, map[string]string{"Authorization": "Bearer " + oldToken})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Новый ключ неизвестен серверу со старым конфигом
+	newToken := getToken(t, after, st)
+	rec = doRequest(before, http.MethodGet, target, nil, map[string]string{"Authorization": "Bearer " + newToken})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Токен HS256, подписанный открытым ключом как секретом, не принимается
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &jwt.StandardClaims{
+		Subject:   "1",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	forged.Header["kid"] = "old"
+	forgedS, err := forged.SignedString([]byte(oldPublic))
+	assert.NoError(t, err)
+
+	rec = doRequest(after, http.MethodGet, target, nil, map[string]string{"Authorization": "Bearer " + forgedS})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+// Тестирование ошибок конфигурации ключей: сервер не запускается
+func Test_InvalidKeys(t *testing.T) {
+	_, public := generateECKey(t)
+
+	testCases := []struct {
+		name   string
+		config *apiserver.JWTConfig
+	}{
+		{
+			name:   "unknown algorithm",
+			config: &apiserver.JWTConfig{Keys: []*apiserver.JWTKey{{ID: "k", Algorithm: "none"}}},
+		},
+		{
+			name:   "missing secret",
+			config: &apiserver.JWTConfig{Keys: []*apiserver.JWTKey{{ID: "k", Algorithm: "HS256"}}},
+		},
+		{
+			name:   "missing kid",
+			config: &apiserver.JWTConfig{Keys: []*apiserver.JWTKey{{Algorithm: "HS256", Secret: jwtSecret}}},
+		},
+		{
+			name: "unknown signing key",
+			config: &apiserver.JWTConfig{
+				SigningKey: "other",
+				Keys:       []*apiserver.JWTKey{{ID: "k", Algorithm: "HS256", Secret: jwtSecret}},
+			},
+		},
+		{
+			name:   "signing key without private part",
+			config: &apiserver.JWTConfig{Keys: []*apiserver.JWTKey{{ID: "k", Algorithm: "ES256", PublicKey: public}}},
+		},
+		{
+			name:   "missing key file",
+			config: &apiserver.JWTConfig{Keys: []*apiserver.JWTKey{{ID: "k", Algorithm: "RS256", PrivateKeyFile: "missing.pem"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := teststore.New()
+			s := newKeysServer(t, st, tc.config)
+			assert.Error(t, s.Start())
+
+			u := &model.User{Email: userEmail, Role: model.RoleAdmin, Password: userPassword}
+			assert.NoError(t, st.User().Create(u))
+
+			rec := doRequest(s, http.MethodPost, "/auth/login", []byte(`{"email":"admin@example.org","password":"password"}`), nil)
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		})
+	}
+}
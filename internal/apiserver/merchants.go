@@ -0,0 +1,93 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"net/http"
+)
+
+const apiKeyHeader = "X-Api-Key"
+
+var errInvalidAPIKey = errors.New("api key is missing, invalid or revoked")
+
+// Пропускает запрос с действующим API-ключом мерчанта в заголовке X-Api-Key
+// и кладет мерчанта в контекст. Ключ ищется по открытому префиксу,
+// а затем сравнивается с хешем
+func checkAPIKey(s *APIServer, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(apiKeyHeader)
+		prefix, ok := model.APIKeyPrefix(value)
+		if !ok {
+			s.logger.Error(errInvalidAPIKey)
+			s.error(w, r, http.StatusUnauthorized, errInvalidAPIKey)
+			return
+		}
+
+		key, err := s.store.Merchant().FindKey(prefix)
+		if err != nil && err != store.ErrNoAPIKey {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if key == nil || !key.IsActive() || !key.Matches(value) {
+			s.logger.Error(fmt.Sprintf("rejected api key %v", prefix))
+			s.error(w, r, http.StatusUnauthorized, errInvalidAPIKey)
+			return
+		}
+
+		m, err := s.store.Merchant().Find(key.MerchantID)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.logger.Info(fmt.Sprintf("authorized merchant %v with api key %v", m.MerchantID, key.Prefix))
+		next(w, r.WithContext(context.WithValue(r.Context(), ctxKeyMerchant, m)))
+	}
+}
+
+// Область видимости сессий для запроса: все сессии видит только администратор.
+// Мерчант по API-ключу и пользователь, привязанный к мерчанту, видят сессии
+// этого мерчанта, а остальные пользователи - ни одной
+func (s *APIServer) scope(r *http.Request) store.Scope {
+	if m, ok := r.Context().Value(ctxKeyMerchant).(*model.Merchant); ok {
+		return store.MerchantScope(m.MerchantID)
+	}
+
+	u, ok := r.Context().Value(ctxKeyUser).(*model.User)
+	if !ok {
+		return store.Scope{}
+	}
+
+	if u.Role == model.RoleAdmin {
+		return store.AllMerchants()
+	}
+	if u.MerchantID != 0 {
+		return store.MerchantScope(u.MerchantID)
+	}
+	return store.Scope{}
+}
+
+// Адрес уведомлений сессии: переданный при создании, затем адрес мерчанта,
+// затем общий адрес из конфига
+func (s *APIServer) callbackURL(session *model.Session) string {
+	if session.CallbackURL != "" {
+		return session.CallbackURL
+	}
+
+	if session.MerchantID != 0 {
+		m, err := s.store.Merchant().Find(session.MerchantID)
+		if err != nil {
+			s.logger.Error(err)
+		} else if m.CallbackURL != "" {
+			return m.CallbackURL
+		}
+	}
+
+	return s.config.Webhooks.URL
+}
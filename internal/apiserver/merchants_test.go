@@ -0,0 +1,237 @@
+package apiserver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Вспомогательная функция для создания мерчанта с новым API-ключом
+func newMerchant(t *testing.T, st store.Store, m *model.Merchant) string {
+	t.Helper()
+
+	m.CreatedAt = time.Now().UTC()
+	if err := st.Merchant().Create(m); err != nil {
+		t.Fatal(err)
+	}
+
+	key, value, err := model.NewAPIKey(m.MerchantID, m.CreatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Merchant().CreateKey(key); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// Вспомогательная функция для входа пользователя, привязанного к мерчанту
+func merchantToken(t *testing.T, s http.Handler, st store.Store, email string, role model.Role, m *model.Merchant) string {
+	t.Helper()
+
+	u := &model.User{Email: email, Role: role, MerchantID: m.MerchantID, Password: userPassword}
+	if err := st.User().Create(u); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte(fmt.Sprintf(`{"email":"%v","password":"%v"}`, email, userPassword))
+	rec := doRequest(s, http.MethodPost, "/auth/login", data, nil)
+	resp := &tokenResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.JWTToken
+}
+
+// Тестирование проверки API-ключа мерчанта при создании сессии
+func Test_SessionCreateAPIKey(t *testing.T) {
+	s, st := newTestServer(t)
+	data := []byte(fmt.Sprintf(`{"amount":%v,"purpose":"%v"}`, amount, purpose))
+
+	m := &model.Merchant{Name: "shop"}
+	key := newMerchant(t, st, m)
+	prefix, _ := model.APIKeyPrefix(key)
+
+	testCases := []struct {
+		name         string
+		key          string
+		expectedCode int
+	}{
+		{name: "valid", key: key, expectedCode: http.StatusCreated},
+		{name: "missing", key: "", expectedCode: http.StatusUnauthorized},
+		{name: "malformed", key: "secret", expectedCode: http.StatusUnauthorized},
+		{name: "unknown prefix", key: "sk_ffffffffffff_secret", expectedCode: http.StatusUnauthorized},
+		{name: "wrong secret", key: prefix + "_wrong", expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := doRequest(s, http.MethodPost, "/session", data, map[string]string{"X-Api-Key": tc.key})
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+
+	session := createSessionWith(t, s, string(data))
+	assert.Equal(t, newTestMerchant(t, st).MerchantID, session.MerchantID)
+
+	// Ротация: новый ключ действует сразу, отозванный больше не принимается
+	rotated, value, err := model.NewAPIKey(m.MerchantID, time.Now().UTC())
+	assert.NoError(t, err)
+	assert.NoError(t, st.Merchant().CreateKey(rotated))
+
+	old, err := st.Merchant().FindKey(prefix)
+	assert.NoError(t, err)
+	assert.NoError(t, st.Merchant().RevokeKey(old, time.Now().UTC()))
+
+	rec := doRequest(s, http.MethodPost, "/session", data, map[string]string{"X-Api-Key": key})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/session", data, map[string]string{"X-Api-Key": value})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	created := &model.Session{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(created))
+	assert.Equal(t, m.MerchantID, created.MerchantID)
+}
+
+// Тестирование изоляции сессий разных мерчантов
+func Test_MerchantIsolation(t *testing.T) {
+	s, st := newTestServer(t)
+
+	own := newTestMerchant(t, st)
+	other := &model.Merchant{Name: "other shop"}
+	otherKey := newMerchant(t, st, other)
+
+	session := createSession(t, s)
+
+	// Одинаковый Idempotency-Key разных мерчантов не пересекается
+	data := []byte(fmt.Sprintf(`{"amount":%v,"purpose":"%v"}`, amount, purpose))
+	rec := doRequest(s, http.MethodPost, "/session", data, map[string]string{"X-Api-Key": apiKey, "Idempotency-Key": "same"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rec = doRequest(s, http.MethodPost, "/session", data, map[string]string{"X-Api-Key": otherKey, "Idempotency-Key": "same"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+
+	otherAuth := map[string]string{"Authorization": "Bearer " + merchantToken(t, s, st, "other@example.org", model.RoleMerchant, other)}
+	ownAuth := map[string]string{"Authorization": "Bearer " + merchantToken(t, s, st, "own@example.org", model.RoleMerchant, own)}
+
+	rec = doRequest(s, http.MethodGet, "/session/"+session.SessionToken+"/webhooks", nil, otherAuth)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/cancel", nil, otherAuth)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	period := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v"}`, today, tomorrow))
+
+	countSessions := func(auth map[string]string) int {
+		rec := doRequest(s, http.MethodGet, "/stat", period, auth)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %v: %v", rec.Code, rec.Body.String())
+		}
+
+		resp := &struct {
+			Sessions []model.Session `json:"sessions"`
+		}{}
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+		return len(resp.Sessions)
+	}
+
+	assert.Equal(t, 1, countSessions(otherAuth))
+	assert.Equal(t, 2, countSessions(ownAuth))
+	assert.Equal(t, 3, countSessions(map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}))
+
+	rec = doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/cancel", nil, ownAuth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// Тестирование области видимости аналитика и поддержки: без привязки
+// к мерчанту они не видят ни одной сессии, с привязкой - только его сессии
+func Test_RoleScope(t *testing.T) {
+	s, st := newTestServer(t)
+
+	own := newTestMerchant(t, st)
+	other := &model.Merchant{Name: "other shop"}
+	otherKey := newMerchant(t, st, other)
+
+	session := createSession(t, s)
+	paySession(t, s, session)
+	data := []byte(fmt.Sprintf(`{"amount":%v,"purpose":"%v"}`, amount, purpose))
+	rec := doRequest(s, http.MethodPost, "/session", data, map[string]string{"X-Api-Key": otherKey})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	analyst := bearer(getTokenWithRole(t, s, st, model.RoleAnalyst))
+	assert.Empty(t, getStats(t, s, analyst, "").Sessions)
+	assert.Empty(t, getStats(t, s, analyst, "").Totals)
+
+	ownAnalyst := bearer(merchantToken(t, s, st, "own-analyst@example.org", model.RoleAnalyst, own))
+	if page := getStats(t, s, ownAnalyst, ""); assert.Len(t, page.Sessions, 1) {
+		assert.Equal(t, own.MerchantID, page.Sessions[0].MerchantID)
+	}
+
+	assert.Len(t, getStats(t, s, bearer(getToken(t, s, st)), "").Sessions, 2)
+
+	support := bearer(getTokenWithRole(t, s, st, model.RoleSupport))
+	otherSupport := bearer(merchantToken(t, s, st, "other-support@example.org", model.RoleSupport, other))
+	ownSupport := bearer(merchantToken(t, s, st, "own-support@example.org", model.RoleSupport, own))
+
+	rec = doRequest(s, http.MethodGet, "/stat", nil, ownSupport)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	target := "/session/" + session.SessionToken + "/refund"
+	refund := []byte(`{"amount":10}`)
+	for _, auth := range []map[string]string{support, otherSupport} {
+		rec = doRequest(s, http.MethodPost, target, refund, auth)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	rec = doRequest(s, http.MethodPost, target, refund, ownSupport)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
+
+// Тестирование настроек мерчанта: времени жизни сессии и адреса уведомлений
+func Test_MerchantSettings(t *testing.T) {
+	s, st := newTestServer(t)
+	m := &model.Merchant{Name: "shop", SessionTTL: time.Hour, CallbackURL: "https://shop.example.org/hook"}
+	key := newMerchant(t, st, m)
+
+	rec := doRequest(s, http.MethodPost, "/session", []byte(`{"amount":10}`), map[string]string{"X-Api-Key": key})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	session := &model.Session{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(session))
+	assert.Equal(t, time.Hour, session.ExpiresAt.Sub(session.CreatedAt))
+
+	paySession(t, s, session)
+
+	// Время жизни мерчанта ограничено пределом из конфига
+	long := &model.Merchant{Name: "long", SessionTTL: 48 * time.Hour}
+	rec = doRequest(s, http.MethodPost, "/session", []byte(`{"amount":10}`), map[string]string{"X-Api-Key": newMerchant(t, st, long)})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	clamped := &model.Session{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(clamped))
+	assert.Equal(t, 24*time.Hour, clamped.ExpiresAt.Sub(clamped.CreatedAt))
+
+	stored, err := st.Session().FindByToken(store.AllMerchants(), session.SessionToken)
+	assert.NoError(t, err)
+
+	drainEvents(t, s, st)
+	deliveries, err := st.Webhook().FindBySession(stored)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, m.CallbackURL, deliveries[0].URL)
+	}
+}
@@ -0,0 +1,45 @@
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"net/http"
+)
+
+var errForbidden = errors.New("not enough permissions")
+
+// Права ролей на закрытые эндпойнты. Администратор может все,
+// аналитик только читает, поддержка читает и делает возвраты,
+// мерчант управляет своими платежами и видит их статистику,
+// метрики процесса доступны только администратору
+var (
+	adminRoles  = []model.Role{model.RoleAdmin}
+	readRoles   = []model.Role{model.RoleAdmin, model.RoleAnalyst, model.RoleSupport, model.RoleMerchant}
+	statsRoles  = []model.Role{model.RoleAdmin, model.RoleAnalyst, model.RoleMerchant}
+	manageRoles = []model.Role{model.RoleAdmin, model.RoleMerchant}
+	refundRoles = []model.Role{model.RoleAdmin, model.RoleSupport, model.RoleMerchant}
+)
+
+// Пропускает запрос, только если роль пользователя входит в roles. Роль
+// берется из хранилища, как и в scope, а не из JWT-токена, выпущенного
+// до ее смены. Должен стоять после checkJWTToken, который кладет
+// пользователя в контекст
+func requireRole(s *APIServer, roles []model.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var role model.Role
+		if u, ok := r.Context().Value(ctxKeyUser).(*model.User); ok {
+			role = u.Role
+		}
+
+		for _, allowed := range roles {
+			if role == allowed {
+				next(w, r)
+				return
+			}
+		}
+
+		s.logger.Error(fmt.Sprintf("role %q is not allowed to %v %v", role, r.Method, r.URL.Path))
+		s.error(w, r, http.StatusForbidden, errForbidden)
+	}
+}
@@ -0,0 +1,330 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStatsLimit = 100
+	maxStatsLimit     = 1000
+	maxStatsBuckets   = 1000
+)
+
+var (
+	errInvalidStatus        = errors.New("status filter contains an unknown session status")
+	errInvalidSort          = errors.New("sort must be one of created_at, -created_at, amount, -amount")
+	errInvalidLimit         = errors.New("limit must be a non-negative integer")
+	errAmountFilterCurrency = errors.New("amount filter requires currency")
+	errInvalidAmountRange   = errors.New("amount_min must not exceed amount_max")
+	errInvalidInterval      = errors.New("interval must be one of hour, day, week, month")
+	errInvalidTimezone      = errors.New("timezone must be an IANA time zone name, e.g. Europe/Moscow")
+)
+
+// Параметры выборки статистики. Курсор берется из next_cursor
+// предыдущей страницы и действует только с той же сортировкой,
+// шаг interval используется только сводной статистикой
+type statsRequest struct {
+	DateBegin string                `json:"date_begin"`
+	DateEnd   string                `json:"date_end"`
+	Timezone  string                `json:"timezone"`
+	Status    []model.SessionStatus `json:"status"`
+	Currency  string                `json:"currency"`
+	AmountMin json.Number           `json:"amount_min"`
+	AmountMax json.Number           `json:"amount_max"`
+	Purpose   string                `json:"purpose"`
+	Sort      store.StatsSort       `json:"sort"`
+	Limit     int                   `json:"limit"`
+	Cursor    string                `json:"cursor"`
+	Interval  store.StatsInterval   `json:"interval"`
+}
+
+// Параметры передаются в строке запроса: тело GET-запроса отбрасывают
+// многие прокси и http-клиенты. Для совместимости json-тело по-прежнему
+// читается, но значения из строки запроса важнее
+func decodeStatsRequest(r *http.Request) (*statsRequest, error) {
+	req := &statsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	query := r.URL.Query()
+	str := func(name string, dst *string) {
+		if values, ok := query[name]; ok {
+			*dst = values[0]
+		}
+	}
+
+	str("date_begin", &req.DateBegin)
+	str("date_end", &req.DateEnd)
+	str("timezone", &req.Timezone)
+	str("currency", &req.Currency)
+	str("purpose", &req.Purpose)
+	str("cursor", &req.Cursor)
+
+	// ParseMoney принимает и дроби вида 1/2, и экспоненту, поэтому
+	// значения из строки запроса сначала проверяются как обычные числа
+	for name, dst := range map[string]*json.Number{"amount_min": &req.AmountMin, "amount_max": &req.AmountMax} {
+		if values, ok := query[name]; ok {
+			if !IsDecimal(values[0]) {
+				return nil, model.ErrInvalidAmount
+			}
+			*dst = json.Number(values[0])
+		}
+	}
+	if values, ok := query["sort"]; ok {
+		req.Sort = store.StatsSort(values[0])
+	}
+	if values, ok := query["interval"]; ok {
+		req.Interval = store.StatsInterval(values[0])
+	}
+
+	// Статусы передаются через запятую или повторением параметра
+	if values, ok := query["status"]; ok {
+		req.Status = nil
+		for _, value := range values {
+			for _, status := range strings.Split(value, ",") {
+				req.Status = append(req.Status, model.SessionStatus(strings.TrimSpace(status)))
+			}
+		}
+	}
+
+	if values, ok := query["limit"]; ok {
+		limit, err := strconv.Atoi(values[0])
+		if err != nil {
+			return nil, errInvalidLimit
+		}
+		req.Limit = limit
+	}
+
+	return req, nil
+}
+
+func loadTimezone(name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errInvalidTimezone
+	}
+	return loc, nil
+}
+
+// Даты периода отсчитываются по местному времени loc
+func (s *APIServer) parseStatsFilter(req *statsRequest, loc *time.Location) (*store.StatsFilter, error) {
+	begin, end, err := s.parseDates(req.DateBegin, req.DateEnd, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &store.StatsFilter{
+		Begin:    begin,
+		End:      end,
+		Currency: strings.ToUpper(strings.TrimSpace(req.Currency)),
+		Purpose:  req.Purpose,
+	}
+
+	for _, status := range req.Status {
+		if !status.IsValid() {
+			return nil, errInvalidStatus
+		}
+		f.Statuses = append(f.Statuses, status)
+	}
+
+	// Суммы сравниваются в минимальных единицах, поэтому без валюты
+	// граница в 100 означала бы и 100 рублей, и 100 иен
+	if (req.AmountMin != "" || req.AmountMax != "") && f.Currency == "" {
+		return nil, errAmountFilterCurrency
+	}
+	if req.AmountMin != "" {
+		min, err := model.ParseMoney(req.AmountMin.String(), f.Currency)
+		if err != nil {
+			return nil, err
+		}
+		f.MinAmount = min.Minor
+	}
+	if req.AmountMax != "" {
+		max, err := model.ParseMoney(req.AmountMax.String(), f.Currency)
+		if err != nil {
+			return nil, err
+		}
+		f.MaxAmount = max.Minor
+	}
+	if f.MaxAmount > 0 && f.MinAmount > f.MaxAmount {
+		return nil, errInvalidAmountRange
+	}
+
+	return f, nil
+}
+
+func (s *APIServer) parseStatsQuery(req *statsRequest) (*store.StatsQuery, error) {
+	loc, err := loadTimezone(req.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := s.parseStatsFilter(req, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &store.StatsQuery{StatsFilter: *f, Sort: req.Sort, Limit: req.Limit}
+	if q.Sort == "" {
+		q.Sort = store.SortCreatedAtDesc
+	}
+	if !q.Sort.IsValid() {
+		return nil, errInvalidSort
+	}
+
+	switch {
+	case q.Limit < 0:
+		return nil, errInvalidLimit
+	case q.Limit == 0:
+		q.Limit = defaultStatsLimit
+	case q.Limit > maxStatsLimit:
+		q.Limit = maxStatsLimit
+	}
+
+	if req.Cursor != "" {
+		q.After, err = store.DecodeStatsCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if q.After.Sort != q.Sort {
+			return nil, store.ErrInvalidCursor
+		}
+	}
+
+	return q, nil
+}
+
+func (s *APIServer) handleSessionsStats() http.HandlerFunc {
+	type response struct {
+		Totals     []model.CurrencyTotal `json:"totals"`
+		Sessions   []model.Session       `json:"sessions"`
+		NextCursor string                `json:"next_cursor,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		exportCSV, err := wantsCSV(r)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		req, err := decodeStatsRequest(r)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		q, err := s.parseStatsQuery(req)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		// Выгрузка содержит всю выборку целиком, limit и cursor к ней не применяются
+		if exportCSV {
+			e, err := s.parseExport(r)
+			if err != nil {
+				s.logger.Error(err)
+				s.error(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			q.Limit, q.After = 0, nil
+			s.exportStats(w, r, q, e)
+			return
+		}
+
+		// Пустая выборка - не ошибка: фильтр или последняя страница
+		// могут не содержать ни одной сессии
+		resp := &response{Totals: []model.CurrencyTotal{}, Sessions: []model.Session{}}
+
+		sessions, next, err := s.store.Session().GetStats(s.scope(r), q)
+		if err != nil && err != store.ErrNoStats {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if sessions != nil {
+			resp.Sessions = sessions
+		}
+		if next != nil {
+			resp.NextCursor = next.Encode()
+		}
+
+		totals, err := s.store.Session().GetTotals(s.scope(r), &q.StatsFilter)
+		if err != nil && err != store.ErrNoStats {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if totals != nil {
+			resp.Totals = totals
+		}
+
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+// Сводная статистика за период: итоги по статусам, конверсия, среднее
+// время до оплаты и временной ряд с шагом interval в часовом поясе timezone
+func (s *APIServer) handleSessionsSummary() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := decodeStatsRequest(r)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.Interval == "" {
+			req.Interval = store.IntervalDay
+		}
+		if !req.Interval.IsValid() {
+			s.logger.Error(errInvalidInterval)
+			s.error(w, r, http.StatusBadRequest, errInvalidInterval)
+			return
+		}
+
+		loc, err := loadTimezone(req.Timezone)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		f, err := s.parseStatsFilter(req, loc)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		bounds, err := store.StatsBuckets(f.Begin, f.End, req.Interval, loc, maxStatsBuckets)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		summary, err := s.store.Session().GetSummary(s.scope(r), f, bounds)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, summary)
+	}
+}
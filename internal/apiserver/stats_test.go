@@ -0,0 +1,273 @@
+package apiserver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type statsPage struct {
+	Totals []struct {
+		Currency string `json:"currency"`
+		Count    int    `json:"count"`
+	} `json:"totals"`
+	Sessions   []model.Session `json:"sessions"`
+	NextCursor string          `json:"next_cursor"`
+}
+
+// Вспомогательная функция для запроса страницы статистики за сегодня
+func getStats(t *testing.T, s http.Handler, auth map[string]string, params string) *statsPage {
+	t.Helper()
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	data := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v"%v}`, today, tomorrow, params))
+
+	rec := doRequest(s, http.MethodGet, "/stat", data, auth)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %v: %v", rec.Code, rec.Body.String())
+	}
+
+	page := &statsPage{}
+	if err := json.NewDecoder(rec.Body).Decode(page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+// Тестирование постраничной выдачи статистики по курсору
+func Test_StatsPagination(t *testing.T) {
+	s, st := newTestServer(t)
+	for i := 1; i <= 5; i++ {
+		createSessionWith(t, s, fmt.Sprintf(`{"amount":%v,"purpose":"order %v"}`, i*10, i))
+	}
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+
+	var purposes []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		params := `,"sort":"-amount","limit":2`
+		if cursor != "" {
+			params += fmt.Sprintf(`,"cursor":"%v"`, cursor)
+		}
+
+		page := getStats(t, s, auth, params)
+		assert.True(t, len(page.Sessions) <= 2)
+		if assert.Len(t, page.Totals, 1) {
+			assert.Equal(t, 5, page.Totals[0].Count)
+		}
+		for _, session := range page.Sessions {
+			purposes = append(purposes, session.Purpose)
+		}
+
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, []string{"order 5", "order 4", "order 3", "order 2", "order 1"}, purposes)
+
+	page := getStats(t, s, auth, `,"limit":100000`)
+	assert.Len(t, page.Sessions, 5)
+	assert.Empty(t, page.NextCursor)
+
+	// Курсор другой сортировки не принимается
+	first := getStats(t, s, auth, `,"sort":"-amount","limit":1`)
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	data := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v","sort":"amount","cursor":"%v"}`, today, tomorrow, first.NextCursor))
+	rec := doRequest(s, http.MethodGet, "/stat", data, auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// Тестирование фильтров статистики
+func Test_StatsFilters(t *testing.T) {
+	s, st := newTestServer(t)
+	createSessionWith(t, s, `{"amount":10,"purpose":"Premium subscription"}`)
+	createSessionWith(t, s, `{"amount":25.50,"purpose":"basic subscription"}`)
+	createSessionWith(t, s, `{"amount":100,"purpose":"gift card"}`)
+	createSessionWith(t, s, `{"amount":30,"currency":"USD","purpose":"premium pack"}`)
+	cancelled := createSessionWith(t, s, `{"amount":40,"purpose":"cancelled order"}`)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+	rec := doRequest(s, http.MethodPost, "/session/"+cancelled.SessionToken+"/cancel", nil, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	testCases := []struct {
+		name     string
+		params   string
+		expected int
+	}{
+		{name: "currency", params: `,"currency":"usd"`, expected: 1},
+		{name: "status", params: `,"status":["cancelled"]`, expected: 1},
+		{name: "several statuses", params: `,"status":["created","cancelled"]`, expected: 5},
+		{name: "amount range", params: `,"currency":"RUB","amount_min":"20","amount_max":40`, expected: 2},
+		{name: "purpose", params: `,"purpose":"PREMIUM"`, expected: 2},
+		{name: "nothing found", params: `,"purpose":"refund"`, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			page := getStats(t, s, auth, tc.params)
+			assert.Len(t, page.Sessions, tc.expected)
+
+			count := 0
+			for _, total := range page.Totals {
+				count += total.Count
+			}
+			assert.Equal(t, tc.expected, count)
+		})
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().Add(24 * time.Hour).Format("2006-01-02")
+	for _, params := range []string{
+		`,"status":["unknown"]`,
+		`,"amount_min":10`,
+		`,"currency":"RUB","amount_min":50,"amount_max":10`,
+		`,"currency":"RUB","amount_min":0.001`,
+		`,"sort":"purpose"`,
+		`,"limit":-1`,
+		`,"cursor":"not-a-cursor"`,
+	} {
+		data := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v"%v}`, today, tomorrow, params))
+		rec := doRequest(s, http.MethodGet, "/stat", data, auth)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, params)
+	}
+}
+
+// Тестирование сводной статистики /stat/summary
+func Test_StatsSummary(t *testing.T) {
+	s, st := newTestServer(t)
+	paySession(t, s, createSession(t, s))
+	createSession(t, s)
+	createSessionWith(t, s, `{"amount":5,"currency":"EUR","purpose":"test"}`)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+	now := time.Now().UTC()
+	yesterday := now.Add(-24 * time.Hour).Format("2006-01-02")
+	tomorrow := now.Add(24 * time.Hour).Format("2006-01-02")
+
+	data := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v","interval":"hour","timezone":"Asia/Tokyo"}`, yesterday, tomorrow))
+	rec := doRequest(s, http.MethodGet, "/stat/summary", data, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	summary := &struct {
+		Count          int     `json:"count"`
+		PaidCount      int     `json:"paid_count"`
+		ConversionRate float64 `json:"conversion_rate"`
+		Statuses       []struct {
+			Status   string      `json:"status"`
+			Currency string      `json:"currency"`
+			Count    int         `json:"count"`
+			Amount   json.Number `json:"amount"`
+		} `json:"statuses"`
+		Buckets []struct {
+			Start     time.Time `json:"start"`
+			Currency  string    `json:"currency"`
+			Count     int       `json:"count"`
+			PaidCount int       `json:"paid_count"`
+		} `json:"buckets"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(summary))
+	assert.Equal(t, 3, summary.Count)
+	assert.Equal(t, 1, summary.PaidCount)
+	assert.InDelta(t, 1.0/3, summary.ConversionRate, 0.0001)
+	assert.Len(t, summary.Statuses, 3)
+
+	count := 0
+	for _, b := range summary.Buckets {
+		_, offset := b.Start.Zone()
+		assert.Equal(t, 9*60*60, offset)
+		assert.Equal(t, 0, b.Start.Minute())
+		count += b.Count
+	}
+	assert.Equal(t, 3, count)
+
+	for _, params := range []string{
+		`,"interval":"year"`,
+		`,"timezone":"Mars/Olympus"`,
+		`,"interval":"hour","date_begin":"2000-01-01"`,
+	} {
+		data := []byte(fmt.Sprintf(`{"date_begin":"%v","date_end":"%v"%v}`, yesterday, tomorrow, params))
+		rec := doRequest(s, http.MethodGet, "/stat/summary", data, auth)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, params)
+	}
+}
+
+// Тестирование параметров статистики в строке запроса
+func Test_StatsQueryParams(t *testing.T) {
+	s, st := newTestServer(t)
+	paySession(t, s, createSession(t, s))
+	createSession(t, s)
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+
+	now := time.Now().UTC()
+	today := now.Format("2006-01-02")
+
+	count := func(target string, body []byte) int {
+		rec := doRequest(s, http.MethodGet, target, body, auth)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %v for %v: %v", rec.Code, target, rec.Body.String())
+		}
+
+		page := &statsPage{}
+		if err := json.NewDecoder(rec.Body).Decode(page); err != nil {
+			t.Fatal(err)
+		}
+		return len(page.Sessions)
+	}
+
+	// День окончания входит в период целиком
+	assert.Equal(t, 2, count(fmt.Sprintf("/stat?date_begin=%v&date_end=%v", today, today), nil))
+	assert.Equal(t, 1, count(fmt.Sprintf("/stat?date_begin=%v&date_end=%v&status=paid,refunded&status=cancelled", today, today), nil))
+
+	// Строка запроса важнее тела
+	body := []byte(`{"date_begin":"2000-01-01","date_end":"2000-01-02","status":["paid"]}`)
+	assert.Equal(t, 2, count(fmt.Sprintf("/stat?date_begin=%v&date_end=%v&status=created,paid", today, today), body))
+	assert.Equal(t, 0, count("/stat", body))
+
+	// Границы суммы задаются обычной десятичной записью
+	assert.Equal(t, 2, count(fmt.Sprintf("/stat?date_begin=%v&date_end=%v&currency=RUB&amount_min=100.10&amount_max=100.10", today, today), nil))
+	assert.Equal(t, 0, count(fmt.Sprintf("/stat?date_begin=%v&date_end=%v&currency=RUB&amount_min=100.11", today, today), nil))
+
+	// Время в формате RFC 3339: конец периода в него не входит
+	hourAgo := url.QueryEscape(now.Add(-time.Hour).Format(time.RFC3339))
+	inHour := url.QueryEscape(now.Add(time.Hour).Format(time.RFC3339))
+	assert.Equal(t, 2, count(fmt.Sprintf("/stat?date_begin=%v&date_end=%v", hourAgo, inHour), nil))
+
+	// Даты без времени отсчитываются в переданном часовом поясе
+	for _, name := range []string{"Pacific/Kiritimati", "Pacific/Pago_Pago"} {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			t.Skip(err)
+		}
+		local := now.In(loc).Format("2006-01-02")
+		yesterday := now.In(loc).AddDate(0, 0, -1).Format("2006-01-02")
+		assert.Equal(t, 2, count(fmt.Sprintf("/stat?date_begin=%v&date_end=%v&timezone=%v", local, local, name), nil), name)
+		assert.Equal(t, 0, count(fmt.Sprintf("/stat?date_begin=%v&date_end=%v&timezone=%v", yesterday, yesterday, name), nil), name)
+	}
+
+	for _, target := range []string{
+		"/stat",
+		"/stat?date_begin=" + today,
+		"/stat?date_begin=18.07.2020&date_end=" + today,
+		fmt.Sprintf("/stat?date_begin=%v&date_end=%v", inHour, hourAgo),
+		fmt.Sprintf("/stat?date_begin=%v&date_end=%v", hourAgo, hourAgo),
+		"/stat?date_begin=2000-01-01&date_end=" + today,
+		fmt.Sprintf("/stat?date_begin=%v&date_end=%v&timezone=Mars/Olympus", today, today),
+		fmt.Sprintf("/stat?date_begin=%v&date_end=%v&limit=ten", today, today),
+		fmt.Sprintf("/stat?date_begin=%v&date_end=%v&currency=RUB&amount_min=1/2", today, today),
+		fmt.Sprintf("/stat?date_begin=%v&date_end=%v&currency=RUB&amount_max=1e3", today, today),
+		fmt.Sprintf("/stat?date_begin=%v&date_end=%v&currency=RUB&amount_min=0x10", today, today),
+		fmt.Sprintf("/stat/summary?date_begin=%v&date_end=%v&interval=year", today, today),
+	} {
+		rec := doRequest(s, http.MethodGet, target, nil, auth)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+	}
+}
@@ -0,0 +1,205 @@
+package apiserver
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"time"
+)
+
+const sweepBatchSize = 500
+
+// Метрики фоновой очистки доступны в /debug/vars под ключом expiry_sweeper
+var sweeperMetrics = expvar.NewMap("expiry_sweeper")
+
+func (s *APIServer) runSweeper(ctx context.Context) {
+	ticker := time.NewTicker(s.config.SweepInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.SweepExpired(); err != nil {
+				s.logger.Error(err)
+			}
+			if _, err := s.SweepAuthorizations(); err != nil {
+				s.logger.Error(err)
+			}
+			if _, err := s.SweepProcessing(); err != nil {
+				s.logger.Error(err)
+			}
+			s.purgeTokens()
+			s.purgeIdempotencyKeys()
+			s.purgeOutbox()
+		}
+	}
+}
+
+// Переводит в expired все неоплаченные сессии с истекшим временем жизни
+// и возвращает их количество. Сессии обрабатываются пачками, чтобы
+// не держать блокировку на большом количестве строк
+func (s *APIServer) SweepExpired() (int, error) {
+	sweeperMetrics.Add("runs", 1)
+
+	now := s.now()
+
+	swept := 0
+	for {
+		sessions, err := s.store.Session().ExpireSessions(now, sweepBatchSize)
+		if err != nil {
+			sweeperMetrics.Add("errors", 1)
+			return swept, err
+		}
+
+		swept += len(sessions)
+		sweeperMetrics.Add("swept_total", int64(len(sessions)))
+
+		if len(sessions) < sweepBatchSize {
+			break
+		}
+	}
+
+	last := new(expvar.Int)
+	last.Set(int64(swept))
+	sweeperMetrics.Set("last_swept", last)
+
+	if swept > 0 {
+		s.logger.Info(fmt.Sprintf("expired %v abandoned sessions", swept))
+	}
+	return swept, nil
+}
+
+// Отменяет у эквайера авторизации, не списанные за authorization_ttl,
+// переводит их сессии в expired и возвращает количество таких сессий
+func (s *APIServer) SweepAuthorizations() (int, error) {
+	now := s.now()
+	authorizedBefore := now.Add(-s.config.AuthorizationTTL.Duration)
+
+	swept := 0
+	for {
+		sessions, err := s.store.Session().FindExpiredAuthorizations(authorizedBefore, sweepBatchSize)
+		if err != nil {
+			sweeperMetrics.Add("errors", 1)
+			return swept, err
+		}
+
+		for i := range sessions {
+			// Конфликт означает, что сессию успели списать или отменить
+			// параллельным запросом, и ее больше не нужно обрабатывать
+			err := s.closeAuthorization(&sessions[i], now)
+			if err == store.ErrSessionConflict {
+				continue
+			}
+			if err != nil {
+				sweeperMetrics.Add("errors", 1)
+				return swept, err
+			}
+			swept++
+			sweeperMetrics.Add("authorizations_expired_total", 1)
+		}
+
+		if len(sessions) < sweepBatchSize {
+			break
+		}
+	}
+
+	if swept > 0 {
+		s.logger.Info(fmt.Sprintf("expired %v overdue authorizations", swept))
+	}
+	return swept, nil
+}
+
+// Отклоняет сессии, зависшие в processing дольше processing_timeout,
+// и возвращает их количество. Номер авторизации до ответа
+// эквайера не сохраняется, поэтому отменить ее нельзя: токен сессии пишется
+// в лог для сверки с эквайером
+func (s *APIServer) SweepProcessing() (int, error) {
+	now := s.now()
+	startedBefore := now.Add(-s.config.ProcessingTimeout.Duration)
+
+	swept := 0
+	for {
+		sessions, err := s.store.Session().FindStaleProcessing(startedBefore, sweepBatchSize)
+		if err != nil {
+			sweeperMetrics.Add("errors", 1)
+			return swept, err
+		}
+
+		for i := range sessions {
+			session := &sessions[i]
+			session.DeclineReason = declineProcessingTimeout
+			err := s.store.Session().UpdateStatus(session, model.StatusDeclined, now)
+			if err == store.ErrSessionConflict {
+				continue
+			}
+			if err != nil {
+				sweeperMetrics.Add("errors", 1)
+				return swept, err
+			}
+			swept++
+			sweeperMetrics.Add("processing_declined_total", 1)
+			s.logger.Warn(fmt.Sprintf("session %v declined after processing timeout, reconcile it with the acquirer", session.SessionToken))
+		}
+
+		if len(sessions) < sweepBatchSize {
+			break
+		}
+	}
+
+	return swept, nil
+}
+
+// Удаляет истекшие токены обновления и записи об отозванных токенах доступа,
+// которые уже не пройдут проверку срока действия
+func (s *APIServer) purgeTokens() {
+	deleted, err := s.store.Token().DeleteExpired(s.now())
+	if err != nil {
+		s.logger.Error(err)
+		return
+	}
+
+	if deleted > 0 {
+		s.logger.Debug(fmt.Sprintf("deleted %v expired tokens", deleted))
+	}
+}
+
+// Удаляет ключи идемпотентности, срок хранения ответа по которым истек
+func (s *APIServer) purgeIdempotencyKeys() {
+	deleted, err := s.store.Idempotency().DeleteExpired(s.now())
+	if err != nil {
+		s.logger.Error(err)
+		return
+	}
+
+	if deleted > 0 {
+		s.logger.Debug(fmt.Sprintf("deleted %v expired idempotency keys", deleted))
+	}
+}
+
+// Удаляет события outbox, опубликованные раньше outbox_retention. Удаление
+// идет пачками, чтобы не держать долгую блокировку на таблице событий
+func (s *APIServer) purgeOutbox() {
+	before := s.now().Add(-s.config.OutboxRetention.Duration)
+
+	deleted := 0
+	for {
+		n, err := s.store.Outbox().DeletePublished(before, sweepBatchSize)
+		if err != nil {
+			s.logger.Error(err)
+			break
+		}
+
+		deleted += n
+		if n < sweepBatchSize {
+			break
+		}
+	}
+
+	if deleted > 0 {
+		s.logger.Debug(fmt.Sprintf("deleted %v published outbox events", deleted))
+	}
+}
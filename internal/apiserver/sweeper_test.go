@@ -0,0 +1,218 @@
+package apiserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/bolshagin/xsolla-be-2020/internal/apiserver"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Тестирование фоновой пометки брошенных сессий как истекших
+func Test_SweepExpired(t *testing.T) {
+	config := apiserver.NewConfig()
+	config.Webhooks.URL = "http://localhost/hook"
+	st := teststore.New()
+	newTestMerchant(t, st)
+	s := newServer(config, st)
+
+	now := time.Now().UTC()
+	sessions := []*model.Session{
+		{SessionToken: "abandoned", CreatedAt: now.Add(-time.Hour)},
+		{SessionToken: "fresh", CreatedAt: now},
+		{SessionToken: "paid", CreatedAt: now.Add(-time.Hour)},
+	}
+	for _, session := range sessions {
+		session.Amount = model.Money{Minor: 10000, Currency: "RUB"}
+		assert.NoError(t, st.Session().Create(session))
+	}
+	assert.NoError(t, st.Session().CommitSession(sessions[2], now))
+
+	swept, err := s.SweepExpired()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, swept)
+
+	expected := map[string]model.SessionStatus{
+		"abandoned": model.StatusExpired,
+		"fresh":     model.StatusCreated,
+		"paid":      model.StatusPaid,
+	}
+	for token, status := range expected {
+		session, err := st.Session().FindByToken(store.AllMerchants(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, status, session.Status, token)
+	}
+
+	drainEvents(t, s, st)
+	deliveries, err := st.Webhook().FindBySession(sessions[0])
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, model.EventSessionExpired, deliveries[0].Event)
+
+	swept, err = s.SweepExpired()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, swept)
+
+	// Метрики процесса доступны только администратору
+	rec := doRequest(s, http.MethodGet, "/debug/vars", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	auth := map[string]string{"Authorization": "Bearer " + getTokenWithRole(t, s, st, model.RoleAnalyst)}
+	rec = doRequest(s, http.MethodGet, "/debug/vars", nil, auth)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	auth = map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+	rec = doRequest(s, http.MethodGet, "/debug/vars", nil, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	vars := struct {
+		Sweeper map[string]int64 `json:"expiry_sweeper"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&vars))
+	assert.GreaterOrEqual(t, vars.Sweeper["swept_total"], int64(1))
+	assert.Equal(t, int64(0), vars.Sweeper["last_swept"])
+}
+
+// Тестирование фоновой отмены авторизаций, не списанных за authorization_ttl
+func Test_SweepAuthorizations(t *testing.T) {
+	config := newTestConfig()
+	config.Webhooks.URL = "http://localhost/hook"
+	st := teststore.New()
+	newTestMerchant(t, st)
+	s := newServer(config, st)
+
+	now := time.Now().UTC()
+	authorize := func(token string, authorizedAt time.Time) *model.Session {
+		session := &model.Session{
+			SessionToken: token,
+			Amount:       model.Money{Minor: 10000, Currency: "RUB"},
+			CaptureMode:  model.CaptureManual,
+			CreatedAt:    authorizedAt,
+			ExpiresAt:    authorizedAt.Add(time.Hour),
+		}
+		assert.NoError(t, st.Session().Create(session))
+		assert.NoError(t, st.Session().UpdateStatus(session, model.StatusProcessing, authorizedAt))
+		session.AuthorizationID = "auth_" + token
+		session.AuthorizedAt = &authorizedAt
+		assert.NoError(t, st.Session().UpdateStatus(session, model.StatusAuthorized, authorizedAt))
+		return session
+	}
+
+	overdue := authorize("overdue", now.Add(-config.AuthorizationTTL.Duration-time.Hour))
+	recent := authorize("recent", now.Add(-time.Hour))
+
+	swept, err := s.SweepAuthorizations()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, swept)
+
+	expected := map[string]model.SessionStatus{
+		overdue.SessionToken: model.StatusExpired,
+		recent.SessionToken:  model.StatusAuthorized,
+	}
+	for token, status := range expected {
+		session, err := st.Session().FindByToken(store.AllMerchants(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, status, session.Status, token)
+	}
+
+	stored, err := st.Session().FindByToken(store.AllMerchants(), overdue.SessionToken)
+	assert.NoError(t, err)
+	drainEvents(t, s, st)
+	deliveries, err := st.Webhook().FindBySession(stored)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, model.EventSessionExpired, deliveries[0].Event)
+	}
+
+	// Отмененную авторизацию уже нельзя списать
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+	rec := doRequest(s, http.MethodPost, "/session/"+overdue.SessionToken+"/capture", nil, auth)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	swept, err = s.SweepAuthorizations()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, swept)
+}
+
+// Тестирование фонового отклонения сессий, зависших в processing
+func Test_SweepProcessing(t *testing.T) {
+	config := newTestConfig()
+	config.Webhooks.URL = "http://localhost/hook"
+	st := teststore.New()
+	newTestMerchant(t, st)
+	s := newServer(config, st)
+
+	// Время жизни сессий еще не истекло: таймаут отсчитывается
+	// от начала обработки платежа, а не от expires_at
+	now := time.Now().UTC()
+	processingAt := map[string]time.Time{
+		"stale":  now.Add(-20 * time.Minute),
+		"recent": now.Add(-time.Minute),
+	}
+	for _, token := range []string{"stale", "recent", "abandoned"} {
+		session := &model.Session{
+			SessionToken: token,
+			Amount:       model.Money{Minor: 10000, Currency: "RUB"},
+			CreatedAt:    now.Add(-time.Hour),
+			ExpiresAt:    now.Add(24 * time.Hour),
+		}
+		assert.NoError(t, st.Session().Create(session))
+		if at, ok := processingAt[token]; ok {
+			assert.NoError(t, st.Session().UpdateStatus(session, model.StatusProcessing, at))
+		}
+	}
+
+	swept, err := s.SweepProcessing()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, swept)
+
+	expected := map[string]model.SessionStatus{
+		"stale":     model.StatusDeclined,
+		"recent":    model.StatusProcessing,
+		"abandoned": model.StatusCreated,
+	}
+	for token, status := range expected {
+		session, err := st.Session().FindByToken(store.AllMerchants(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, status, session.Status, token)
+	}
+
+	stale, err := st.Session().FindByToken(store.AllMerchants(), "stale")
+	assert.NoError(t, err)
+	assert.Equal(t, "processing_timeout", stale.DeclineReason)
+
+	drainEvents(t, s, st)
+	deliveries, err := st.Webhook().FindBySession(stale)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, model.EventSessionDeclined, deliveries[0].Event)
+	}
+
+	swept, err = s.SweepProcessing()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, swept)
+}
+
+// Тестирование остановки сервера вместе с фоновыми задачами
+func Test_ServerShutdown(t *testing.T) {
+	config := apiserver.NewConfig()
+	config.BindAddr = "127.0.0.1:0"
+	config.SweepInterval.Duration = time.Millisecond
+	s := newServer(config, teststore.New())
+
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start()
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.NoError(t, <-started)
+}
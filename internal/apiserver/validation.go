@@ -1,14 +1,20 @@
 package apiserver
 
 import (
+	"github.com/bolshagin/xsolla-be-2020/internal/webhook"
+	"net"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 var (
 	notNumberRegexp = regexp.MustCompile("[^0-9]+")
 	dateRegexp      = regexp.MustCompile("^(1[0-2]|[1-9])[\\/][0-9][0-9]$")
 	codeRegexp      = regexp.MustCompile("^[0-9][0-9][0-9]$")
+	currencyRegexp  = regexp.MustCompile("^[A-Z]{3}$")
+	decimalRegexp   = regexp.MustCompile("^[0-9]+(\\.[0-9]+)?$")
 )
 
 func IsCreditCard(s string) bool {
@@ -51,3 +57,38 @@ func IsCardDate(s string) bool {
 func IsCardCode(s string) bool {
 	return codeRegexp.MatchString(s)
 }
+
+func IsCurrencyCode(s string) bool {
+	return currencyRegexp.MatchString(s)
+}
+
+// Десятичная запись без знака и экспоненты, например "10" или "10.50"
+func IsDecimal(s string) bool {
+	return decimalRegexp.MatchString(s)
+}
+
+func IsCallbackURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Адрес не указывает на localhost или IP-адрес внутренней сети. Имена,
+// которые разрешаются во внутренние адреса, отсекаются при отправке
+func IsPublicURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return !webhook.IsPrivateIP(ip)
+	}
+	return true
+}
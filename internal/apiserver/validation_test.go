@@ -123,3 +123,151 @@ func TestIsCardCode(t *testing.T) {
 		})
 	}
 }
+
+// Тестирование функции по проверке адреса для уведомлений мерчанта
+func TestIsCallbackURL(t *testing.T) {
+	testCases := []struct {
+		name    string
+		url     string
+		isValid bool
+	}{
+		{
+			name:    "valid https",
+			url:     "https://merchant.example.com/hooks/payments",
+			isValid: true,
+		},
+		{
+			name:    "valid http with port",
+			url:     "http://localhost:9000/hook",
+			isValid: true,
+		},
+		{
+			name:    "relative path",
+			url:     "/hook",
+			isValid: false,
+		},
+		{
+			name:    "other scheme",
+			url:     "ftp://merchant.example.com/hook",
+			isValid: false,
+		},
+		{
+			name:    "empty",
+			url:     "",
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.isValid, apiserver.IsCallbackURL(tc.url))
+		})
+	}
+}
+
+// Тестирование функции по проверке, что адрес уведомлений не указывает во внутреннюю сеть
+func TestIsPublicURL(t *testing.T) {
+	testCases := []struct {
+		name    string
+		url     string
+		isValid bool
+	}{
+		{
+			name:    "public host",
+			url:     "https://merchant.example.com/hooks/payments",
+			isValid: true,
+		},
+		{
+			name:    "public ip",
+			url:     "http://93.184.216.34/hook",
+			isValid: true,
+		},
+		{
+			name:    "localhost",
+			url:     "http://localhost:9000/hook",
+			isValid: false,
+		},
+		{
+			name:    "loopback",
+			url:     "http://127.0.0.1/hook",
+			isValid: false,
+		},
+		{
+			name:    "private range",
+			url:     "http://192.168.1.10/hook",
+			isValid: false,
+		},
+		{
+			name:    "link-local",
+			url:     "http://169.254.169.254/latest/meta-data",
+			isValid: false,
+		},
+		{
+			name:    "ipv6 loopback",
+			url:     "http://[::1]:9000/hook",
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.isValid, apiserver.IsPublicURL(tc.url))
+		})
+	}
+}
+
+// Тестирование функции по проверке границ суммы в строке запроса статистики
+func TestIsDecimal(t *testing.T) {
+	testCases := []struct {
+		name    string
+		value   string
+		isValid bool
+	}{
+		{
+			name:    "integer",
+			value:   "500",
+			isValid: true,
+		},
+		{
+			name:    "fraction digits",
+			value:   "10.50",
+			isValid: true,
+		},
+		{
+			name:    "rational",
+			value:   "1/2",
+			isValid: false,
+		},
+		{
+			name:    "exponent",
+			value:   "1e3",
+			isValid: false,
+		},
+		{
+			name:    "hex",
+			value:   "0x10",
+			isValid: false,
+		},
+		{
+			name:    "sign",
+			value:   "+10",
+			isValid: false,
+		},
+		{
+			name:    "trailing dot",
+			value:   "10.",
+			isValid: false,
+		},
+		{
+			name:    "empty",
+			value:   "",
+			isValid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.isValid, apiserver.IsDecimal(tc.value))
+		})
+	}
+}
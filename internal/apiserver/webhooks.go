@@ -0,0 +1,138 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/gorilla/mux"
+	"net/http"
+	"time"
+)
+
+type webhookPayload struct {
+	EventID        uint                `json:"event_id"`
+	Event          model.WebhookEvent  `json:"event"`
+	SessionToken   string              `json:"session_token"`
+	Status         model.SessionStatus `json:"status"`
+	Amount         model.Money         `json:"amount"`
+	Currency       string              `json:"currency"`
+	CapturedAmount model.Money         `json:"captured_amount"`
+	RefundAmount   *model.Money        `json:"refund_amount,omitempty"`
+	DeclineReason  string              `json:"decline_reason,omitempty"`
+	OccurredAt     time.Time           `json:"occurred_at"`
+}
+
+// Уведомление мерчанта, соответствующее событию outbox. Переходы
+// в остальные статусы мерчанту не отправляются
+func webhookEvent(e *model.OutboxEvent) (model.WebhookEvent, bool) {
+	switch e.EventType {
+	case model.OutboxRefundCreated:
+		return model.EventSessionRefunded, true
+	case model.OutboxSessionStatusChanged:
+		switch e.Status {
+		case model.StatusPaid:
+			return model.EventSessionPaid, true
+		case model.StatusDeclined:
+			return model.EventSessionDeclined, true
+		case model.StatusExpired:
+			return model.EventSessionExpired, true
+		}
+	}
+	return "", false
+}
+
+// Подписчик издателя outbox: записывает уведомление в журнал доставок.
+// Событие попадает сюда только после фиксации изменения сессии, поэтому
+// уведомление не теряется при ошибке записи и не уходит об откаченном
+// изменении. Реле доставляет события как минимум один раз, мерчант может
+// отбросить повтор по event_id
+func (s *APIServer) enqueueWebhook(e *model.OutboxEvent) error {
+	event, ok := webhookEvent(e)
+	if !ok {
+		return nil
+	}
+
+	session, err := s.store.Session().FindByToken(store.AllMerchants(), e.SessionToken)
+	if err != nil {
+		return err
+	}
+
+	url := s.callbackURL(session)
+	if url == "" {
+		return nil
+	}
+
+	payload := &webhookPayload{
+		EventID:        e.EventID,
+		Event:          event,
+		SessionToken:   session.SessionToken,
+		Status:         e.Status,
+		Amount:         session.Amount,
+		Currency:       session.Amount.Currency,
+		CapturedAmount: model.Money{Minor: session.Captured.Minor, Currency: session.Amount.Currency},
+		DeclineReason:  session.DeclineReason,
+		OccurredAt:     e.CreatedAt,
+	}
+	if e.EventType == model.OutboxRefundCreated {
+		refund := struct {
+			Amount json.Number `json:"amount"`
+		}{}
+		if err := json.Unmarshal(e.Payload, &refund); err != nil {
+			return err
+		}
+
+		amount, err := model.ParseMoney(refund.Amount.String(), session.Amount.Currency)
+		if err != nil {
+			return err
+		}
+		payload.RefundAmount = &amount
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delivery := &model.WebhookDelivery{
+		SessionID:     session.SessionID,
+		MerchantID:    session.MerchantID,
+		Event:         event,
+		URL:           url,
+		Payload:       data,
+		CreatedAt:     s.now(),
+		NextAttemptAt: s.now(),
+	}
+
+	if err := s.store.Webhook().Create(delivery); err != nil {
+		return err
+	}
+
+	s.logger.Info(fmt.Sprintf("webhook %v queued for session %v", event, session.SessionToken))
+	s.webhooks.Notify()
+	return nil
+}
+
+func (s *APIServer) handleSessionWebhooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := s.store.Session().FindByToken(s.scope(r), mux.Vars(r)["token"])
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, findErrorCode(err), err)
+			return
+		}
+
+		deliveries, err := s.store.Webhook().FindBySession(session)
+		if err != nil {
+			s.logger.Error(err)
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if deliveries == nil {
+			deliveries = []model.WebhookDelivery{}
+		}
+
+		s.respond(w, r, http.StatusOK, map[string]interface{}{"deliveries": deliveries})
+	}
+}
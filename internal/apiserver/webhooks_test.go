@@ -0,0 +1,156 @@
+package apiserver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/internal/apiserver"
+	"github.com/bolshagin/xsolla-be-2020/internal/webhook"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Вспомогательный получатель уведомлений, проверяющий подпись каждого запроса
+type webhookReceiver struct {
+	mu     sync.Mutex
+	secret []byte
+	events []map[string]interface{}
+	valid  bool
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+	rc.valid = webhook.Verify(rc.secret, timestamp, body, r.Header.Get(webhook.SignatureHeader))
+
+	event := map[string]interface{}{}
+	json.Unmarshal(body, &event)
+	rc.events = append(rc.events, event)
+}
+
+// Тестирование уведомлений мерчанта об оплате и возврате, подписанных его секретом
+func Test_SessionWebhooks(t *testing.T) {
+	config := apiserver.NewConfig()
+	config.Webhooks.AllowPrivateNetworks = true
+	config.Webhooks.Secret = "global-secret"
+	st := teststore.New()
+	m := newTestMerchant(t, st)
+	s := newServer(config, st)
+
+	receiver := &webhookReceiver{secret: []byte(m.WebhookSecret)}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	worker := webhook.NewWorker(st.Webhook(), st.Merchant(), config.Webhooks.Secret, logrus.New())
+	worker.AllowPrivateNetworks = true
+
+	rec := doRequest(s, http.MethodPost, "/session", []byte(`{"amount":10,"callback_url":"ftp://merchant"}`), map[string]string{"X-Api-Key": apiKey})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	session := createSessionWith(t, s, fmt.Sprintf(`{"amount":%v,"purpose":"%v","callback_url":"%v"}`, amount, purpose, srv.URL))
+	assert.Equal(t, srv.URL, session.CallbackURL)
+	paySession(t, s, session)
+
+	auth := map[string]string{"Authorization": "Bearer " + getToken(t, s, st)}
+	rec = doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/refund", []byte(`{"amount":10}`), auth)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	drainEvents(t, s, st)
+	assert.Equal(t, 2, worker.ProcessDue())
+	assert.True(t, receiver.valid)
+	assert.Len(t, receiver.events, 2)
+	assert.Equal(t, string(model.EventSessionPaid), receiver.events[0]["event"])
+	assert.Equal(t, session.SessionToken, receiver.events[0]["session_token"])
+	assert.NotEqual(t, receiver.events[0]["event_id"], receiver.events[1]["event_id"])
+	assert.Equal(t, 100.1, receiver.events[0]["captured_amount"])
+	assert.Equal(t, string(model.EventSessionRefunded), receiver.events[1]["event"])
+	assert.Equal(t, 10.0, receiver.events[1]["refund_amount"])
+
+	// После ротации секрета уведомления подписываются новым
+	rotated, err := model.NewWebhookSecret()
+	assert.NoError(t, err)
+	m.WebhookSecret = rotated
+	assert.NoError(t, st.Merchant().UpdateWebhookSecret(m))
+
+	rec = doRequest(s, http.MethodPost, "/session/"+session.SessionToken+"/refund", []byte(`{"amount":10}`), auth)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	drainEvents(t, s, st)
+	receiver.secret = []byte(rotated)
+	assert.Equal(t, 1, worker.ProcessDue())
+	assert.True(t, receiver.valid)
+
+	target := "/session/" + session.SessionToken + "/webhooks"
+	rec = doRequest(s, http.MethodGet, target, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = doRequest(s, http.MethodGet, target, nil, auth)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	resp := struct {
+		Deliveries []model.WebhookDelivery `json:"deliveries"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Len(t, resp.Deliveries, 3)
+	assert.Equal(t, model.DeliveryDelivered, resp.Deliveries[0].Status)
+}
+
+// Тестирование уведомления об отклонении на адрес мерчанта из конфига
+func Test_SessionWebhooksDeclined(t *testing.T) {
+	receiver := &webhookReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	config := apiserver.NewConfig()
+	config.Webhooks.AllowPrivateNetworks = true
+	config.Webhooks.URL = srv.URL
+	st := teststore.New()
+	newTestMerchant(t, st)
+	s := newServer(config, st)
+
+	session := createSession(t, s)
+	data := []byte(fmt.Sprintf(
+		`{"session_token":"%v","card_number":"4000 0000 0000 0002","code":"%v","date":"%v"}`,
+		session.SessionToken,
+		cardCode,
+		cardDate,
+	))
+	rec := doRequest(s, http.MethodPost, "/pay", data, nil)
+	assert.Equal(t, http.StatusPaymentRequired, rec.Code)
+
+	drainEvents(t, s, st)
+	worker := webhook.NewWorker(st.Webhook(), st.Merchant(), config.Webhooks.Secret, logrus.New())
+	worker.AllowPrivateNetworks = true
+	assert.Equal(t, 1, worker.ProcessDue())
+	assert.Equal(t, string(model.EventSessionDeclined), receiver.events[0]["event"])
+	assert.Equal(t, "card_declined", receiver.events[0]["decline_reason"])
+}
+
+// Тестирование запрета callback_url на адреса внутренней сети
+func Test_SessionPrivateCallbackURL(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for _, callbackURL := range []string{
+		"http://localhost:8080/hook",
+		"http://127.0.0.1/hook",
+		"http://10.0.0.5/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hook",
+	} {
+		rec := doRequest(s, http.MethodPost, "/session", []byte(fmt.Sprintf(`{"amount":10,"callback_url":"%v"}`, callbackURL)), map[string]string{"X-Api-Key": apiKey})
+		assert.Equal(t, http.StatusBadRequest, rec.Code, callbackURL)
+		assert.Contains(t, rec.Body.String(), "private network", callbackURL)
+	}
+
+	rec := doRequest(s, http.MethodPost, "/session", []byte(`{"amount":10,"callback_url":"https://shop.example.org/hook"}`), map[string]string{"X-Api-Key": apiKey})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
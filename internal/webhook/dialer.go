@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var ErrPrivateAddress = errors.New("webhook url points to a private network address")
+
+// Адреса внутренней сети, куда уведомления не отправляются: иначе
+// мерчант мог бы через callback_url опрашивать внутренние сервисы
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func IsPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Проверяет адрес уже после разрешения имени, поэтому запрет нельзя
+// обойти доменом, который указывает на внутренний адрес
+func checkAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || IsPrivateIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 8
+	defaultBackoff     = 10 * time.Second
+	defaultMaxBackoff  = time.Hour
+	defaultInterval    = 5 * time.Second
+	defaultTimeout     = 10 * time.Second
+	defaultBatchSize   = 100
+	maxErrorLen        = 255
+)
+
+// Фоновый обработчик журнала доставок: отправляет ожидающие уведомления
+// и при неудаче откладывает следующую попытку с экспоненциальной задержкой.
+// На адреса внутренней сети уведомления отправляются, только если
+// выставлен AllowPrivateNetworks
+type Worker struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Interval    time.Duration
+	BatchSize   int
+
+	AllowPrivateNetworks bool
+
+	repo      store.WebhookRepository
+	merchants store.MerchantRepository
+	client    *http.Client
+	secret    []byte
+	logger    *logrus.Logger
+	wake      chan struct{}
+	now       func() time.Time
+}
+
+// Уведомления подписываются секретом мерчанта сессии. Общий секрет secret
+// подписывает только уведомления по сессиям, созданным без мерчанта
+func NewWorker(repo store.WebhookRepository, merchants store.MerchantRepository, secret string, logger *logrus.Logger) *Worker {
+	w := &Worker{
+		MaxAttempts: defaultMaxAttempts,
+		Backoff:     defaultBackoff,
+		MaxBackoff:  defaultMaxBackoff,
+		Interval:    defaultInterval,
+		BatchSize:   defaultBatchSize,
+		repo:        repo,
+		merchants:   merchants,
+		secret:      []byte(secret),
+		logger:      logger,
+		wake:        make(chan struct{}, 1),
+		now: func() time.Time {
+			return time.Now().UTC()
+		},
+	}
+
+	// Прокси не используется: адрес проверяется при установке соединения,
+	// а через прокси соединение устанавливалось бы с ним, а не с получателем
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   w.control,
+	}).DialContext
+	w.client = &http.Client{Timeout: defaultTimeout, Transport: transport}
+
+	return w
+}
+
+func (w *Worker) control(network, address string, c syscall.RawConn) error {
+	if w.AllowPrivateNetworks {
+		return nil
+	}
+	return checkAddress(network, address, c)
+}
+
+func (w *Worker) SetTimeout(timeout time.Duration) {
+	w.client.Timeout = timeout
+}
+
+// Будит обработчик, не дожидаясь очередного интервала опроса
+func (w *Worker) Notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		w.ProcessDue()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// Выполняет одну попытку для каждой доставки, время которой наступило,
+// и возвращает количество обработанных доставок
+func (w *Worker) ProcessDue() int {
+	deliveries, err := w.repo.FindDue(w.now(), w.BatchSize)
+	if err != nil {
+		w.logger.Error(err)
+		return 0
+	}
+
+	for _, d := range deliveries {
+		w.deliver(d)
+		if err := w.repo.Update(d); err != nil {
+			w.logger.Error(err)
+		}
+	}
+
+	return len(deliveries)
+}
+
+func (w *Worker) deliver(d *model.WebhookDelivery) {
+	d.Attempts++
+
+	code, err := w.send(d)
+	d.ResponseCode = code
+
+	now := w.now()
+	if err == nil {
+		d.Status = model.DeliveryDelivered
+		d.LastError = ""
+		d.DeliveredAt = &now
+		w.logger.Info(fmt.Sprintf("webhook %v delivered to %v", d.DeliveryID, d.URL))
+		return
+	}
+
+	d.LastError = err.Error()
+	if len(d.LastError) > maxErrorLen {
+		d.LastError = d.LastError[:maxErrorLen]
+	}
+
+	if d.Attempts >= w.MaxAttempts {
+		d.Status = model.DeliveryFailed
+		w.logger.Error(fmt.Sprintf("webhook %v failed after %v attempts: %v", d.DeliveryID, d.Attempts, err))
+		return
+	}
+
+	d.NextAttemptAt = now.Add(w.backoff(d.Attempts))
+	w.logger.Warn(fmt.Sprintf("webhook %v attempt %v failed: %v", d.DeliveryID, d.Attempts, err))
+}
+
+func (w *Worker) send(d *model.WebhookDelivery) (int, error) {
+	secret, err := w.signingSecret(d)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := w.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(d.Event))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(d.DeliveryID), 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, d.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %v", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Секрет читается перед каждой попыткой, поэтому после ротации
+// повторные попытки подписываются уже новым секретом
+func (w *Worker) signingSecret(d *model.WebhookDelivery) ([]byte, error) {
+	if d.MerchantID == 0 {
+		return w.secret, nil
+	}
+
+	m, err := w.merchants.Find(d.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+	if m.WebhookSecret == "" {
+		return nil, fmt.Errorf("merchant %v has no webhook secret", m.MerchantID)
+	}
+
+	return []byte(m.WebhookSecret), nil
+}
+
+// Задержка удваивается после каждой неудачной попытки, но не превышает MaxBackoff
+func (w *Worker) backoff(attempts int) time.Duration {
+	delay := w.Backoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= w.MaxBackoff {
+			return w.MaxBackoff
+		}
+	}
+	return delay
+}
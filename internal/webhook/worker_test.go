@@ -0,0 +1,172 @@
+package webhook_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/internal/webhook"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const secret = "test-secret"
+
+// Вспомогательная функция для постановки доставки в журнал
+func queueDelivery(t *testing.T, st *teststore.Store, url string, merchantID uint) *model.WebhookDelivery {
+	t.Helper()
+
+	now := time.Now().UTC()
+	d := &model.WebhookDelivery{
+		SessionID:     1,
+		MerchantID:    merchantID,
+		Event:         model.EventSessionPaid,
+		URL:           url,
+		Payload:       []byte(`{"event":"session.paid"}`),
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	if err := st.Webhook().Create(d); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// Тестирование доставки подписанного уведомления
+func TestWorker_Deliver(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		received <- r
+	}))
+	defer receiver.Close()
+
+	st := teststore.New()
+	queueDelivery(t, st, receiver.URL, 0)
+
+	worker := webhook.NewWorker(st.Webhook(), st.Merchant(), secret, logrus.New())
+	worker.AllowPrivateNetworks = true
+	assert.Equal(t, 1, worker.ProcessDue())
+
+	r := <-received
+	timestamp, err := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, string(model.EventSessionPaid), r.Header.Get(webhook.EventHeader))
+	assert.True(t, webhook.Verify([]byte(secret), timestamp, body, r.Header.Get(webhook.SignatureHeader)))
+	assert.False(t, webhook.Verify([]byte("other"), timestamp, body, r.Header.Get(webhook.SignatureHeader)))
+
+	deliveries, err := st.Webhook().FindBySession(&model.Session{SessionID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, model.DeliveryDelivered, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusOK, deliveries[0].ResponseCode)
+	assert.NotNil(t, deliveries[0].DeliveredAt)
+
+	assert.Equal(t, 0, worker.ProcessDue())
+}
+
+// Тестирование подписи уведомления секретом мерчанта сессии
+func TestWorker_MerchantSecret(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		received <- r
+	}))
+	defer receiver.Close()
+
+	st := teststore.New()
+	m := &model.Merchant{Name: "shop", CreatedAt: time.Now().UTC()}
+	assert.NoError(t, st.Merchant().Create(m))
+
+	queueDelivery(t, st, receiver.URL, m.MerchantID)
+	queueDelivery(t, st, receiver.URL, m.MerchantID+1)
+
+	worker := webhook.NewWorker(st.Webhook(), st.Merchant(), secret, logrus.New())
+	worker.AllowPrivateNetworks = true
+	assert.Equal(t, 2, worker.ProcessDue())
+
+	r := <-received
+	timestamp, err := strconv.ParseInt(r.Header.Get(webhook.TimestampHeader), 10, 64)
+	assert.NoError(t, err)
+	assert.True(t, webhook.Verify([]byte(m.WebhookSecret), timestamp, body, r.Header.Get(webhook.SignatureHeader)))
+	assert.False(t, webhook.Verify([]byte(secret), timestamp, body, r.Header.Get(webhook.SignatureHeader)))
+
+	// Уведомление неизвестного мерчанта не подписывается общим секретом
+	deliveries, err := st.Webhook().FindBySession(&model.Session{SessionID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, model.DeliveryDelivered, deliveries[0].Status)
+	assert.Equal(t, model.DeliveryPending, deliveries[1].Status)
+	assert.Equal(t, 0, deliveries[1].ResponseCode)
+	assert.NotEmpty(t, deliveries[1].LastError)
+}
+
+// Тестирование повторных попыток доставки с экспоненциальной задержкой
+func TestWorker_Retry(t *testing.T) {
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	st := teststore.New()
+	queueDelivery(t, st, receiver.URL, 0)
+
+	worker := webhook.NewWorker(st.Webhook(), st.Merchant(), secret, logrus.New())
+	worker.AllowPrivateNetworks = true
+	worker.Backoff = time.Minute
+
+	before := time.Now().UTC()
+	assert.Equal(t, 1, worker.ProcessDue())
+	assert.Equal(t, 0, worker.ProcessDue())
+
+	deliveries, _ := st.Webhook().FindBySession(&model.Session{SessionID: 1})
+	assert.Equal(t, model.DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, deliveries[0].ResponseCode)
+	assert.NotEmpty(t, deliveries[0].LastError)
+	assert.True(t, deliveries[0].NextAttemptAt.After(before.Add(59*time.Second)))
+
+	// Без задержки каждая попытка выполняется сразу, пока не исчерпан лимит
+	worker.Backoff = 0
+	worker.MaxAttempts = 3
+	deliveries[0].NextAttemptAt = before
+	assert.NoError(t, st.Webhook().Update(&deliveries[0]))
+
+	assert.Equal(t, 1, worker.ProcessDue())
+	assert.Equal(t, 1, worker.ProcessDue())
+	assert.Equal(t, 0, worker.ProcessDue())
+
+	deliveries, _ = st.Webhook().FindBySession(&model.Session{SessionID: 1})
+	assert.Equal(t, model.DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+	assert.Equal(t, 3, calls)
+}
+
+// Тестирование запрета доставки на адреса внутренней сети
+func TestWorker_PrivateAddress(t *testing.T) {
+	received := make(chan struct{}, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer receiver.Close()
+
+	st := teststore.New()
+	queueDelivery(t, st, receiver.URL, 0)
+
+	worker := webhook.NewWorker(st.Webhook(), st.Merchant(), secret, logrus.New())
+	assert.Equal(t, 1, worker.ProcessDue())
+	assert.Len(t, received, 0)
+
+	deliveries, err := st.Webhook().FindBySession(&model.Session{SessionID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, model.DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, 0, deliveries[0].ResponseCode)
+	assert.Contains(t, deliveries[0].LastError, webhook.ErrPrivateAddress.Error())
+}
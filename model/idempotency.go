@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// Сохраненный результат запроса с заголовком Idempotency-Key.
+// StatusCode равен нулю, пока первый запрос с ключом еще выполняется
+type IdempotencyKey struct {
+	Scope       string
+	Key         string
+	RequestHash string
+	StatusCode  int
+	Response    []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func (k *IdempotencyKey) IsCompleted() bool {
+	return k.StatusCode != 0
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	apiKeyPrefix       = "sk_"
+	apiKeyPrefixLength = len(apiKeyPrefix) + 12
+	apiKeySecretLength = 32
+
+	webhookSecretPrefix = "whsec_"
+	webhookSecretLength = 24
+)
+
+var (
+	ErrInvalidMerchantName = errors.New("merchant name must be from 1 to 255 symbols")
+	ErrInvalidSessionTTL   = errors.New("merchant session ttl must not be negative")
+)
+
+// Мерчант, от имени которого создаются платежные сессии. Если CallbackURL
+// или SessionTTL не заданы, используются значения из конфига сервера.
+// WebhookSecret подписывает уведомления мерчанта, поэтому хранится открыто
+type Merchant struct {
+	MerchantID    uint          `json:"merchant_id"`
+	Name          string        `json:"name"`
+	CallbackURL   string        `json:"callback_url,omitempty"`
+	SessionTTL    time.Duration `json:"-"`
+	WebhookSecret string        `json:"-"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+func (m *Merchant) Validate() error {
+	m.Name = strings.TrimSpace(m.Name)
+	if m.Name == "" || len(m.Name) > 255 {
+		return ErrInvalidMerchantName
+	}
+
+	if m.SessionTTL < 0 {
+		return ErrInvalidSessionTTL
+	}
+
+	return nil
+}
+
+// Новому мерчанту выпускается секрет подписи уведомлений, если он не задан
+func (m *Merchant) BeforeCreate() error {
+	if m.WebhookSecret != "" {
+		return nil
+	}
+
+	secret, err := NewWebhookSecret()
+	if err != nil {
+		return err
+	}
+
+	m.WebhookSecret = secret
+	return nil
+}
+
+// Создает секрет подписи уведомлений вида whsec_<48 hex>. Для ротации
+// мерчанту выпускается новый секрет, который сразу заменяет старый
+func NewWebhookSecret() (string, error) {
+	secret := make([]byte, webhookSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + hex.EncodeToString(secret), nil
+}
+
+// API-ключ мерчанта вида sk_<12 hex>_<секрет>. Префикс хранится открыто
+// и служит для поиска и опознания ключа, сам ключ хранится SHA-256 хешем.
+// Для ротации мерчанту выпускается новый ключ, а старый отзывается
+type APIKey struct {
+	KeyID      uint       `json:"-"`
+	MerchantID uint       `json:"merchant_id"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Создает API-ключ мерчанта и возвращает его вместе со значением,
+// которое показывается мерчанту один раз
+func NewAPIKey(merchantID uint, at time.Time) (*APIKey, string, error) {
+	id := make([]byte, (apiKeyPrefixLength-len(apiKeyPrefix))/2)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+
+	secret := make([]byte, apiKeySecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+
+	prefix := apiKeyPrefix + hex.EncodeToString(id)
+	value := prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return &APIKey{
+		MerchantID: merchantID,
+		Prefix:     prefix,
+		KeyHash:    HashToken(value),
+		CreatedAt:  at,
+	}, value, nil
+}
+
+// Возвращает префикс ключа, по которому его можно найти в хранилище
+func APIKeyPrefix(value string) (string, bool) {
+	if len(value) <= apiKeyPrefixLength+1 || !strings.HasPrefix(value, apiKeyPrefix) || value[apiKeyPrefixLength] != '_' {
+		return "", false
+	}
+	return value[:apiKeyPrefixLength], true
+}
+
+func (k *APIKey) Matches(value string) bool {
+	return subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(HashToken(value))) == 1
+}
+
+func (k *APIKey) IsActive() bool {
+	return k.RevokedAt == nil
+}
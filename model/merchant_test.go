@@ -0,0 +1,57 @@
+package model_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Тестирование выпуска API-ключа мерчанта и разбора его префикса
+func TestNewAPIKey(t *testing.T) {
+	key, value, err := model.NewAPIKey(1, time.Now())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(value, key.Prefix+"_"))
+	assert.Equal(t, uint(1), key.MerchantID)
+	assert.NotContains(t, key.KeyHash, value)
+	assert.True(t, key.Matches(value))
+	assert.False(t, key.Matches(value+"x"))
+
+	prefix, ok := model.APIKeyPrefix(value)
+	assert.True(t, ok)
+	assert.Equal(t, key.Prefix, prefix)
+
+	other, _, err := model.NewAPIKey(1, time.Now())
+	assert.NoError(t, err)
+	assert.NotEqual(t, key.Prefix, other.Prefix)
+
+	for _, value := range []string{"", "sk_", key.Prefix, key.Prefix + "_", "pk" + value[2:], key.Prefix + "-secret"} {
+		_, ok := model.APIKeyPrefix(value)
+		assert.False(t, ok, value)
+	}
+}
+
+// Тестирование проверки параметров мерчанта
+func TestMerchant_Validate(t *testing.T) {
+	assert.NoError(t, (&model.Merchant{Name: " Shop "}).Validate())
+	assert.Equal(t, model.ErrInvalidMerchantName, (&model.Merchant{Name: " "}).Validate())
+	assert.Equal(t, model.ErrInvalidMerchantName, (&model.Merchant{Name: strings.Repeat("a", 256)}).Validate())
+	assert.Equal(t, model.ErrInvalidSessionTTL, (&model.Merchant{Name: "Shop", SessionTTL: -time.Second}).Validate())
+}
+
+// Тестирование выпуска секрета подписи уведомлений при создании мерчанта
+func TestMerchant_BeforeCreate(t *testing.T) {
+	m := &model.Merchant{Name: "Shop"}
+	assert.NoError(t, m.BeforeCreate())
+	assert.True(t, strings.HasPrefix(m.WebhookSecret, "whsec_"))
+	assert.Len(t, m.WebhookSecret, 54)
+
+	secret := m.WebhookSecret
+	assert.NoError(t, m.BeforeCreate())
+	assert.Equal(t, secret, m.WebhookSecret)
+
+	other, err := model.NewWebhookSecret()
+	assert.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+}
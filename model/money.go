@@ -0,0 +1,86 @@
+package model
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const DefaultCurrency = "RUB"
+
+var (
+	ErrInvalidAmount     = errors.New("amount must be a decimal number")
+	ErrAmountNotPositive = errors.New("amount must be greater than zero")
+	ErrAmountPrecision   = errors.New("amount has more decimal places than currency allows")
+	ErrAmountTooLarge    = errors.New("amount is too large")
+)
+
+// Количество знаков после запятой для валют, у которых оно отличается от двух
+var currencyExponents = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"BHD": 3,
+	"KWD": 3,
+}
+
+// Денежная сумма в минимальных единицах валюты (копейках, центах)
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+func ParseMoney(s, currency string) (Money, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return Money{}, ErrInvalidAmount
+	}
+
+	if r.Sign() <= 0 {
+		return Money{}, ErrAmountNotPositive
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(CurrencyExponent(currency))), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+	if !r.IsInt() {
+		return Money{}, ErrAmountPrecision
+	}
+
+	if !r.Num().IsInt64() {
+		return Money{}, ErrAmountTooLarge
+	}
+
+	return Money{Minor: r.Num().Int64(), Currency: currency}, nil
+}
+
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func (m Money) String() string {
+	exp := CurrencyExponent(m.Currency)
+	if exp == 0 {
+		return strconv.FormatInt(m.Minor, 10)
+	}
+
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	digits := strconv.FormatInt(minor, 10)
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
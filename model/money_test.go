@@ -0,0 +1,126 @@
+package model_test
+
+import (
+	"encoding/json"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Тестирование разбора денежной суммы
+func TestParseMoney(t *testing.T) {
+	testCases := []struct {
+		name     string
+		amount   string
+		currency string
+		minor    int64
+		err      error
+	}{
+		{
+			name:     "integer",
+			amount:   "1000",
+			currency: "RUB",
+			minor:    100000,
+		},
+		{
+			name:     "two decimals",
+			amount:   "0.30",
+			currency: "USD",
+			minor:    30,
+		},
+		{
+			name:     "exponent",
+			amount:   "1.5e2",
+			currency: "EUR",
+			minor:    15000,
+		},
+		{
+			name:     "zero exponent currency",
+			amount:   "500",
+			currency: "JPY",
+			minor:    500,
+		},
+		{
+			name:     "zero",
+			amount:   "0",
+			currency: "RUB",
+			err:      model.ErrAmountNotPositive,
+		},
+		{
+			name:     "negative",
+			amount:   "-10",
+			currency: "RUB",
+			err:      model.ErrAmountNotPositive,
+		},
+		{
+			name:     "nan",
+			amount:   "NaN",
+			currency: "RUB",
+			err:      model.ErrInvalidAmount,
+		},
+		{
+			name:     "empty",
+			amount:   "",
+			currency: "RUB",
+			err:      model.ErrInvalidAmount,
+		},
+		{
+			name:     "over precision",
+			amount:   "10.001",
+			currency: "RUB",
+			err:      model.ErrAmountPrecision,
+		},
+		{
+			name:     "over precision zero exponent",
+			amount:   "10.5",
+			currency: "JPY",
+			err:      model.ErrAmountPrecision,
+		},
+		{
+			name:     "too large",
+			amount:   "100000000000000000000",
+			currency: "RUB",
+			err:      model.ErrAmountTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := model.ParseMoney(tc.amount, tc.currency)
+			if tc.err != nil {
+				assert.Equal(t, tc.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, model.Money{Minor: tc.minor, Currency: tc.currency}, m)
+		})
+	}
+}
+
+// Тестирование строкового представления денежной суммы
+func TestMoney_String(t *testing.T) {
+	assert.Equal(t, "0.30", model.Money{Minor: 30, Currency: "RUB"}.String())
+	assert.Equal(t, "0.05", model.Money{Minor: 5, Currency: "RUB"}.String())
+	assert.Equal(t, "1000.00", model.Money{Minor: 100000, Currency: "USD"}.String())
+	assert.Equal(t, "-12.34", model.Money{Minor: -1234, Currency: "EUR"}.String())
+	assert.Equal(t, "500", model.Money{Minor: 500, Currency: "JPY"}.String())
+}
+
+// Тестирование сериализации платежной сессии в JSON и обратно
+func TestSession_JSON(t *testing.T) {
+	s := model.Session{
+		SessionToken: "token",
+		Amount:       model.Money{Minor: 30, Currency: "RUB"},
+		Purpose:      "test",
+	}
+
+	data, err := json.Marshal(s)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"amount":0.30`)
+	assert.Contains(t, string(data), `"currency":"RUB"`)
+
+	decoded := model.Session{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, s.Amount, decoded.Amount)
+	assert.Equal(t, s.Purpose, decoded.Purpose)
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	OutboxSessionCreated       = "session.created"
+	OutboxSessionStatusChanged = "session.status_changed"
+	OutboxRefundCreated        = "refund.created"
+)
+
+// Событие, записанное в outbox в одной транзакции с изменением сессии.
+// PublishedAt заполняется после того, как событие принял издатель
+type OutboxEvent struct {
+	EventID      uint            `json:"event_id"`
+	EventType    string          `json:"event_type"`
+	SessionID    uint            `json:"-"`
+	SessionToken string          `json:"session_token"`
+	Status       SessionStatus   `json:"status"`
+	Payload      json.RawMessage `json:"payload"`
+	CreatedAt    time.Time       `json:"created_at"`
+	PublishedAt  *time.Time      `json:"published_at,omitempty"`
+}
+
+func NewSessionEvent(eventType string, s *Session, payload interface{}, at time.Time) (*OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxEvent{
+		EventType:    eventType,
+		SessionID:    s.SessionID,
+		SessionToken: s.SessionToken,
+		Status:       s.Status,
+		Payload:      data,
+		CreatedAt:    at,
+	}, nil
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+type RefundStatus string
+
+const (
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundFailed    RefundStatus = "failed"
+)
+
+// Возврат сначала резервируется в статусе pending и только после ответа
+// эквайера становится succeeded или failed. Зарезервированная сумма
+// учитывается в остатке сессии, чтобы параллельные возвраты не превысили его
+type Refund struct {
+	RefundID  uint         `json:"refund_id"`
+	SessionID uint         `json:"-"`
+	Amount    Money        `json:"amount"`
+	Status    RefundStatus `json:"status"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Занимает ли возврат часть остатка сессии
+func (r *Refund) IsReserved() bool {
+	return r.Status != RefundFailed
+}
@@ -0,0 +1,18 @@
+package model
+
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"
+	RoleMerchant Role = "merchant"
+	RoleAnalyst  Role = "analyst"
+	RoleSupport  Role = "support"
+)
+
+func (r Role) IsValid() bool {
+	switch r {
+	case RoleAdmin, RoleMerchant, RoleAnalyst, RoleSupport:
+		return true
+	}
+	return false
+}
@@ -1,14 +1,68 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type Session struct {
-	SessionID    uint      `json:"-"`
-	SessionToken string    `json:"session_token,omitempty"`
-	Amount       float64   `json:"amount"`
-	Purpose      string    `json:"purpose"`
-	CreatedAt    time.Time `json:"created_at"`
-	ClosedAt     time.Time `json:"closed_at,omitempty"`
+	SessionID       uint          `json:"-"`
+	MerchantID      uint          `json:"merchant_id,omitempty"`
+	SessionToken    string        `json:"session_token,omitempty"`
+	Amount          Money         `json:"amount"`
+	Purpose         string        `json:"purpose"`
+	Status          SessionStatus `json:"status"`
+	CaptureMode     CaptureMode   `json:"capture_mode"`
+	Captured        Money         `json:"-"`
+	AuthorizationID string        `json:"-"`
+	DeclineReason   string        `json:"decline_reason,omitempty"`
+	CallbackURL     string        `json:"callback_url,omitempty"`
+	CreatedAt       time.Time     `json:"created_at"`
+	ExpiresAt       time.Time     `json:"expires_at"`
+	ProcessingAt    *time.Time    `json:"-"`
+	AuthorizedAt    *time.Time    `json:"-"`
+	ClosedAt        *time.Time    `json:"closed_at,omitempty"`
+}
+
+// Время жизни сессии, если при создании не задано другое
+const DefaultSessionTTL = 15 * time.Minute
+
+type sessionJSON Session
+
+func (s Session) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		sessionJSON
+		Currency string `json:"currency"`
+	}{
+		sessionJSON: sessionJSON(s),
+		Currency:    s.Amount.Currency,
+	})
+}
+
+func (s *Session) UnmarshalJSON(data []byte) error {
+	aux := struct {
+		*sessionJSON
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}{
+		sessionJSON: (*sessionJSON)(s),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.Currency == "" {
+		aux.Currency = DefaultCurrency
+	}
+
+	if aux.Amount != "" {
+		amount, err := ParseMoney(aux.Amount.String(), aux.Currency)
+		if err != nil {
+			return err
+		}
+		s.Amount = amount
+	}
+
+	return nil
 }
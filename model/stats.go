@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+type CurrencyTotal struct {
+	Currency   string `json:"currency"`
+	Count      int    `json:"count"`
+	Amount     Money  `json:"amount"`
+	PaidCount  int    `json:"paid_count"`
+	PaidAmount Money  `json:"paid_amount"`
+
+	AuthorizedCount  int   `json:"authorized_count"`
+	AuthorizedAmount Money `json:"authorized_amount"`
+
+	RefundedAmount Money `json:"refunded_amount"`
+	NetAmount      Money `json:"net_amount"`
+
+	CancelledCount int `json:"cancelled_count"`
+}
+
+// Количество и сумма сессий с одним статусом в одной валюте
+type StatusTotal struct {
+	Status   SessionStatus `json:"status"`
+	Currency string        `json:"currency"`
+	Count    int           `json:"count"`
+	Amount   Money         `json:"amount"`
+}
+
+// Итоги по сессиям, созданным в одном интервале временного ряда
+type StatsBucket struct {
+	Start      time.Time `json:"start"`
+	Currency   string    `json:"currency"`
+	Count      int       `json:"count"`
+	Amount     Money     `json:"amount"`
+	PaidCount  int       `json:"paid_count"`
+	PaidAmount Money     `json:"paid_amount"`
+}
+
+// Сводная статистика за период. Конверсия - доля оплаченных сессий
+// среди созданных, время до оплаты считается от создания сессии
+// до списания денег в секундах
+type StatsSummary struct {
+	Count          int           `json:"count"`
+	PaidCount      int           `json:"paid_count"`
+	ConversionRate float64       `json:"conversion_rate"`
+	AvgTimeToPay   float64       `json:"avg_time_to_pay"`
+	Statuses       []StatusTotal `json:"statuses"`
+	Buckets        []StatsBucket `json:"buckets"`
+}
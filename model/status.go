@@ -0,0 +1,61 @@
+package model
+
+type SessionStatus string
+
+const (
+	StatusCreated    SessionStatus = "created"
+	StatusProcessing SessionStatus = "processing"
+	StatusAuthorized SessionStatus = "authorized"
+	StatusCapturing  SessionStatus = "capturing"
+	StatusPaid       SessionStatus = "paid"
+	StatusDeclined   SessionStatus = "declined"
+	StatusExpired    SessionStatus = "expired"
+	StatusCancelled  SessionStatus = "cancelled"
+	StatusRefunded   SessionStatus = "refunded"
+	StatusVoided     SessionStatus = "voided"
+)
+
+// Таблица допустимых переходов между статусами платежной сессии.
+// Все изменения статуса в хранилищах проверяются по ней
+var transitions = map[SessionStatus][]SessionStatus{
+	StatusCreated:    {StatusProcessing, StatusPaid, StatusDeclined, StatusExpired, StatusCancelled},
+	StatusProcessing: {StatusAuthorized, StatusPaid, StatusDeclined},
+	StatusAuthorized: {StatusCapturing, StatusPaid, StatusVoided, StatusExpired},
+	StatusCapturing:  {StatusPaid, StatusAuthorized},
+	StatusPaid:       {StatusRefunded},
+	StatusDeclined:   {},
+	StatusExpired:    {},
+	StatusCancelled:  {},
+	StatusRefunded:   {},
+	StatusVoided:     {},
+}
+
+func (s SessionStatus) IsValid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// Открытая сессия еще может быть оплачена
+func (s SessionStatus) IsOpen() bool {
+	return s == StatusCreated || s == StatusProcessing
+}
+
+func (s SessionStatus) CanTransitionTo(next SessionStatus) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+type CaptureMode string
+
+const (
+	CaptureAuto   CaptureMode = "auto"
+	CaptureManual CaptureMode = "manual"
+)
+
+func (m CaptureMode) IsValid() bool {
+	return m == CaptureAuto || m == CaptureManual
+}
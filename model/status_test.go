@@ -0,0 +1,38 @@
+package model_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Тестирование таблицы переходов между статусами платежной сессии
+func TestSessionStatus_CanTransitionTo(t *testing.T) {
+	testCases := []struct {
+		from    model.SessionStatus
+		to      model.SessionStatus
+		allowed bool
+	}{
+		{from: model.StatusCreated, to: model.StatusPaid, allowed: true},
+		{from: model.StatusCreated, to: model.StatusExpired, allowed: true},
+		{from: model.StatusCreated, to: model.StatusCancelled, allowed: true},
+		{from: model.StatusProcessing, to: model.StatusDeclined, allowed: true},
+		{from: model.StatusPaid, to: model.StatusRefunded, allowed: true},
+		{from: model.StatusAuthorized, to: model.StatusCapturing, allowed: true},
+		{from: model.StatusCapturing, to: model.StatusAuthorized, allowed: true},
+		{from: model.StatusCapturing, to: model.StatusPaid, allowed: true},
+		{from: model.StatusCapturing, to: model.StatusVoided, allowed: false},
+		{from: model.StatusCreated, to: model.StatusRefunded, allowed: false},
+		{from: model.StatusPaid, to: model.StatusPaid, allowed: false},
+		{from: model.StatusExpired, to: model.StatusPaid, allowed: false},
+		{from: model.StatusCancelled, to: model.StatusPaid, allowed: false},
+		{from: model.StatusRefunded, to: model.StatusPaid, allowed: false},
+		{from: "", to: model.StatusPaid, allowed: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.from)+"->"+string(tc.to), func(t *testing.T) {
+			assert.Equal(t, tc.allowed, tc.from.CanTransitionTo(tc.to))
+		})
+	}
+}
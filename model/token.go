@@ -0,0 +1,48 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+const refreshTokenLength = 32
+
+// Токен обновления пары токенов. Сам токен выдается клиенту один раз,
+// в хранилище попадает только его SHA-256 хеш
+type RefreshToken struct {
+	TokenID   uint
+	UserID    uint
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// Создает токен обновления пользователя и возвращает его вместе
+// со значением, которое передается клиенту
+func NewRefreshToken(userID uint, at time.Time, ttl time.Duration) (*RefreshToken, string, error) {
+	b := make([]byte, refreshTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(b)
+	return &RefreshToken{
+		UserID:    userID,
+		TokenHash: HashToken(value),
+		CreatedAt: at,
+		ExpiresAt: at.Add(ttl),
+	}, value, nil
+}
+
+func HashToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func (t *RefreshToken) IsActive(at time.Time) bool {
+	return t.RevokedAt == nil && t.ExpiresAt.After(at)
+}
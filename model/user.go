@@ -0,0 +1,73 @@
+package model
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"time"
+)
+
+const minPasswordLength = 8
+
+var (
+	ErrInvalidEmail    = errors.New("email is invalid")
+	ErrPasswordTooWeak = errors.New("password must be at least 8 symbols")
+	ErrInvalidRole     = errors.New("role must be admin, merchant, analyst or support")
+	ErrNoUserMerchant  = errors.New("user with merchant role must be linked to a merchant")
+)
+
+// Пользователь API статистики. Password заполняется только при создании
+// и заменяется хешем в BeforeCreate, в хранилище попадает только хеш.
+// Пользователь с ролью merchant обязан быть привязан к мерчанту, остальные
+// роли кроме admin без привязки не видят ни одной сессии
+type User struct {
+	UserID            uint      `json:"user_id"`
+	Email             string    `json:"email"`
+	Role              Role      `json:"role"`
+	MerchantID        uint      `json:"merchant_id,omitempty"`
+	Password          string    `json:"-"`
+	EncryptedPassword string    `json:"-"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+func (u *User) Validate() error {
+	at := strings.Index(u.Email, "@")
+	if at < 1 || at == len(u.Email)-1 || len(u.Email) > 255 {
+		return ErrInvalidEmail
+	}
+
+	if !u.Role.IsValid() {
+		return ErrInvalidRole
+	}
+
+	if u.Role == RoleMerchant && u.MerchantID == 0 {
+		return ErrNoUserMerchant
+	}
+
+	if u.EncryptedPassword == "" && len(u.Password) < minPasswordLength {
+		return ErrPasswordTooWeak
+	}
+
+	return nil
+}
+
+func (u *User) BeforeCreate() error {
+	u.Email = strings.ToLower(strings.TrimSpace(u.Email))
+
+	if u.Password == "" {
+		return nil
+	}
+
+	enc, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	u.EncryptedPassword = string(enc)
+	u.Password = ""
+	return nil
+}
+
+func (u *User) ComparePassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.EncryptedPassword), []byte(password)) == nil
+}
@@ -0,0 +1,72 @@
+package model_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Тестирование проверки параметров пользователя
+func TestUser_Validate(t *testing.T) {
+	testCases := []struct {
+		name string
+		user *model.User
+		err  error
+	}{
+		{
+			name: "valid",
+			user: &model.User{Email: "user@example.org", Role: model.RoleAdmin, Password: "password"},
+		},
+		{
+			name: "empty email",
+			user: &model.User{Role: model.RoleAdmin, Password: "password"},
+			err:  model.ErrInvalidEmail,
+		},
+		{
+			name: "email without domain",
+			user: &model.User{Email: "user@", Role: model.RoleAdmin, Password: "password"},
+			err:  model.ErrInvalidEmail,
+		},
+		{
+			name: "short password",
+			user: &model.User{Email: "user@example.org", Role: model.RoleAdmin, Password: "pass"},
+			err:  model.ErrPasswordTooWeak,
+		},
+		{
+			name: "unknown role",
+			user: &model.User{Email: "user@example.org", Role: "owner", Password: "password"},
+			err:  model.ErrInvalidRole,
+		},
+		{
+			name: "merchant without merchant id",
+			user: &model.User{Email: "user@example.org", Role: model.RoleMerchant, Password: "password"},
+			err:  model.ErrNoUserMerchant,
+		},
+		{
+			name: "merchant",
+			user: &model.User{Email: "user@example.org", Role: model.RoleMerchant, MerchantID: 1, Password: "password"},
+		},
+		{
+			name: "encrypted password",
+			user: &model.User{Email: "user@example.org", Role: model.RoleAdmin, EncryptedPassword: "hash"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.err, tc.user.Validate())
+		})
+	}
+}
+
+// Тестирование хеширования пароля перед сохранением пользователя
+func TestUser_BeforeCreate(t *testing.T) {
+	u := &model.User{Email: " User@Example.org ", Password: "password"}
+
+	assert.NoError(t, u.BeforeCreate())
+	assert.Equal(t, "user@example.org", u.Email)
+	assert.Empty(t, u.Password)
+	assert.NotEmpty(t, u.EncryptedPassword)
+	assert.True(t, u.ComparePassword("password"))
+	assert.False(t, u.ComparePassword("wrong password"))
+}
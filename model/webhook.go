@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+type WebhookEvent string
+
+const (
+	EventSessionPaid     WebhookEvent = "session.paid"
+	EventSessionDeclined WebhookEvent = "session.declined"
+	EventSessionExpired  WebhookEvent = "session.expired"
+	EventSessionRefunded WebhookEvent = "session.refunded"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// Запись журнала доставки уведомления мерчанту. Payload хранится в том виде,
+// в котором отправляется, чтобы подпись повторных попыток совпадала с первой
+type WebhookDelivery struct {
+	DeliveryID    uint           `json:"delivery_id"`
+	SessionID     uint           `json:"-"`
+	MerchantID    uint           `json:"-"`
+	Event         WebhookEvent   `json:"event"`
+	URL           string         `json:"url"`
+	Payload       []byte         `json:"-"`
+	Status        DeliveryStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	ResponseCode  int            `json:"response_code,omitempty"`
+	LastError     string         `json:"last_error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at,omitempty"`
+}
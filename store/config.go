@@ -1,11 +1,18 @@
 package store
 
+import "fmt"
+
 type Config struct {
-	DBName   string `toml:"dbname"`
-	User     string `toml:"user"`
-	Password string `toml:"password"`
+	DBName      string `toml:"dbname"`
+	User        string `toml:"user"`
+	Password    string `toml:"password"`
+	AutoMigrate bool   `toml:"auto_migrate"`
 }
 
 func NewConfig() *Config {
 	return &Config{}
 }
+
+func (c *Config) ConnectionString() string {
+	return fmt.Sprintf("%s:%s@/%s?parseTime=true", c.User, c.Password, c.DBName)
+}
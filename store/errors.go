@@ -0,0 +1,27 @@
+package store
+
+import "errors"
+
+var (
+	ErrNoSession = errors.New("there is no session with given token")
+	ErrNoStats   = errors.New("there is no created sessions with given period")
+
+	ErrInvalidCursor  = errors.New("stats cursor is malformed or does not match the sort order")
+	ErrTooManyBuckets = errors.New("too many stats buckets, choose a longer interval or a shorter period")
+
+	ErrSessionConflict   = errors.New("session status was changed by another request")
+	ErrInvalidTransition = errors.New("session status transition is not allowed")
+
+	ErrSessionNotPaid      = errors.New("session is not paid")
+	ErrRefundExceedsAmount = errors.New("refund exceeds the paid amount")
+	ErrRefundNotPending    = errors.New("refund is already completed or failed")
+
+	ErrNoUser     = errors.New("there is no user with given credentials")
+	ErrUserExists = errors.New("user with given email already exists")
+
+	ErrNoMerchant = errors.New("there is no merchant with given id")
+	ErrNoAPIKey   = errors.New("there is no api key with given prefix")
+
+	ErrNoRefreshToken      = errors.New("there is no refresh token with given value")
+	ErrRefreshTokenRevoked = errors.New("refresh token is already revoked")
+)
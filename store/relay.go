@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRelayInterval  = time.Second
+	defaultRelayBatchSize = 100
+)
+
+type EventPublisher interface {
+	Publish(e *model.OutboxEvent) error
+}
+
+// Переносит события из outbox к издателю. Событие помечается опубликованным
+// только после успешной публикации, поэтому при падении процесса между этими
+// шагами оно будет опубликовано повторно: доставка как минимум один раз
+type Relay struct {
+	Interval  time.Duration
+	BatchSize int
+	OnError   func(err error)
+
+	outbox    OutboxRepository
+	publisher EventPublisher
+}
+
+func NewRelay(outbox OutboxRepository, publisher EventPublisher) *Relay {
+	return &Relay{
+		Interval:  defaultRelayInterval,
+		BatchSize: defaultRelayBatchSize,
+		OnError:   func(err error) {},
+		outbox:    outbox,
+		publisher: publisher,
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.Drain(); err != nil {
+			r.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Публикует неопубликованные события по порядку и возвращает их количество.
+// На первой ошибке останавливается, чтобы не нарушать порядок событий
+func (r *Relay) Drain() (int, error) {
+	published := 0
+	for {
+		events, err := r.outbox.FindUnpublished(r.BatchSize)
+		if err != nil {
+			return published, err
+		}
+
+		for i := range events {
+			if err := r.publisher.Publish(&events[i]); err != nil {
+				return published, err
+			}
+			if err := r.outbox.MarkPublished(&events[i], time.Now().UTC()); err != nil {
+				return published, err
+			}
+			published++
+		}
+
+		if len(events) < r.BatchSize {
+			return published, nil
+		}
+	}
+}
+
+// Издатель внутри процесса: передает событие всем подписчикам по очереди.
+// Ошибка подписчика возвращается реле, и событие будет опубликовано повторно
+type InProcessPublisher struct {
+	mu       sync.RWMutex
+	handlers []func(e *model.OutboxEvent) error
+}
+
+func NewInProcessPublisher() *InProcessPublisher {
+	return &InProcessPublisher{}
+}
+
+func (p *InProcessPublisher) Subscribe(handler func(e *model.OutboxEvent) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.handlers = append(p.handlers, handler)
+}
+
+func (p *InProcessPublisher) Publish(e *model.OutboxEvent) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, handler := range p.handlers {
+		if err := handler(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package store_test
+
+import (
+	"errors"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Вспомогательная функция для создания оплаченной сессии в хранилище
+func paidSession(t *testing.T, st store.Store) *model.Session {
+	t.Helper()
+
+	s := &model.Session{
+		SessionToken: "2a6a8f4e-6b2a-4a52-9a41-3f1d1e0c0c11",
+		Amount:       model.Money{Minor: 10000, Currency: "RUB"},
+		Purpose:      "test",
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := st.Session().Create(s); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Captured = s.Amount
+	if err := st.Session().CommitSession(s, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Тестирование публикации событий из outbox по порядку
+func TestRelay_Drain(t *testing.T) {
+	st := teststore.New()
+	s := paidSession(t, st)
+
+	publisher := store.NewInProcessPublisher()
+	var events []model.OutboxEvent
+	publisher.Subscribe(func(e *model.OutboxEvent) error {
+		events = append(events, *e)
+		return nil
+	})
+
+	relay := store.NewRelay(st.Outbox(), publisher)
+	relay.BatchSize = 1
+
+	n, err := relay.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, model.OutboxSessionCreated, events[0].EventType)
+	assert.Equal(t, model.StatusCreated, events[0].Status)
+	assert.Equal(t, model.OutboxSessionStatusChanged, events[1].EventType)
+	assert.Equal(t, model.StatusPaid, events[1].Status)
+	assert.Equal(t, s.SessionToken, events[1].SessionToken)
+
+	rf := &model.Refund{
+		Amount:    model.Money{Minor: 10000, Currency: "RUB"},
+		CreatedAt: time.Now().UTC(),
+	}
+	assert.NoError(t, st.Refund().Create(s, rf))
+	assert.NoError(t, st.Refund().Complete(s, rf))
+
+	n, err = relay.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, model.OutboxSessionStatusChanged, events[2].EventType)
+	assert.Equal(t, model.OutboxRefundCreated, events[3].EventType)
+	assert.Equal(t, model.StatusRefunded, events[3].Status)
+
+	n, err = relay.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+// Тестирование повторной публикации событий после ошибки подписчика
+func TestRelay_AtLeastOnce(t *testing.T) {
+	st := teststore.New()
+	paidSession(t, st)
+
+	publisher := store.NewInProcessPublisher()
+	received := map[uint]int{}
+	fail := true
+	publisher.Subscribe(func(e *model.OutboxEvent) error {
+		received[e.EventID]++
+		return nil
+	})
+	publisher.Subscribe(func(e *model.OutboxEvent) error {
+		if fail && e.EventType == model.OutboxSessionStatusChanged {
+			return errors.New("subscriber is unavailable")
+		}
+		return nil
+	})
+
+	relay := store.NewRelay(st.Outbox(), publisher)
+
+	n, err := relay.Drain()
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+
+	fail = false
+	n, err = relay.Drain()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, 1, received[1])
+	assert.Equal(t, 2, received[2])
+
+	events, err := st.Outbox().FindUnpublished(10)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
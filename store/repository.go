@@ -0,0 +1,77 @@
+package store
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"time"
+)
+
+type SessionRepository interface {
+	Create(s *model.Session) error
+	FindByToken(scope Scope, token string) (*model.Session, error)
+	CommitSession(s *model.Session, closedAt time.Time) error
+	UpdateStatus(s *model.Session, status model.SessionStatus, at time.Time) error
+	ExpireSessions(at time.Time, limit int) ([]model.Session, error)
+	FindExpiredAuthorizations(authorizedBefore time.Time, limit int) ([]model.Session, error)
+	FindStaleProcessing(startedBefore time.Time, limit int) ([]model.Session, error)
+	GetStats(scope Scope, q *StatsQuery) ([]model.Session, *StatsCursor, error)
+	StreamStats(scope Scope, q *StatsQuery, fn func(s *model.Session) error) error
+	GetTotals(scope Scope, f *StatsFilter) ([]model.CurrencyTotal, error)
+	GetSummary(scope Scope, f *StatsFilter, bounds []time.Time) (*model.StatsSummary, error)
+}
+
+// Create резервирует возврат в статусе pending, Complete подтверждает его
+// после одобрения эквайером, а Fail освобождает зарезервированную сумму
+type RefundRepository interface {
+	Create(s *model.Session, r *model.Refund) error
+	Complete(s *model.Session, r *model.Refund) error
+	Fail(r *model.Refund) error
+	FindBySession(s *model.Session) ([]model.Refund, error)
+}
+
+type IdempotencyRepository interface {
+	Reserve(k *model.IdempotencyKey) (*model.IdempotencyKey, error)
+	Complete(k *model.IdempotencyKey) error
+	Delete(k *model.IdempotencyKey) error
+	DeleteExpired(at time.Time) (int, error)
+}
+
+type WebhookRepository interface {
+	Create(d *model.WebhookDelivery) error
+	FindDue(now time.Time, limit int) ([]*model.WebhookDelivery, error)
+	Update(d *model.WebhookDelivery) error
+	FindBySession(s *model.Session) ([]model.WebhookDelivery, error)
+}
+
+type OutboxRepository interface {
+	FindUnpublished(limit int) ([]model.OutboxEvent, error)
+	MarkPublished(e *model.OutboxEvent, at time.Time) error
+	DeletePublished(before time.Time, limit int) (int, error)
+}
+
+type MerchantRepository interface {
+	Create(m *model.Merchant) error
+	Find(id uint) (*model.Merchant, error)
+	UpdateWebhookSecret(m *model.Merchant) error
+	CreateKey(k *model.APIKey) error
+	FindKey(prefix string) (*model.APIKey, error)
+	FindKeys(m *model.Merchant) ([]model.APIKey, error)
+	RevokeKey(k *model.APIKey, at time.Time) error
+}
+
+type UserRepository interface {
+	Create(u *model.User) error
+	Find(id uint) (*model.User, error)
+	FindByEmail(email string) (*model.User, error)
+}
+
+// Токены обновления и отозванные токены доступа, которые хранятся
+// до истечения срока их действия
+type TokenRepository interface {
+	CreateRefresh(t *model.RefreshToken) error
+	FindRefresh(hash string) (*model.RefreshToken, error)
+	RevokeRefresh(t *model.RefreshToken, at time.Time) error
+	RevokeUserRefresh(userID uint, at time.Time) error
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+	DeleteExpired(at time.Time) (int, error)
+}
@@ -0,0 +1,29 @@
+package store
+
+// Ограничение запросов к сессиям одним мерчантом. Нулевое значение
+// не дает доступа ни к одной сессии, поэтому забытая область видимости
+// не открывает чужие данные
+type Scope struct {
+	merchantID uint
+	all        bool
+}
+
+// Сессии одного мерчанта
+func MerchantScope(merchantID uint) Scope {
+	return Scope{merchantID: merchantID}
+}
+
+// Сессии всех мерчантов: для администраторов и запросов по токену сессии,
+// который сам по себе дает доступ к ней плательщику
+func AllMerchants() Scope {
+	return Scope{all: true}
+}
+
+// Возвращает идентификатор мерчанта и false, если область не ограничена
+func (s Scope) Merchant() (uint, bool) {
+	return s.merchantID, !s.all
+}
+
+func (s Scope) Includes(merchantID uint) bool {
+	return s.all || (s.merchantID != 0 && s.merchantID == merchantID)
+}
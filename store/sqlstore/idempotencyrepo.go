@@ -0,0 +1,108 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"time"
+)
+
+type IdempotencyRepo struct {
+	store *Store
+}
+
+// Резервирует ключ за текущим запросом. Если ключ уже занят действующей
+// записью, возвращает ее; просроченную запись текущий запрос перезаписывает
+func (r *IdempotencyRepo) Reserve(k *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	res, err := r.store.db.Exec(
+		`INSERT IGNORE INTO idempotency_keys (Scope, IdemKey, RequestHash, CreatedAt, ExpiresAt)
+		VALUES (?, ?, ?, ?, ?)`,
+		k.Scope,
+		k.Key,
+		k.RequestHash,
+		k.CreatedAt,
+		k.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	res, err = r.store.db.Exec(
+		`UPDATE idempotency_keys
+		SET RequestHash = ?, StatusCode = 0, ResponseBody = NULL, CreatedAt = ?, ExpiresAt = ?
+		WHERE Scope = ? AND IdemKey = ? AND ExpiresAt <= ?`,
+		k.RequestHash,
+		k.CreatedAt,
+		k.ExpiresAt,
+		k.Scope,
+		k.Key,
+		k.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	existing := &model.IdempotencyKey{}
+	if err := r.store.db.QueryRow(
+		`SELECT Scope, IdemKey, RequestHash, StatusCode, ResponseBody, CreatedAt, ExpiresAt
+		FROM idempotency_keys WHERE Scope = ? AND IdemKey = ?`,
+		k.Scope,
+		k.Key,
+	).Scan(
+		&existing.Scope,
+		&existing.Key,
+		&existing.RequestHash,
+		&existing.StatusCode,
+		&existing.Response,
+		&existing.CreatedAt,
+		&existing.ExpiresAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return r.Reserve(k)
+		}
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+func (r *IdempotencyRepo) Complete(k *model.IdempotencyKey) error {
+	_, err := r.store.db.Exec(
+		"UPDATE idempotency_keys SET StatusCode = ?, ResponseBody = ? WHERE Scope = ? AND IdemKey = ?",
+		k.StatusCode,
+		k.Response,
+		k.Scope,
+		k.Key,
+	)
+	return err
+}
+
+func (r *IdempotencyRepo) Delete(k *model.IdempotencyKey) error {
+	_, err := r.store.db.Exec(
+		"DELETE FROM idempotency_keys WHERE Scope = ? AND IdemKey = ?",
+		k.Scope,
+		k.Key,
+	)
+	return err
+}
+
+// Удаляет записи, срок действия которых истек: Reserve их уже не возвращает
+func (r *IdempotencyRepo) DeleteExpired(at time.Time) (int, error) {
+	res, err := r.store.db.Exec("DELETE FROM idempotency_keys WHERE ExpiresAt <= ?", at)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
@@ -0,0 +1,82 @@
+package sqlstore_test
+
+import (
+	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования резервирования и сохранения ответа по ключу идемпотентности
+func TestIdempotencyRepo_Reserve(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("idempotency_keys")
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	k := &model.IdempotencyKey{
+		Scope:       "session",
+		Key:         "key",
+		RequestHash: "hash",
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+
+	existing, err := st.Idempotency().Reserve(k)
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+
+	k.StatusCode = 201
+	k.Response = []byte(`{}`)
+	assert.NoError(t, st.Idempotency().Complete(k))
+
+	existing, err = st.Idempotency().Reserve(k)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, existing.StatusCode)
+	assert.Equal(t, []byte(`{}`), existing.Response)
+
+	later := *k
+	later.CreatedAt = now.Add(2 * time.Hour)
+	later.ExpiresAt = now.Add(3 * time.Hour)
+	existing, err = st.Idempotency().Reserve(&later)
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+}
+
+// Функция для тестирования удаления просроченных ключей идемпотентности
+func TestIdempotencyRepo_DeleteExpired(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("idempotency_keys")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, ttl := range []time.Duration{-time.Hour, 0, time.Hour} {
+		_, err := st.Idempotency().Reserve(&model.IdempotencyKey{
+			Scope:       "session",
+			Key:         fmt.Sprintf("key-%v", i),
+			RequestHash: "hash",
+			CreatedAt:   now.Add(-2 * time.Hour),
+			ExpiresAt:   now.Add(ttl),
+		})
+		assert.NoError(t, err)
+	}
+
+	deleted, err := st.Idempotency().DeleteExpired(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	deleted, err = st.Idempotency().DeleteExpired(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	existing, err := st.Idempotency().Reserve(&model.IdempotencyKey{
+		Scope:       "session",
+		Key:         "key-2",
+		RequestHash: "hash",
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, existing)
+}
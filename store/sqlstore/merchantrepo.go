@@ -0,0 +1,185 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"time"
+)
+
+type MerchantRepo struct {
+	store *Store
+}
+
+func (r *MerchantRepo) Create(m *model.Merchant) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	if err := m.BeforeCreate(); err != nil {
+		return err
+	}
+
+	res, err := r.store.db.Exec(
+		"INSERT INTO merchants (Name, CallbackURL, SessionTTL, WebhookSecret, CreatedAt) VALUES (?, ?, ?, ?, ?)",
+		m.Name,
+		m.CallbackURL,
+		int64(m.SessionTTL/time.Second),
+		m.WebhookSecret,
+		m.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	m.MerchantID = uint(id)
+
+	return nil
+}
+
+func (r *MerchantRepo) Find(id uint) (*model.Merchant, error) {
+	m := &model.Merchant{}
+
+	var ttl int64
+	if err := r.store.db.QueryRow(
+		"SELECT MerchantID, Name, CallbackURL, SessionTTL, WebhookSecret, CreatedAt FROM merchants WHERE MerchantID = ?",
+		id,
+	).Scan(
+		&m.MerchantID,
+		&m.Name,
+		&m.CallbackURL,
+		&ttl,
+		&m.WebhookSecret,
+		&m.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrNoMerchant
+		}
+		return nil, err
+	}
+
+	m.SessionTTL = time.Duration(ttl) * time.Second
+	return m, nil
+}
+
+func (r *MerchantRepo) UpdateWebhookSecret(m *model.Merchant) error {
+	res, err := r.store.db.Exec(
+		"UPDATE merchants SET WebhookSecret = ? WHERE MerchantID = ?",
+		m.WebhookSecret,
+		m.MerchantID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Строка с тем же секретом не считается измененной, поэтому
+	// отсутствие мерчанта проверяется отдельно
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if _, err := r.Find(m.MerchantID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *MerchantRepo) CreateKey(k *model.APIKey) error {
+	if _, err := r.Find(k.MerchantID); err != nil {
+		return err
+	}
+
+	res, err := r.store.db.Exec(
+		"INSERT INTO api_keys (MerchantID, Prefix, KeyHash, CreatedAt) VALUES (?, ?, ?, ?)",
+		k.MerchantID,
+		k.Prefix,
+		k.KeyHash,
+		k.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	k.KeyID = uint(id)
+
+	return nil
+}
+
+func (r *MerchantRepo) FindKey(prefix string) (*model.APIKey, error) {
+	k := &model.APIKey{}
+	if err := r.store.db.QueryRow(
+		"SELECT KeyID, MerchantID, Prefix, KeyHash, CreatedAt, RevokedAt FROM api_keys WHERE Prefix = ?",
+		prefix,
+	).Scan(
+		&k.KeyID,
+		&k.MerchantID,
+		&k.Prefix,
+		&k.KeyHash,
+		&k.CreatedAt,
+		&k.RevokedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrNoAPIKey
+		}
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (r *MerchantRepo) FindKeys(m *model.Merchant) ([]model.APIKey, error) {
+	rows, err := r.store.db.Query(
+		"SELECT KeyID, MerchantID, Prefix, KeyHash, CreatedAt, RevokedAt FROM api_keys WHERE MerchantID = ? ORDER BY KeyID",
+		m.MerchantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []model.APIKey
+	for rows.Next() {
+		var k model.APIKey
+		if err := rows.Scan(
+			&k.KeyID,
+			&k.MerchantID,
+			&k.Prefix,
+			&k.KeyHash,
+			&k.CreatedAt,
+			&k.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// Повторный отзыв не меняет время первого
+func (r *MerchantRepo) RevokeKey(k *model.APIKey, at time.Time) error {
+	if _, err := r.store.db.Exec(
+		"UPDATE api_keys SET RevokedAt = COALESCE(RevokedAt, ?) WHERE Prefix = ?",
+		at,
+		k.Prefix,
+	); err != nil {
+		return err
+	}
+
+	revoked, err := r.FindKey(k.Prefix)
+	if err != nil {
+		return err
+	}
+
+	k.RevokedAt = revoked.RevokedAt
+	return nil
+}
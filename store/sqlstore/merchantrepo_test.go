@@ -0,0 +1,101 @@
+package sqlstore_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования создания и поиска мерчанта
+func TestMerchantRepo_Create(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("merchants")
+
+	_, err := st.Merchant().Find(1)
+	assert.Equal(t, store.ErrNoMerchant, err)
+
+	m := &model.Merchant{
+		Name:        "shop",
+		CallbackURL: "https://shop.example.org/hook",
+		SessionTTL:  time.Hour,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+	}
+	assert.NoError(t, st.Merchant().Create(m))
+	assert.NotZero(t, m.MerchantID)
+
+	found, err := st.Merchant().Find(m.MerchantID)
+	assert.NoError(t, err)
+	assert.Equal(t, m.Name, found.Name)
+	assert.Equal(t, m.CallbackURL, found.CallbackURL)
+	assert.Equal(t, time.Hour, found.SessionTTL)
+	assert.NotEmpty(t, found.WebhookSecret)
+	assert.Equal(t, m.WebhookSecret, found.WebhookSecret)
+
+	assert.Equal(t, model.ErrInvalidMerchantName, st.Merchant().Create(&model.Merchant{}))
+}
+
+// Функция для тестирования ротации секрета подписи уведомлений мерчанта
+func TestMerchantRepo_UpdateWebhookSecret(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("merchants")
+
+	m := &model.Merchant{Name: "shop", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	assert.NoError(t, st.Merchant().Create(m))
+
+	secret, err := model.NewWebhookSecret()
+	assert.NoError(t, err)
+	m.WebhookSecret = secret
+	assert.NoError(t, st.Merchant().UpdateWebhookSecret(m))
+	assert.NoError(t, st.Merchant().UpdateWebhookSecret(m))
+
+	found, err := st.Merchant().Find(m.MerchantID)
+	assert.NoError(t, err)
+	assert.Equal(t, secret, found.WebhookSecret)
+
+	assert.Equal(t, store.ErrNoMerchant, st.Merchant().UpdateWebhookSecret(&model.Merchant{MerchantID: m.MerchantID + 1, WebhookSecret: secret}))
+}
+
+// Функция для тестирования выпуска, поиска и отзыва API-ключей мерчанта
+func TestMerchantRepo_Keys(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("merchants", "api_keys")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	m := &model.Merchant{Name: "shop", CreatedAt: now}
+	assert.NoError(t, st.Merchant().Create(m))
+
+	orphan, _, err := model.NewAPIKey(m.MerchantID+1, now)
+	assert.NoError(t, err)
+	assert.Equal(t, store.ErrNoMerchant, st.Merchant().CreateKey(orphan))
+
+	key, value, err := model.NewAPIKey(m.MerchantID, now)
+	assert.NoError(t, err)
+	assert.NoError(t, st.Merchant().CreateKey(key))
+
+	rotated, _, err := model.NewAPIKey(m.MerchantID, now)
+	assert.NoError(t, err)
+	assert.NoError(t, st.Merchant().CreateKey(rotated))
+
+	prefix, _ := model.APIKeyPrefix(value)
+	found, err := st.Merchant().FindKey(prefix)
+	assert.NoError(t, err)
+	assert.True(t, found.IsActive())
+	assert.True(t, found.Matches(value))
+
+	_, err = st.Merchant().FindKey("sk_ffffffffffff")
+	assert.Equal(t, store.ErrNoAPIKey, err)
+
+	assert.NoError(t, st.Merchant().RevokeKey(found, now))
+	assert.False(t, found.IsActive())
+
+	keys, err := st.Merchant().FindKeys(m)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, key.Prefix, keys[0].Prefix)
+		assert.False(t, keys[0].IsActive())
+		assert.True(t, keys[1].IsActive())
+	}
+}
@@ -0,0 +1,134 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	errUnknownSchemaVersion = errors.New("database schema version is newer than the binary supports")
+)
+
+type migration struct {
+	version int
+	name    string
+	up      []string
+	down    []string
+}
+
+func (s *Store) SchemaVersion() (int, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(Version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return sortedMigrations()[len(migrations)-1].version
+}
+
+func (s *Store) MigrateUp() error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > LatestSchemaVersion() {
+		return errUnknownSchemaVersion
+	}
+
+	for _, m := range sortedMigrations() {
+		if m.version <= current {
+			continue
+		}
+		if err := s.apply(m, m.up, func(tx *sql.Tx) error {
+			_, err := tx.Exec("INSERT INTO schema_migrations (Version, Name, AppliedAt) VALUES (?, ?, UTC_TIMESTAMP())", m.version, m.name)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) MigrateDown(steps int) error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > LatestSchemaVersion() {
+		return errUnknownSchemaVersion
+	}
+
+	ms := sortedMigrations()
+	for i := len(ms) - 1; i >= 0 && steps > 0; i-- {
+		m := ms[i]
+		if m.version > current {
+			continue
+		}
+		if err := s.apply(m, m.down, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE Version = ?", m.version)
+			return err
+		}); err != nil {
+			return err
+		}
+		steps--
+	}
+
+	return nil
+}
+
+func (s *Store) ensureMigrationsTable() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		Version INT NOT NULL,
+		Name VARCHAR(255) NOT NULL,
+		AppliedAt DATETIME NOT NULL,
+		PRIMARY KEY (Version)
+	)`)
+	return err
+}
+
+// В MySQL DDL-запросы неявно фиксируют транзакцию, поэтому транзакция
+// гарантирует атомарность только DML-части миграции и записи о версии
+func (s *Store) apply(m migration, statements []string, record func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d_%s: %v", m.version, m.name, err)
+		}
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s: %v", m.version, m.name, err)
+	}
+
+	return tx.Commit()
+}
+
+func sortedMigrations() []migration {
+	ms := make([]migration, len(migrations))
+	copy(ms, migrations)
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].version < ms[j].version
+	})
+	return ms
+}
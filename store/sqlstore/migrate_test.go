@@ -0,0 +1,27 @@
+package sqlstore_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Функция для тестирования отката и повторного применения миграций
+func TestStore_Migrate(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown()
+
+	version, err := st.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, sqlstore.LatestSchemaVersion(), version)
+
+	assert.NoError(t, st.MigrateDown(version))
+	version, err = st.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	assert.NoError(t, st.MigrateUp())
+	version, err = st.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, sqlstore.LatestSchemaVersion(), version)
+}
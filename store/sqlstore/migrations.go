@@ -0,0 +1,331 @@
+package sqlstore
+
+// Миграции схемы БД. Номера версий идут строго по возрастанию,
+// уже выпущенные миграции не редактируются — изменения схемы
+// оформляются новой миграцией в конце списка
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create_sessions",
+		up: []string{
+			`CREATE TABLE IF NOT EXISTS sessions (
+				SessionID INT NOT NULL AUTO_INCREMENT,
+				SessionToken VARCHAR(36) NOT NULL,
+				Amount FLOAT NOT NULL,
+				Purpose NVARCHAR(4000) NULL,
+				CreatedAt DATETIME NOT NULL,
+				ClosedAt DATETIME NOT NULL DEFAULT '1000-01-01 00:00:00',
+				PRIMARY KEY (SessionID),
+				UNIQUE KEY UQ_sessions_SessionToken (SessionToken),
+				KEY IX_sessions_CreatedAt (CreatedAt)
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS sessions`,
+		},
+	},
+	{
+		version: 2,
+		name:    "sessions_amount_minor_units",
+		up: []string{
+			`ALTER TABLE sessions
+				ADD COLUMN AmountMinor BIGINT NOT NULL DEFAULT 0 AFTER Amount,
+				ADD COLUMN Currency CHAR(3) NOT NULL DEFAULT 'RUB' AFTER AmountMinor`,
+			`UPDATE sessions SET AmountMinor = ROUND(Amount * 100)`,
+			`ALTER TABLE sessions DROP COLUMN Amount`,
+			`ALTER TABLE sessions CHANGE COLUMN AmountMinor Amount BIGINT NOT NULL`,
+		},
+		down: []string{
+			`ALTER TABLE sessions ADD COLUMN AmountMajor FLOAT NOT NULL DEFAULT 0 AFTER Amount`,
+			`UPDATE sessions SET AmountMajor = Amount / 100`,
+			`ALTER TABLE sessions DROP COLUMN Amount, DROP COLUMN Currency`,
+			`ALTER TABLE sessions CHANGE COLUMN AmountMajor Amount FLOAT NOT NULL`,
+		},
+	},
+	{
+		version: 3,
+		name:    "sessions_status",
+		up: []string{
+			`ALTER TABLE sessions
+				ADD COLUMN Status VARCHAR(16) NOT NULL DEFAULT 'created' AFTER Purpose,
+				MODIFY COLUMN ClosedAt DATETIME NULL DEFAULT NULL,
+				ADD KEY IX_sessions_Status (Status)`,
+			`UPDATE sessions SET Status = 'paid' WHERE ClosedAt <> '1000-01-01 00:00:00'`,
+			`UPDATE sessions SET ClosedAt = NULL WHERE ClosedAt = '1000-01-01 00:00:00'`,
+			`UPDATE sessions SET Status = 'expired', ClosedAt = DATE_ADD(CreatedAt, INTERVAL 15 MINUTE)
+				WHERE Status = 'created' AND CreatedAt < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 15 MINUTE)`,
+		},
+		down: []string{
+			`UPDATE sessions SET ClosedAt = NULL WHERE Status <> 'paid'`,
+			`UPDATE sessions SET ClosedAt = '1000-01-01 00:00:00' WHERE ClosedAt IS NULL`,
+			`ALTER TABLE sessions
+				DROP KEY IX_sessions_Status,
+				DROP COLUMN Status,
+				MODIFY COLUMN ClosedAt DATETIME NOT NULL DEFAULT '1000-01-01 00:00:00'`,
+		},
+	},
+	{
+		version: 4,
+		name:    "create_refunds",
+		up: []string{
+			`CREATE TABLE refunds (
+				RefundID INT NOT NULL AUTO_INCREMENT,
+				SessionID INT NOT NULL,
+				Amount BIGINT NOT NULL,
+				Currency CHAR(3) NOT NULL,
+				CreatedAt DATETIME NOT NULL,
+				PRIMARY KEY (RefundID),
+				KEY IX_refunds_SessionID (SessionID)
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS refunds`,
+		},
+	},
+	{
+		version: 5,
+		name:    "sessions_acquirer_result",
+		up: []string{
+			`ALTER TABLE sessions
+				ADD COLUMN AuthorizationID VARCHAR(64) NOT NULL DEFAULT '' AFTER Status,
+				ADD COLUMN DeclineReason VARCHAR(64) NOT NULL DEFAULT '' AFTER AuthorizationID`,
+		},
+		down: []string{
+			`ALTER TABLE sessions DROP COLUMN AuthorizationID, DROP COLUMN DeclineReason`,
+		},
+	},
+	{
+		version: 6,
+		name:    "sessions_two_step_capture",
+		up: []string{
+			`ALTER TABLE sessions
+				ADD COLUMN CaptureMode VARCHAR(8) NOT NULL DEFAULT 'auto' AFTER Status,
+				ADD COLUMN Captured BIGINT NOT NULL DEFAULT 0 AFTER CaptureMode,
+				ADD COLUMN AuthorizedAt DATETIME NULL DEFAULT NULL AFTER CreatedAt`,
+			`UPDATE sessions SET Captured = Amount WHERE Status IN ('paid', 'refunded')`,
+		},
+		down: []string{
+			`ALTER TABLE sessions DROP COLUMN CaptureMode, DROP COLUMN Captured, DROP COLUMN AuthorizedAt`,
+		},
+	},
+	{
+		version: 7,
+		name:    "create_idempotency_keys",
+		up: []string{
+			`CREATE TABLE idempotency_keys (
+				Scope VARCHAR(64) NOT NULL,
+				IdemKey VARCHAR(255) NOT NULL,
+				RequestHash CHAR(64) NOT NULL,
+				StatusCode INT NOT NULL DEFAULT 0,
+				ResponseBody BLOB NULL,
+				CreatedAt DATETIME NOT NULL,
+				ExpiresAt DATETIME NOT NULL,
+				PRIMARY KEY (Scope, IdemKey),
+				KEY IX_idempotency_keys_ExpiresAt (ExpiresAt)
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS idempotency_keys`,
+		},
+	},
+	{
+		version: 8,
+		name:    "create_webhook_deliveries",
+		up: []string{
+			`ALTER TABLE sessions ADD COLUMN CallbackURL VARCHAR(2048) NOT NULL DEFAULT '' AFTER DeclineReason`,
+			`CREATE TABLE webhook_deliveries (
+				DeliveryID INT NOT NULL AUTO_INCREMENT,
+				SessionID INT NOT NULL,
+				Event VARCHAR(32) NOT NULL,
+				URL VARCHAR(2048) NOT NULL,
+				Payload BLOB NOT NULL,
+				Status VARCHAR(16) NOT NULL,
+				Attempts INT NOT NULL DEFAULT 0,
+				ResponseCode INT NOT NULL DEFAULT 0,
+				LastError VARCHAR(255) NOT NULL DEFAULT '',
+				CreatedAt DATETIME NOT NULL,
+				NextAttemptAt DATETIME NOT NULL,
+				DeliveredAt DATETIME NULL DEFAULT NULL,
+				PRIMARY KEY (DeliveryID),
+				KEY IX_webhook_deliveries_SessionID (SessionID),
+				KEY IX_webhook_deliveries_Status_NextAttemptAt (Status, NextAttemptAt)
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS webhook_deliveries`,
+			`ALTER TABLE sessions DROP COLUMN CallbackURL`,
+		},
+	},
+	{
+		version: 9,
+		name:    "create_outbox_events",
+		up: []string{
+			`CREATE TABLE outbox_events (
+				EventID INT NOT NULL AUTO_INCREMENT,
+				EventType VARCHAR(32) NOT NULL,
+				SessionID INT NOT NULL,
+				SessionToken VARCHAR(36) NOT NULL,
+				Status VARCHAR(16) NOT NULL,
+				Payload BLOB NOT NULL,
+				CreatedAt DATETIME NOT NULL,
+				PublishedAt DATETIME NULL DEFAULT NULL,
+				PRIMARY KEY (EventID),
+				KEY IX_outbox_events_PublishedAt (PublishedAt, EventID)
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS outbox_events`,
+		},
+	},
+	{
+		version: 10,
+		name:    "sessions_expires_at",
+		up: []string{
+			`ALTER TABLE sessions ADD COLUMN ExpiresAt DATETIME NULL DEFAULT NULL AFTER CreatedAt`,
+			`UPDATE sessions SET ExpiresAt = CreatedAt + INTERVAL 15 MINUTE`,
+			`ALTER TABLE sessions MODIFY ExpiresAt DATETIME NOT NULL, ADD KEY IX_sessions_Status_ExpiresAt (Status, ExpiresAt)`,
+		},
+		down: []string{
+			`ALTER TABLE sessions DROP KEY IX_sessions_Status_ExpiresAt, DROP COLUMN ExpiresAt`,
+		},
+	},
+	{
+		version: 11,
+		name:    "create_users",
+		up: []string{
+			`CREATE TABLE users (
+				UserID INT NOT NULL AUTO_INCREMENT,
+				Email VARCHAR(255) NOT NULL,
+				EncryptedPassword VARCHAR(255) NOT NULL,
+				CreatedAt DATETIME NOT NULL,
+				PRIMARY KEY (UserID),
+				UNIQUE KEY UQ_users_Email (Email)
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS users`,
+		},
+	},
+	{
+		version: 12,
+		name:    "users_role",
+		up: []string{
+			`ALTER TABLE users ADD COLUMN Role VARCHAR(16) NOT NULL DEFAULT 'analyst' AFTER Email`,
+			`ALTER TABLE users ALTER COLUMN Role DROP DEFAULT`,
+		},
+		down: []string{
+			`ALTER TABLE users DROP COLUMN Role`,
+		},
+	},
+	{
+		version: 13,
+		name:    "create_tokens",
+		up: []string{
+			`CREATE TABLE refresh_tokens (
+				TokenID INT NOT NULL AUTO_INCREMENT,
+				UserID INT NOT NULL,
+				TokenHash CHAR(64) NOT NULL,
+				CreatedAt DATETIME NOT NULL,
+				ExpiresAt DATETIME NOT NULL,
+				RevokedAt DATETIME NULL DEFAULT NULL,
+				PRIMARY KEY (TokenID),
+				UNIQUE KEY UQ_refresh_tokens_TokenHash (TokenHash),
+				KEY IX_refresh_tokens_UserID (UserID),
+				KEY IX_refresh_tokens_ExpiresAt (ExpiresAt)
+			)`,
+			`CREATE TABLE revoked_tokens (
+				TokenID VARCHAR(36) NOT NULL,
+				ExpiresAt DATETIME NOT NULL,
+				PRIMARY KEY (TokenID),
+				KEY IX_revoked_tokens_ExpiresAt (ExpiresAt)
+			)`,
+		},
+		down: []string{
+			`DROP TABLE IF EXISTS revoked_tokens`,
+			`DROP TABLE IF EXISTS refresh_tokens`,
+		},
+	},
+	{
+		version: 14,
+		name:    "create_merchants",
+		up: []string{
+			`CREATE TABLE merchants (
+				MerchantID INT NOT NULL AUTO_INCREMENT,
+				Name VARCHAR(255) NOT NULL,
+				CallbackURL VARCHAR(2048) NOT NULL DEFAULT '',
+				SessionTTL INT NOT NULL DEFAULT 0,
+				CreatedAt DATETIME NOT NULL,
+				PRIMARY KEY (MerchantID)
+			)`,
+			`CREATE TABLE api_keys (
+				KeyID INT NOT NULL AUTO_INCREMENT,
+				MerchantID INT NOT NULL,
+				Prefix VARCHAR(16) NOT NULL,
+				KeyHash CHAR(64) NOT NULL,
+				CreatedAt DATETIME NOT NULL,
+				RevokedAt DATETIME NULL DEFAULT NULL,
+				PRIMARY KEY (KeyID),
+				UNIQUE KEY UQ_api_keys_Prefix (Prefix),
+				KEY IX_api_keys_MerchantID (MerchantID)
+			)`,
+			`ALTER TABLE sessions ADD COLUMN MerchantID INT NULL DEFAULT NULL AFTER SessionID,
+				ADD KEY IX_sessions_MerchantID_CreatedAt (MerchantID, CreatedAt)`,
+			`ALTER TABLE users ADD COLUMN MerchantID INT NULL DEFAULT NULL AFTER Role`,
+		},
+		down: []string{
+			`ALTER TABLE users DROP COLUMN MerchantID`,
+			`ALTER TABLE sessions DROP KEY IX_sessions_MerchantID_CreatedAt, DROP COLUMN MerchantID`,
+			`DROP TABLE IF EXISTS api_keys`,
+			`DROP TABLE IF EXISTS merchants`,
+		},
+	},
+	{
+		version: 15,
+		name:    "refunds_status",
+		up: []string{
+			`ALTER TABLE refunds ADD COLUMN Status VARCHAR(16) NOT NULL DEFAULT 'succeeded' AFTER Currency`,
+			`ALTER TABLE refunds ALTER COLUMN Status DROP DEFAULT`,
+		},
+		down: []string{
+			`DELETE FROM refunds WHERE Status <> 'succeeded'`,
+			`ALTER TABLE refunds DROP COLUMN Status`,
+		},
+	},
+	{
+		version: 16,
+		name:    "merchants_webhook_secret",
+		up: []string{
+			`ALTER TABLE merchants ADD COLUMN WebhookSecret VARCHAR(64) NOT NULL DEFAULT '' AFTER SessionTTL`,
+			`UPDATE merchants SET WebhookSecret = CONCAT('whsec_', LOWER(HEX(RANDOM_BYTES(24))))`,
+			`ALTER TABLE merchants ALTER COLUMN WebhookSecret DROP DEFAULT`,
+			`ALTER TABLE webhook_deliveries ADD COLUMN MerchantID INT NULL DEFAULT NULL AFTER SessionID`,
+			`UPDATE webhook_deliveries d JOIN sessions s ON s.SessionID = d.SessionID SET d.MerchantID = s.MerchantID`,
+		},
+		down: []string{
+			`ALTER TABLE webhook_deliveries DROP COLUMN MerchantID`,
+			`ALTER TABLE merchants DROP COLUMN WebhookSecret`,
+		},
+	},
+	{
+		version: 17,
+		name:    "sessions_amount_index",
+		up: []string{
+			`ALTER TABLE sessions ADD KEY IX_sessions_MerchantID_Amount (MerchantID, Amount, SessionID)`,
+		},
+		down: []string{
+			`ALTER TABLE sessions DROP KEY IX_sessions_MerchantID_Amount`,
+		},
+	},
+	{
+		version: 18,
+		name:    "sessions_processing_at",
+		up: []string{
+			`ALTER TABLE sessions ADD COLUMN ProcessingAt DATETIME NULL DEFAULT NULL AFTER ExpiresAt,
+				ADD KEY IX_sessions_Status_ProcessingAt (Status, ProcessingAt)`,
+			`UPDATE sessions SET ProcessingAt = CreatedAt WHERE Status = 'processing'`,
+		},
+		down: []string{
+			`ALTER TABLE sessions DROP KEY IX_sessions_Status_ProcessingAt, DROP COLUMN ProcessingAt`,
+		},
+	},
+}
@@ -0,0 +1,100 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"time"
+)
+
+type OutboxRepo struct {
+	store *Store
+}
+
+func (r *OutboxRepo) FindUnpublished(limit int) ([]model.OutboxEvent, error) {
+	rows, err := r.store.db.Query(
+		`SELECT EventID, EventType, SessionID, SessionToken, Status, Payload, CreatedAt, PublishedAt
+		FROM outbox_events WHERE PublishedAt IS NULL ORDER BY EventID LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.OutboxEvent
+	for rows.Next() {
+		var e model.OutboxEvent
+		if err := rows.Scan(
+			&e.EventID,
+			&e.EventType,
+			&e.SessionID,
+			&e.SessionToken,
+			&e.Status,
+			&e.Payload,
+			&e.CreatedAt,
+			&e.PublishedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func (r *OutboxRepo) MarkPublished(e *model.OutboxEvent, at time.Time) error {
+	if _, err := r.store.db.Exec(
+		"UPDATE outbox_events SET PublishedAt = ? WHERE EventID = ?",
+		at,
+		e.EventID,
+	); err != nil {
+		return err
+	}
+
+	e.PublishedAt = &at
+	return nil
+}
+
+// Удаляет не больше limit событий, опубликованных не позже before.
+// Неопубликованные события не удаляются независимо от возраста
+func (r *OutboxRepo) DeletePublished(before time.Time, limit int) (int, error) {
+	res, err := r.store.db.Exec(
+		"DELETE FROM outbox_events WHERE PublishedAt <= ? ORDER BY PublishedAt, EventID LIMIT ?",
+		before,
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// Записывает событие в outbox в рамках транзакции, изменяющей сессию
+func insertOutboxEvent(tx *sql.Tx, e *model.OutboxEvent) error {
+	res, err := tx.Exec(
+		`INSERT INTO outbox_events (EventType, SessionID, SessionToken, Status, Payload, CreatedAt)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		e.EventType,
+		e.SessionID,
+		e.SessionToken,
+		e.Status,
+		[]byte(e.Payload),
+		e.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.EventID = uint(id)
+
+	return nil
+}
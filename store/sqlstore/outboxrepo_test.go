@@ -0,0 +1,77 @@
+package sqlstore_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования записи событий в outbox вместе с изменением сессии
+func TestOutboxRepo_FindUnpublished(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("sessions", "outbox_events")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	s := &model.Session{
+		SessionToken: "2a6a8f4e-6b2a-4a52-9a41-3f1d1e0c0c11",
+		Amount:       model.Money{Minor: 10000, Currency: "RUB"},
+		Purpose:      "test",
+		CreatedAt:    now,
+	}
+	assert.NoError(t, st.Session().Create(s))
+
+	stale := *s
+	assert.NoError(t, st.Session().UpdateStatus(s, model.StatusCancelled, now))
+	assert.Equal(t, store.ErrSessionConflict, st.Session().UpdateStatus(&stale, model.StatusExpired, now))
+
+	events, err := st.Outbox().FindUnpublished(10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, model.OutboxSessionCreated, events[0].EventType)
+	assert.Equal(t, model.OutboxSessionStatusChanged, events[1].EventType)
+	assert.Equal(t, model.StatusCancelled, events[1].Status)
+	assert.Equal(t, s.SessionID, events[1].SessionID)
+
+	assert.NoError(t, st.Outbox().MarkPublished(&events[0], now))
+
+	events, err = st.Outbox().FindUnpublished(10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, model.StatusCancelled, events[0].Status)
+}
+
+// Функция для тестирования удаления старых опубликованных событий
+func TestOutboxRepo_DeletePublished(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("sessions", "outbox_events")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	s := &model.Session{
+		SessionToken: "5c0e1d7a-3f4b-4e2a-8d6c-9b1a2f3e4d5c",
+		Amount:       model.Money{Minor: 10000, Currency: "RUB"},
+		Purpose:      "test",
+		CreatedAt:    now,
+	}
+	assert.NoError(t, st.Session().Create(s))
+	assert.NoError(t, st.Session().UpdateStatus(s, model.StatusCancelled, now))
+
+	events, err := st.Outbox().FindUnpublished(10)
+	assert.NoError(t, err)
+	assert.NoError(t, st.Outbox().MarkPublished(&events[0], now.Add(-time.Hour)))
+
+	deleted, err := st.Outbox().DeletePublished(now.Add(-2*time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	deleted, err = st.Outbox().DeletePublished(now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	events, err = st.Outbox().FindUnpublished(10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, model.StatusCancelled, events[0].Status)
+}
@@ -0,0 +1,220 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+)
+
+type RefundRepo struct {
+	store *Store
+}
+
+func (r *RefundRepo) Create(s *model.Session, rf *model.Refund) error {
+	if rf.Amount.Minor <= 0 {
+		return model.ErrAmountNotPositive
+	}
+
+	tx, err := r.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Строка сессии блокируется до конца транзакции, поэтому параллельные
+	// возвраты по одной сессии проверяют остаток последовательно. Ожидающие
+	// ответа эквайера возвраты уменьшают остаток так же, как подтвержденные
+	status, amount, currency, err := lockSession(tx, s.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if status != model.StatusPaid {
+		return store.ErrSessionNotPaid
+	}
+
+	var reserved int64
+	if err := tx.QueryRow(
+		"SELECT COALESCE(SUM(Amount), 0) FROM refunds WHERE SessionID = ? AND Status <> ?",
+		s.SessionID,
+		model.RefundFailed,
+	).Scan(&reserved); err != nil {
+		return err
+	}
+
+	if reserved+rf.Amount.Minor > amount {
+		return store.ErrRefundExceedsAmount
+	}
+
+	rf.SessionID = s.SessionID
+	rf.Status = model.RefundPending
+	rf.Amount.Currency = currency
+
+	res, err := tx.Exec(
+		"INSERT INTO refunds (SessionID, Amount, Currency, Status, CreatedAt) VALUES (?, ?, ?, ?, ?)",
+		rf.SessionID,
+		rf.Amount.Minor,
+		rf.Amount.Currency,
+		rf.Status,
+		rf.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rf.RefundID = uint(id)
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.Status = status
+	return nil
+}
+
+func (r *RefundRepo) Complete(s *model.Session, rf *model.Refund) error {
+	tx, err := r.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status, amount, _, err := lockSession(tx, rf.SessionID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(
+		"UPDATE refunds SET Status = ? WHERE RefundID = ? AND Status = ?",
+		model.RefundSucceeded,
+		rf.RefundID,
+		model.RefundPending,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return store.ErrRefundNotPending
+	}
+	rf.Status = model.RefundSucceeded
+
+	var refunded int64
+	if err := tx.QueryRow(
+		"SELECT COALESCE(SUM(Amount), 0) FROM refunds WHERE SessionID = ? AND Status = ?",
+		rf.SessionID,
+		model.RefundSucceeded,
+	).Scan(&refunded); err != nil {
+		return err
+	}
+
+	updated := *s
+	updated.Status = status
+
+	if status == model.StatusPaid && refunded == amount {
+		if _, err := tx.Exec(
+			"UPDATE sessions SET Status = ? WHERE SessionID = ?",
+			model.StatusRefunded,
+			rf.SessionID,
+		); err != nil {
+			return err
+		}
+		updated.Status = model.StatusRefunded
+
+		event, err := model.NewSessionEvent(model.OutboxSessionStatusChanged, &updated, &updated, rf.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		if err := insertOutboxEvent(tx, event); err != nil {
+			return err
+		}
+	}
+
+	// Событие возврата несет статус сессии после него
+	event, err := model.NewSessionEvent(model.OutboxRefundCreated, &updated, rf, rf.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := insertOutboxEvent(tx, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.Status = updated.Status
+	return nil
+}
+
+func (r *RefundRepo) Fail(rf *model.Refund) error {
+	res, err := r.store.db.Exec(
+		"UPDATE refunds SET Status = ? WHERE RefundID = ? AND Status = ?",
+		model.RefundFailed,
+		rf.RefundID,
+		model.RefundPending,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return store.ErrRefundNotPending
+	}
+
+	rf.Status = model.RefundFailed
+	return nil
+}
+
+func (r *RefundRepo) FindBySession(s *model.Session) ([]model.Refund, error) {
+	rows, err := r.store.db.Query(
+		"SELECT RefundID, SessionID, Amount, Currency, Status, CreatedAt FROM refunds WHERE SessionID = ? ORDER BY RefundID",
+		s.SessionID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []model.Refund
+	for rows.Next() {
+		var rf model.Refund
+		if err := rows.Scan(&rf.RefundID, &rf.SessionID, &rf.Amount.Minor, &rf.Amount.Currency, &rf.Status, &rf.CreatedAt); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, rf)
+	}
+
+	return refunds, rows.Err()
+}
+
+// Блокирует строку сессии до конца транзакции и возвращает ее статус,
+// списанную сумму и валюту
+func lockSession(tx *sql.Tx, sessionID uint) (model.SessionStatus, int64, string, error) {
+	var (
+		status   model.SessionStatus
+		amount   int64
+		currency string
+	)
+	if err := tx.QueryRow(
+		"SELECT Status, Captured, Currency FROM sessions WHERE SessionID = ? FOR UPDATE",
+		sessionID,
+	).Scan(&status, &amount, &currency); err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, "", store.ErrNoSession
+		}
+		return "", 0, "", err
+	}
+	return status, amount, currency, nil
+}
@@ -0,0 +1,63 @@
+package sqlstore_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования резервирования, частичных и полного возврата по сессии
+func TestRefundRepo_Create(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("sessions", "refunds")
+
+	s := &model.Session{
+		Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+		SessionToken: "ca197d71-142c-4bef-abd8-65f0bdd53f0b",
+		Purpose:      "test",
+		CreatedAt:    time.Now(),
+	}
+	st.Session().Create(s)
+
+	err := st.Refund().Create(s, &model.Refund{Amount: model.Money{Minor: 100}, CreatedAt: time.Now()})
+	assert.EqualError(t, err, store.ErrSessionNotPaid.Error())
+
+	s.Captured = s.Amount
+	assert.NoError(t, st.Session().CommitSession(s, time.Now()))
+
+	rf := &model.Refund{Amount: model.Money{Minor: 400}, CreatedAt: time.Now()}
+	assert.NoError(t, st.Refund().Create(s, rf))
+	assert.NotZero(t, rf.RefundID)
+	assert.Equal(t, model.RefundPending, rf.Status)
+	assert.Equal(t, model.DefaultCurrency, rf.Amount.Currency)
+
+	// Ожидающий возврат уже занимает часть остатка
+	err = st.Refund().Create(s, &model.Refund{Amount: model.Money{Minor: 700}, CreatedAt: time.Now()})
+	assert.EqualError(t, err, store.ErrRefundExceedsAmount.Error())
+
+	assert.NoError(t, st.Refund().Complete(s, rf))
+	assert.Equal(t, model.RefundSucceeded, rf.Status)
+	assert.Equal(t, model.StatusPaid, s.Status)
+	assert.Equal(t, store.ErrRefundNotPending, st.Refund().Complete(s, rf))
+
+	// Отклоненный возврат освобождает сумму
+	failed := &model.Refund{Amount: model.Money{Minor: 600}, CreatedAt: time.Now()}
+	assert.NoError(t, st.Refund().Create(s, failed))
+	assert.NoError(t, st.Refund().Fail(failed))
+	assert.Equal(t, model.RefundFailed, failed.Status)
+	assert.Equal(t, store.ErrRefundNotPending, st.Refund().Fail(failed))
+
+	last := &model.Refund{Amount: model.Money{Minor: 600}, CreatedAt: time.Now()}
+	assert.NoError(t, st.Refund().Create(s, last))
+	assert.Equal(t, model.StatusPaid, s.Status)
+	assert.NoError(t, st.Refund().Complete(s, last))
+	assert.Equal(t, model.StatusRefunded, s.Status)
+
+	refunds, err := st.Refund().FindBySession(s)
+	assert.NoError(t, err)
+	assert.Len(t, refunds, 3)
+	assert.Equal(t, model.RefundFailed, refunds[1].Status)
+}
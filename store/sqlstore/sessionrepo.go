@@ -0,0 +1,640 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"strings"
+	"time"
+)
+
+type SessionRepo struct {
+	store *Store
+}
+
+func (r *SessionRepo) Create(s *model.Session) error {
+	if s.Status == "" {
+		s.Status = model.StatusCreated
+	}
+	if s.CaptureMode == "" {
+		s.CaptureMode = model.CaptureAuto
+	}
+	if s.ExpiresAt.IsZero() {
+		s.ExpiresAt = s.CreatedAt.Add(model.DefaultSessionTTL)
+	}
+
+	tx, err := r.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO sessions (MerchantID, SessionToken, Amount, Currency, Purpose, Status, CaptureMode, CallbackURL, CreatedAt, ExpiresAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullableID(s.MerchantID),
+		s.SessionToken,
+		s.Amount.Minor,
+		s.Amount.Currency,
+		s.Purpose,
+		s.Status,
+		s.CaptureMode,
+		s.CallbackURL,
+		s.CreatedAt,
+		s.ExpiresAt)
+
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	s.SessionID = uint(id)
+
+	event, err := model.NewSessionEvent(model.OutboxSessionCreated, s, s, s.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := insertOutboxEvent(tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Колонки сессии в порядке, в котором их читает scanSession
+const sessionColumns = `SessionID, COALESCE(MerchantID, 0), SessionToken, Amount, Currency, Purpose, Status, CaptureMode, Captured,
+	AuthorizationID, DeclineReason, CallbackURL, CreatedAt, ExpiresAt, ProcessingAt, AuthorizedAt, ClosedAt`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row scanner) (*model.Session, error) {
+	s := &model.Session{}
+	if err := row.Scan(
+		&s.SessionID,
+		&s.MerchantID,
+		&s.SessionToken,
+		&s.Amount.Minor,
+		&s.Amount.Currency,
+		&s.Purpose,
+		&s.Status,
+		&s.CaptureMode,
+		&s.Captured.Minor,
+		&s.AuthorizationID,
+		&s.DeclineReason,
+		&s.CallbackURL,
+		&s.CreatedAt,
+		&s.ExpiresAt,
+		&s.ProcessingAt,
+		&s.AuthorizedAt,
+		&s.ClosedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	s.Captured.Currency = s.Amount.Currency
+	return s, nil
+}
+
+func (r *SessionRepo) FindByToken(scope store.Scope, token string) (*model.Session, error) {
+	cond, args := scopeCondition(scope, "MerchantID")
+	s, err := scanSession(r.store.db.QueryRow(
+		`SELECT `+sessionColumns+` FROM sessions WHERE SessionToken = ? AND `+cond,
+		append([]interface{}{token}, args...)...,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrNoSession
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (r *SessionRepo) CommitSession(s *model.Session, closedAt time.Time) error {
+	return r.UpdateStatus(s, model.StatusPaid, closedAt)
+}
+
+func (r *SessionRepo) UpdateStatus(s *model.Session, status model.SessionStatus, at time.Time) error {
+	if !s.Status.CanTransitionTo(status) {
+		return store.ErrInvalidTransition
+	}
+
+	var processingAt, closedAt interface{}
+	if status == model.StatusProcessing {
+		processingAt = at
+	}
+	if !status.IsOpen() {
+		closedAt = at
+	}
+
+	// Статус, прочитанный вызывающим, служит версией строки: если другой
+	// запрос успел его изменить, обновление не затронет ни одной строки
+	query := `UPDATE sessions SET
+			Status = ?, Captured = ?, AuthorizationID = ?, DeclineReason = ?, AuthorizedAt = ?,
+			ProcessingAt = COALESCE(?, ProcessingAt), ClosedAt = COALESCE(ClosedAt, ?)
+		WHERE SessionToken = ? AND Status = ?`
+	args := []interface{}{
+		status,
+		s.Captured.Minor,
+		s.AuthorizationID,
+		s.DeclineReason,
+		s.AuthorizedAt,
+		processingAt,
+		closedAt,
+		s.SessionToken,
+		s.Status,
+	}
+
+	tx, err := r.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return store.ErrSessionConflict
+	}
+
+	updated := *s
+	updated.Status = status
+	if status == model.StatusProcessing {
+		updated.ProcessingAt = &at
+	}
+	if !status.IsOpen() && updated.ClosedAt == nil {
+		updated.ClosedAt = &at
+	}
+
+	event, err := model.NewSessionEvent(model.OutboxSessionStatusChanged, &updated, &updated, at)
+	if err != nil {
+		return err
+	}
+
+	if err := insertOutboxEvent(tx, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*s = updated
+	return nil
+}
+
+// Переводит в expired не больше limit неоплаченных сессий, время жизни которых
+// истекло к моменту at, одной транзакцией и возвращает их уже с новым статусом
+func (r *SessionRepo) ExpireSessions(at time.Time, limit int) ([]model.Session, error) {
+	tx, err := r.store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT SessionID, COALESCE(MerchantID, 0), SessionToken, Amount, Currency, Purpose, Status, CaptureMode, CallbackURL, CreatedAt, ExpiresAt
+		FROM sessions WHERE Status = ? AND ExpiresAt <= ?
+		ORDER BY SessionID LIMIT ? FOR UPDATE`,
+		model.StatusCreated,
+		at,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []model.Session
+	for rows.Next() {
+		var s model.Session
+		if err := rows.Scan(
+			&s.SessionID,
+			&s.MerchantID,
+			&s.SessionToken,
+			&s.Amount.Minor,
+			&s.Amount.Currency,
+			&s.Purpose,
+			&s.Status,
+			&s.CaptureMode,
+			&s.CallbackURL,
+			&s.CreatedAt,
+			&s.ExpiresAt,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		s.Captured.Currency = s.Amount.Currency
+		sessions = append(sessions, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(sessions) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]interface{}, 0, len(sessions)+2)
+	ids = append(ids, model.StatusExpired, at)
+	for _, s := range sessions {
+		ids = append(ids, s.SessionID)
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE sessions SET Status = ?, ClosedAt = COALESCE(ClosedAt, ?)
+		WHERE SessionID IN (?`+strings.Repeat(", ?", len(sessions)-1)+`)`,
+		ids...,
+	); err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		closedAt := at
+		sessions[i].Status = model.StatusExpired
+		sessions[i].ClosedAt = &closedAt
+
+		event, err := model.NewSessionEvent(model.OutboxSessionStatusChanged, &sessions[i], &sessions[i], at)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := insertOutboxEvent(tx, event); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Возвращает не больше limit сессий, авторизованных не позже authorizedBefore
+// и так и не списанных или отмененных. Статус не меняется: вызывающий
+// сначала отменяет авторизацию у эквайера, а затем обновляет сессию
+func (r *SessionRepo) FindExpiredAuthorizations(authorizedBefore time.Time, limit int) ([]model.Session, error) {
+	return r.findByStatus(
+		`Status = ? AND AuthorizedAt <= ? ORDER BY SessionID LIMIT ?`,
+		model.StatusAuthorized,
+		authorizedBefore,
+		limit,
+	)
+}
+
+// Возвращает не больше limit сессий, которые перешли в processing
+// не позже startedBefore и так и не получили ответ эквайера
+func (r *SessionRepo) FindStaleProcessing(startedBefore time.Time, limit int) ([]model.Session, error) {
+	return r.findByStatus(
+		`Status = ? AND ProcessingAt <= ? ORDER BY SessionID LIMIT ?`,
+		model.StatusProcessing,
+		startedBefore,
+		limit,
+	)
+}
+
+func (r *SessionRepo) findByStatus(cond string, args ...interface{}) ([]model.Session, error) {
+	rows, err := r.store.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE `+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (r *SessionRepo) GetStats(scope store.Scope, q *store.StatsQuery) ([]model.Session, *store.StatsCursor, error) {
+	// Лишняя строка показывает, что за страницей есть продолжение
+	page := *q
+	if page.Sort == "" {
+		page.Sort = store.SortCreatedAtDesc
+	}
+	if q.Limit > 0 {
+		page.Limit = q.Limit + 1
+	}
+
+	var sessions []model.Session
+	if err := r.StreamStats(scope, &page, func(s *model.Session) error {
+		sessions = append(sessions, *s)
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	if sessions == nil {
+		return nil, nil, store.ErrNoStats
+	}
+
+	var next *store.StatsCursor
+	if q.Limit > 0 && len(sessions) > q.Limit {
+		sessions = sessions[:q.Limit]
+		next = store.NewStatsCursor(page.Sort, &sessions[q.Limit-1])
+	}
+
+	return sessions, next, nil
+}
+
+// Передает сессии выборки в fn по одной, не загружая выборку в память.
+// Ошибка fn прерывает чтение и возвращается вызывающему
+func (r *SessionRepo) StreamStats(scope store.Scope, q *store.StatsQuery, fn func(s *model.Session) error) error {
+	order := q.Sort
+	if order == "" {
+		order = store.SortCreatedAtDesc
+	}
+
+	column, direction, cmp := "CreatedAt", "ASC", ">"
+	if order.ByAmount() {
+		column = "Amount"
+	}
+	if order.Descending() {
+		direction, cmp = "DESC", "<"
+	}
+
+	cond, args := statsCondition(scope, &q.StatsFilter, "")
+	if q.After != nil {
+		var after interface{} = q.After.CreatedAt
+		if order.ByAmount() {
+			after = q.After.Amount
+		}
+		cond += " AND (" + column + " " + cmp + " ? OR (" + column + " = ? AND SessionID " + cmp + " ?))"
+		args = append(args, after, after, q.After.SessionID)
+	}
+
+	query := `SELECT SessionID, COALESCE(MerchantID, 0), Amount, Currency, Purpose, Status, CaptureMode, DeclineReason, CreatedAt, ExpiresAt, ClosedAt
+		FROM sessions WHERE ` + cond + `
+		ORDER BY ` + column + " " + direction + ", SessionID " + direction
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := r.store.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s model.Session
+		if err := rows.Scan(
+			&s.SessionID,
+			&s.MerchantID,
+			&s.Amount.Minor,
+			&s.Amount.Currency,
+			&s.Purpose,
+			&s.Status,
+			&s.CaptureMode,
+			&s.DeclineReason,
+			&s.CreatedAt,
+			&s.ExpiresAt,
+			&s.ClosedAt,
+		); err != nil {
+			return err
+		}
+		if err := fn(&s); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *SessionRepo) GetTotals(scope store.Scope, f *store.StatsFilter) ([]model.CurrencyTotal, error) {
+	cond, args := statsCondition(scope, f, "s.")
+	rows, err := r.store.db.Query(
+		`SELECT
+			s.Currency,
+			COUNT(*),
+			SUM(s.Amount),
+			SUM(s.Status IN ('paid', 'refunded')),
+			COALESCE(SUM(CASE WHEN s.Status IN ('paid', 'refunded') THEN s.Captured END), 0),
+			SUM(s.Status = 'authorized'),
+			COALESCE(SUM(CASE WHEN s.Status = 'authorized' THEN s.Amount END), 0),
+			COALESCE(SUM(rf.Refunded), 0),
+			SUM(s.Status = 'cancelled')
+		FROM sessions s
+		LEFT JOIN (
+			SELECT SessionID, SUM(Amount) AS Refunded FROM refunds WHERE Status = 'succeeded' GROUP BY SessionID
+		) rf ON rf.SessionID = s.SessionID
+		WHERE `+cond+`
+		GROUP BY s.Currency
+		ORDER BY s.Currency`,
+		args...,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []model.CurrencyTotal
+	for rows.Next() {
+		var t model.CurrencyTotal
+		if err := rows.Scan(
+			&t.Currency,
+			&t.Count,
+			&t.Amount.Minor,
+			&t.PaidCount,
+			&t.PaidAmount.Minor,
+			&t.AuthorizedCount,
+			&t.AuthorizedAmount.Minor,
+			&t.RefundedAmount.Minor,
+			&t.CancelledCount,
+		); err != nil {
+			return nil, err
+		}
+		t.Amount.Currency = t.Currency
+		t.PaidAmount.Currency = t.Currency
+		t.AuthorizedAmount.Currency = t.Currency
+		t.RefundedAmount.Currency = t.Currency
+		t.NetAmount = model.Money{Minor: t.PaidAmount.Minor - t.RefundedAmount.Minor, Currency: t.Currency}
+		totals = append(totals, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if totals == nil {
+		return nil, store.ErrNoStats
+	}
+
+	return totals, nil
+}
+
+// Сводная статистика считается агрегатами в БД, в память загружаются
+// только итоговые строки
+func (r *SessionRepo) GetSummary(scope store.Scope, f *store.StatsFilter, bounds []time.Time) (*model.StatsSummary, error) {
+	cond, args := statsCondition(scope, f, "")
+	summary := &model.StatsSummary{Statuses: []model.StatusTotal{}, Buckets: []model.StatsBucket{}}
+
+	if err := r.store.db.QueryRow(
+		`SELECT
+			COUNT(*),
+			COALESCE(SUM(Status IN ('paid', 'refunded')), 0),
+			COALESCE(SUM(Status IN ('paid', 'refunded')) / COUNT(*), 0),
+			COALESCE(AVG(CASE WHEN Status IN ('paid', 'refunded') THEN TIMESTAMPDIFF(SECOND, CreatedAt, ClosedAt) END), 0)
+		FROM sessions WHERE `+cond,
+		args...,
+	).Scan(
+		&summary.Count,
+		&summary.PaidCount,
+		&summary.ConversionRate,
+		&summary.AvgTimeToPay,
+	); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.store.db.Query(
+		`SELECT Status, Currency, COUNT(*), SUM(Amount)
+		FROM sessions WHERE `+cond+`
+		GROUP BY Status, Currency
+		ORDER BY Status, Currency`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t model.StatusTotal
+		if err := rows.Scan(&t.Status, &t.Currency, &t.Count, &t.Amount.Minor); err != nil {
+			return nil, err
+		}
+		t.Amount.Currency = t.Currency
+		summary.Statuses = append(summary.Statuses, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(bounds) < 2 {
+		return summary, nil
+	}
+
+	// Границы интервалов вычисляются заранее с учетом часового пояса
+	// и передаются в запрос таблицей, поэтому группировка не зависит
+	// от загруженных в MySQL описаний часовых поясов
+	parts := make([]string, 0, len(bounds)-1)
+	var bucketArgs []interface{}
+	for i := 0; i < len(bounds)-1; i++ {
+		parts = append(parts, "SELECT ? AS Idx, ? AS StartAt, ? AS EndAt")
+		bucketArgs = append(bucketArgs, i, bounds[i].UTC(), bounds[i+1].UTC())
+	}
+
+	cond, args = statsCondition(scope, f, "s.")
+	rows, err = r.store.db.Query(
+		`SELECT
+			b.Idx,
+			s.Currency,
+			COUNT(*),
+			SUM(s.Amount),
+			SUM(s.Status IN ('paid', 'refunded')),
+			COALESCE(SUM(CASE WHEN s.Status IN ('paid', 'refunded') THEN s.Captured END), 0)
+		FROM sessions s
+		JOIN (`+strings.Join(parts, " UNION ALL ")+`) b ON s.CreatedAt >= b.StartAt AND s.CreatedAt < b.EndAt
+		WHERE `+cond+`
+		GROUP BY b.Idx, s.Currency
+		ORDER BY b.Idx, s.Currency`,
+		append(bucketArgs, args...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var index int
+		var b model.StatsBucket
+		if err := rows.Scan(&index, &b.Currency, &b.Count, &b.Amount.Minor, &b.PaidCount, &b.PaidAmount.Minor); err != nil {
+			return nil, err
+		}
+		b.Start = bounds[index]
+		b.Amount.Currency = b.Currency
+		b.PaidAmount.Currency = b.Currency
+		summary.Buckets = append(summary.Buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// Условие на мерчанта для запросов к сессиям. Сессии, созданные до появления
+// мерчантов, хранятся с NULL и видны только без ограничения области
+func scopeCondition(scope store.Scope, column string) (string, []interface{}) {
+	if id, ok := scope.Merchant(); ok {
+		return column + " = ?", []interface{}{id}
+	}
+	return "TRUE", nil
+}
+
+// Условие отбора сессий для статистики. Колонки берутся с префиксом
+// псевдонима таблицы, если запрос соединяет несколько таблиц
+func statsCondition(scope store.Scope, f *store.StatsFilter, prefix string) (string, []interface{}) {
+	cond, args := scopeCondition(scope, prefix+"MerchantID")
+
+	conds := []string{prefix + "CreatedAt >= ?", prefix + "CreatedAt < ?", cond}
+	args = append([]interface{}{f.Begin, f.End}, args...)
+
+	if len(f.Statuses) > 0 {
+		conds = append(conds, prefix+"Status IN (?"+strings.Repeat(", ?", len(f.Statuses)-1)+")")
+		for _, status := range f.Statuses {
+			args = append(args, status)
+		}
+	}
+	if f.Currency != "" {
+		conds = append(conds, prefix+"Currency = ?")
+		args = append(args, f.Currency)
+	}
+	if f.MinAmount > 0 {
+		conds = append(conds, prefix+"Amount >= ?")
+		args = append(args, f.MinAmount)
+	}
+	if f.MaxAmount > 0 {
+		conds = append(conds, prefix+"Amount <= ?")
+		args = append(args, f.MaxAmount)
+	}
+	if f.Purpose != "" {
+		conds = append(conds, prefix+"Purpose LIKE ?")
+		args = append(args, "%"+likeEscaper.Replace(f.Purpose)+"%")
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func nullableID(id uint) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
@@ -0,0 +1,140 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+type Store struct {
+	config       *store.Config
+	db           *sql.DB
+	sessionRepo  *SessionRepo
+	refundRepo   *RefundRepo
+	idemRepo     *IdempotencyRepo
+	webhookRepo  *WebhookRepo
+	outboxRepo   *OutboxRepo
+	merchantRepo *MerchantRepo
+	userRepo     *UserRepo
+	tokenRepo    *TokenRepo
+}
+
+func New(config *store.Config) *Store {
+	return &Store{
+		config: config,
+	}
+}
+
+func (s *Store) Open(cs string) error {
+	db, err := sql.Open("mysql", cs)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Ping(); err != nil {
+		return err
+	}
+
+	s.db = db
+	return nil
+}
+
+func (s *Store) Close() {
+	s.db.Close()
+}
+
+func (s *Store) Session() store.SessionRepository {
+	if s.sessionRepo != nil {
+		return s.sessionRepo
+	}
+
+	s.sessionRepo = &SessionRepo{
+		store: s,
+	}
+
+	return s.sessionRepo
+}
+
+func (s *Store) Refund() store.RefundRepository {
+	if s.refundRepo != nil {
+		return s.refundRepo
+	}
+
+	s.refundRepo = &RefundRepo{
+		store: s,
+	}
+
+	return s.refundRepo
+}
+
+func (s *Store) Idempotency() store.IdempotencyRepository {
+	if s.idemRepo != nil {
+		return s.idemRepo
+	}
+
+	s.idemRepo = &IdempotencyRepo{
+		store: s,
+	}
+
+	return s.idemRepo
+}
+
+func (s *Store) Webhook() store.WebhookRepository {
+	if s.webhookRepo != nil {
+		return s.webhookRepo
+	}
+
+	s.webhookRepo = &WebhookRepo{
+		store: s,
+	}
+
+	return s.webhookRepo
+}
+
+func (s *Store) Outbox() store.OutboxRepository {
+	if s.outboxRepo != nil {
+		return s.outboxRepo
+	}
+
+	s.outboxRepo = &OutboxRepo{
+		store: s,
+	}
+
+	return s.outboxRepo
+}
+
+func (s *Store) Merchant() store.MerchantRepository {
+	if s.merchantRepo != nil {
+		return s.merchantRepo
+	}
+
+	s.merchantRepo = &MerchantRepo{
+		store: s,
+	}
+
+	return s.merchantRepo
+}
+
+func (s *Store) User() store.UserRepository {
+	if s.userRepo != nil {
+		return s.userRepo
+	}
+
+	s.userRepo = &UserRepo{
+		store: s,
+	}
+
+	return s.userRepo
+}
+
+func (s *Store) Token() store.TokenRepository {
+	if s.tokenRepo != nil {
+		return s.tokenRepo
+	}
+
+	s.tokenRepo = &TokenRepo{
+		store: s,
+	}
+
+	return s.tokenRepo
+}
@@ -1,7 +1,8 @@
-package store
+package sqlstore
 
 import (
 	"fmt"
+	"github.com/bolshagin/xsolla-be-2020/store"
 	"strings"
 	"testing"
 )
@@ -9,13 +10,17 @@ import (
 func TestStore(t *testing.T, cs string) (*Store, func(...string)) {
 	t.Helper()
 
-	config := NewConfig()
+	config := store.NewConfig()
 
 	s := New(config)
 	if err := s.Open(cs); err != nil {
 		t.Fatal(err)
 	}
 
+	if err := s.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
 	return s, func(tables ...string) {
 		if len(tables) > 0 {
 			if _, err := s.db.Exec(fmt.Sprintf("TRUNCATE %s ", strings.Join(tables, ", "))); err != nil {
@@ -0,0 +1,130 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"time"
+)
+
+type TokenRepo struct {
+	store *Store
+}
+
+func (r *TokenRepo) CreateRefresh(t *model.RefreshToken) error {
+	res, err := r.store.db.Exec(
+		"INSERT INTO refresh_tokens (UserID, TokenHash, CreatedAt, ExpiresAt) VALUES (?, ?, ?, ?)",
+		t.UserID,
+		t.TokenHash,
+		t.CreatedAt,
+		t.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	t.TokenID = uint(id)
+
+	return nil
+}
+
+func (r *TokenRepo) FindRefresh(hash string) (*model.RefreshToken, error) {
+	t := &model.RefreshToken{}
+	if err := r.store.db.QueryRow(
+		"SELECT TokenID, UserID, TokenHash, CreatedAt, ExpiresAt, RevokedAt FROM refresh_tokens WHERE TokenHash = ?",
+		hash,
+	).Scan(
+		&t.TokenID,
+		&t.UserID,
+		&t.TokenHash,
+		&t.CreatedAt,
+		&t.ExpiresAt,
+		&t.RevokedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrNoRefreshToken
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Токен отзывается условным обновлением, поэтому из двух одновременных
+// обновлений по одному токену успешно только одно
+func (r *TokenRepo) RevokeRefresh(t *model.RefreshToken, at time.Time) error {
+	res, err := r.store.db.Exec(
+		"UPDATE refresh_tokens SET RevokedAt = ? WHERE TokenID = ? AND RevokedAt IS NULL",
+		at,
+		t.TokenID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store.ErrRefreshTokenRevoked
+	}
+
+	t.RevokedAt = &at
+	return nil
+}
+
+func (r *TokenRepo) RevokeUserRefresh(userID uint, at time.Time) error {
+	_, err := r.store.db.Exec(
+		"UPDATE refresh_tokens SET RevokedAt = ? WHERE UserID = ? AND RevokedAt IS NULL",
+		at,
+		userID,
+	)
+	return err
+}
+
+func (r *TokenRepo) Revoke(jti string, expiresAt time.Time) error {
+	_, err := r.store.db.Exec(
+		"INSERT IGNORE INTO revoked_tokens (TokenID, ExpiresAt) VALUES (?, ?)",
+		jti,
+		expiresAt,
+	)
+	return err
+}
+
+func (r *TokenRepo) IsRevoked(jti string) (bool, error) {
+	var n int
+	if err := r.store.db.QueryRow(
+		"SELECT COUNT(*) FROM revoked_tokens WHERE TokenID = ?",
+		jti,
+	).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (r *TokenRepo) DeleteExpired(at time.Time) (int, error) {
+	deleted := 0
+	for _, query := range []string{
+		"DELETE FROM refresh_tokens WHERE ExpiresAt <= ?",
+		"DELETE FROM revoked_tokens WHERE ExpiresAt <= ?",
+	} {
+		res, err := r.store.db.Exec(query, at)
+		if err != nil {
+			return deleted, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(n)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,74 @@
+package sqlstore_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования создания, поиска и отзыва токена обновления
+func TestTokenRepo_Refresh(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("refresh_tokens", "revoked_tokens")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	_, err := st.Token().FindRefresh(model.HashToken("unknown"))
+	assert.Equal(t, store.ErrNoRefreshToken, err)
+
+	token, value, err := model.NewRefreshToken(1, now, time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, st.Token().CreateRefresh(token))
+	assert.NotZero(t, token.TokenID)
+
+	found, err := st.Token().FindRefresh(model.HashToken(value))
+	assert.NoError(t, err)
+	assert.True(t, found.IsActive(now))
+
+	assert.NoError(t, st.Token().RevokeRefresh(found, now))
+	assert.Equal(t, store.ErrRefreshTokenRevoked, st.Token().RevokeRefresh(found, now))
+
+	found, err = st.Token().FindRefresh(model.HashToken(value))
+	assert.NoError(t, err)
+	assert.False(t, found.IsActive(now))
+
+	other, _, err := model.NewRefreshToken(1, now, time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, st.Token().CreateRefresh(other))
+	assert.NoError(t, st.Token().RevokeUserRefresh(1, now))
+	assert.Equal(t, store.ErrRefreshTokenRevoked, st.Token().RevokeRefresh(other, now))
+}
+
+// Функция для тестирования списка отозванных токенов доступа и удаления истекших записей
+func TestTokenRepo_Revoke(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("refresh_tokens", "revoked_tokens")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	revoked, err := st.Token().IsRevoked("jti")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	assert.NoError(t, st.Token().Revoke("jti", now.Add(time.Minute)))
+	revoked, err = st.Token().IsRevoked("jti")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	token, _, err := model.NewRefreshToken(1, now, time.Hour)
+	assert.NoError(t, err)
+	assert.NoError(t, st.Token().CreateRefresh(token))
+
+	deleted, err := st.Token().DeleteExpired(now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	revoked, err = st.Token().IsRevoked("jti")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	deleted, err = st.Token().DeleteExpired(now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+}
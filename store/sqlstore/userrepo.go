@@ -0,0 +1,79 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/go-sql-driver/mysql"
+	"strings"
+)
+
+// Код ошибки MySQL при нарушении уникального ключа
+const errDuplicateEntry = 1062
+
+type UserRepo struct {
+	store *Store
+}
+
+func (r *UserRepo) Create(u *model.User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	if err := u.BeforeCreate(); err != nil {
+		return err
+	}
+
+	res, err := r.store.db.Exec(
+		"INSERT INTO users (Email, Role, MerchantID, EncryptedPassword, CreatedAt) VALUES (?, ?, ?, ?, ?)",
+		u.Email,
+		u.Role,
+		nullableID(u.MerchantID),
+		u.EncryptedPassword,
+		u.CreatedAt,
+	)
+	if err != nil {
+		if me, ok := err.(*mysql.MySQLError); ok && me.Number == errDuplicateEntry {
+			return store.ErrUserExists
+		}
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	u.UserID = uint(id)
+
+	return nil
+}
+
+func (r *UserRepo) Find(id uint) (*model.User, error) {
+	return r.findBy("UserID", id)
+}
+
+func (r *UserRepo) FindByEmail(email string) (*model.User, error) {
+	return r.findBy("Email", strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (r *UserRepo) findBy(column string, value interface{}) (*model.User, error) {
+	u := &model.User{}
+	if err := r.store.db.QueryRow(
+		"SELECT UserID, Email, Role, COALESCE(MerchantID, 0), EncryptedPassword, CreatedAt FROM users WHERE "+column+" = ?",
+		value,
+	).Scan(
+		&u.UserID,
+		&u.Email,
+		&u.Role,
+		&u.MerchantID,
+		&u.EncryptedPassword,
+		&u.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrNoUser
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
@@ -0,0 +1,62 @@
+package sqlstore_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования создания пользователя с хешированным паролем
+func TestUserRepo_Create(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("users")
+
+	u := &model.User{
+		Email:     "user@example.org",
+		Role:      model.RoleAnalyst,
+		Password:  "password",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	assert.NoError(t, st.User().Create(u))
+	assert.NotZero(t, u.UserID)
+	assert.Empty(t, u.Password)
+
+	duplicate := &model.User{Email: "USER@example.org", Role: model.RoleAnalyst, Password: "password"}
+	assert.Equal(t, store.ErrUserExists, st.User().Create(duplicate))
+
+	weak := &model.User{Email: "other@example.org", Role: model.RoleAnalyst, Password: "pass"}
+	assert.Equal(t, model.ErrPasswordTooWeak, st.User().Create(weak))
+}
+
+// Функция для тестирования поиска пользователя по идентификатору и email
+func TestUserRepo_Find(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("users")
+
+	_, err := st.User().FindByEmail("user@example.org")
+	assert.Equal(t, store.ErrNoUser, err)
+
+	u := &model.User{
+		Email:     "user@example.org",
+		Role:      model.RoleAnalyst,
+		Password:  "password",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	assert.NoError(t, st.User().Create(u))
+
+	found, err := st.User().FindByEmail(" User@Example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, u.UserID, found.UserID)
+	assert.True(t, found.ComparePassword("password"))
+
+	found, err = st.User().Find(u.UserID)
+	assert.NoError(t, err)
+	assert.Equal(t, u.Email, found.Email)
+	assert.Equal(t, model.RoleAnalyst, found.Role)
+
+	_, err = st.User().Find(u.UserID + 1)
+	assert.Equal(t, store.ErrNoUser, err)
+}
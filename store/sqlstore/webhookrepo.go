@@ -0,0 +1,134 @@
+package sqlstore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"time"
+)
+
+type WebhookRepo struct {
+	store *Store
+}
+
+func (r *WebhookRepo) Create(d *model.WebhookDelivery) error {
+	if d.Status == "" {
+		d.Status = model.DeliveryPending
+	}
+
+	res, err := r.store.db.Exec(
+		`INSERT INTO webhook_deliveries (SessionID, MerchantID, Event, URL, Payload, Status, CreatedAt, NextAttemptAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.SessionID,
+		nullableID(d.MerchantID),
+		d.Event,
+		d.URL,
+		d.Payload,
+		d.Status,
+		d.CreatedAt,
+		d.NextAttemptAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	d.DeliveryID = uint(id)
+
+	return nil
+}
+
+// Возвращает ожидающие доставки, время очередной попытки которых уже наступило
+func (r *WebhookRepo) FindDue(now time.Time, limit int) ([]*model.WebhookDelivery, error) {
+	rows, err := r.store.db.Query(
+		`SELECT
+			DeliveryID, SessionID, COALESCE(MerchantID, 0), Event, URL, Payload, Status, Attempts, ResponseCode,
+			LastError, CreatedAt, NextAttemptAt, DeliveredAt
+		FROM webhook_deliveries
+		WHERE Status = ? AND NextAttemptAt <= ?
+		ORDER BY NextAttemptAt, DeliveryID
+		LIMIT ?`,
+		model.DeliveryPending,
+		now,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*model.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r *WebhookRepo) Update(d *model.WebhookDelivery) error {
+	_, err := r.store.db.Exec(
+		`UPDATE webhook_deliveries SET
+			Status = ?, Attempts = ?, ResponseCode = ?, LastError = ?, NextAttemptAt = ?, DeliveredAt = ?
+		WHERE DeliveryID = ?`,
+		d.Status,
+		d.Attempts,
+		d.ResponseCode,
+		d.LastError,
+		d.NextAttemptAt,
+		d.DeliveredAt,
+		d.DeliveryID,
+	)
+	return err
+}
+
+func (r *WebhookRepo) FindBySession(s *model.Session) ([]model.WebhookDelivery, error) {
+	rows, err := r.store.db.Query(
+		`SELECT
+			DeliveryID, SessionID, COALESCE(MerchantID, 0), Event, URL, Payload, Status, Attempts, ResponseCode,
+			LastError, CreatedAt, NextAttemptAt, DeliveredAt
+		FROM webhook_deliveries WHERE SessionID = ? ORDER BY DeliveryID`,
+		s.SessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []model.WebhookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func scanDelivery(rows interface{ Scan(...interface{}) error }) (*model.WebhookDelivery, error) {
+	d := &model.WebhookDelivery{}
+	if err := rows.Scan(
+		&d.DeliveryID,
+		&d.SessionID,
+		&d.MerchantID,
+		&d.Event,
+		&d.URL,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.ResponseCode,
+		&d.LastError,
+		&d.CreatedAt,
+		&d.NextAttemptAt,
+		&d.DeliveredAt,
+	); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,58 @@
+package sqlstore_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования журнала доставок уведомлений
+func TestWebhookRepo_FindDue(t *testing.T) {
+	st, teardown := sqlstore.TestStore(t, cs)
+	defer teardown("webhook_deliveries")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	session := &model.Session{SessionID: 1}
+
+	due := &model.WebhookDelivery{
+		SessionID:     session.SessionID,
+		MerchantID:    2,
+		Event:         model.EventSessionPaid,
+		URL:           "http://localhost/hook",
+		Payload:       []byte(`{}`),
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}
+	later := *due
+	later.MerchantID = 0
+	later.NextAttemptAt = now.Add(time.Minute)
+
+	assert.NoError(t, st.Webhook().Create(due))
+	assert.NoError(t, st.Webhook().Create(&later))
+	assert.NotZero(t, due.DeliveryID)
+
+	deliveries, err := st.Webhook().FindDue(now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, due.DeliveryID, deliveries[0].DeliveryID)
+	assert.Equal(t, uint(2), deliveries[0].MerchantID)
+	assert.Equal(t, model.DeliveryPending, deliveries[0].Status)
+
+	deliveries[0].Status = model.DeliveryDelivered
+	deliveries[0].Attempts = 1
+	deliveries[0].DeliveredAt = &now
+	assert.NoError(t, st.Webhook().Update(deliveries[0]))
+
+	deliveries, err = st.Webhook().FindDue(now.Add(time.Hour), 10)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, later.DeliveryID, deliveries[0].DeliveryID)
+	assert.Zero(t, deliveries[0].MerchantID)
+
+	log, err := st.Webhook().FindBySession(session)
+	assert.NoError(t, err)
+	assert.Len(t, log, 2)
+	assert.Equal(t, model.DeliveryDelivered, log[0].Status)
+}
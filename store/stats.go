@@ -0,0 +1,231 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"strings"
+	"time"
+)
+
+// Порядок сортировки сессий в статистике. Минус означает убывание,
+// при равных значениях сессии упорядочиваются по идентификатору
+type StatsSort string
+
+const (
+	SortCreatedAtDesc StatsSort = "-created_at"
+	SortCreatedAtAsc  StatsSort = "created_at"
+	SortAmountDesc    StatsSort = "-amount"
+	SortAmountAsc     StatsSort = "amount"
+)
+
+func (s StatsSort) IsValid() bool {
+	switch s {
+	case SortCreatedAtDesc, SortCreatedAtAsc, SortAmountDesc, SortAmountAsc:
+		return true
+	}
+	return false
+}
+
+func (s StatsSort) ByAmount() bool {
+	return s == SortAmountDesc || s == SortAmountAsc
+}
+
+func (s StatsSort) Descending() bool {
+	return s == SortCreatedAtDesc || s == SortAmountDesc
+}
+
+// Условия отбора сессий для статистики. Период полуоткрытый: сессии,
+// созданные ровно в End, в него не входят. Пустые поля не ограничивают
+// выборку, суммы задаются в минимальных единицах валюты
+type StatsFilter struct {
+	Begin     time.Time
+	End       time.Time
+	Statuses  []model.SessionStatus
+	Currency  string
+	MinAmount int64
+	MaxAmount int64
+	Purpose   string
+}
+
+// Назначение сравнивается с фильтром без учета регистра
+func (f *StatsFilter) Matches(s *model.Session) bool {
+	if s.CreatedAt.Before(f.Begin) || !s.CreatedAt.Before(f.End) {
+		return false
+	}
+	if len(f.Statuses) > 0 && !containsStatus(f.Statuses, s.Status) {
+		return false
+	}
+	if f.Currency != "" && s.Amount.Currency != f.Currency {
+		return false
+	}
+	if f.MinAmount > 0 && s.Amount.Minor < f.MinAmount {
+		return false
+	}
+	if f.MaxAmount > 0 && s.Amount.Minor > f.MaxAmount {
+		return false
+	}
+	if f.Purpose != "" && !strings.Contains(strings.ToLower(s.Purpose), strings.ToLower(f.Purpose)) {
+		return false
+	}
+	return true
+}
+
+func containsStatus(statuses []model.SessionStatus, status model.SessionStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Страница статистики: сессии, следующие за курсором в заданном порядке
+type StatsQuery struct {
+	StatsFilter
+	Sort  StatsSort
+	Limit int
+	After *StatsCursor
+}
+
+// Позиция последней сессии страницы. Следующая страница выбирается
+// условием по ключу сортировки, а не смещением, поэтому ее стоимость
+// не растет с номером страницы
+type StatsCursor struct {
+	Sort      StatsSort `json:"s"`
+	CreatedAt time.Time `json:"c"`
+	Amount    int64     `json:"a"`
+	SessionID uint      `json:"i"`
+}
+
+func NewStatsCursor(sort StatsSort, s *model.Session) *StatsCursor {
+	return &StatsCursor{
+		Sort:      sort,
+		CreatedAt: s.CreatedAt,
+		Amount:    s.Amount.Minor,
+		SessionID: s.SessionID,
+	}
+}
+
+// Курсор передается клиенту непрозрачной строкой
+func (c *StatsCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeStatsCursor(value string) (*StatsCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &StatsCursor{}
+	if err := json.Unmarshal(data, c); err != nil || !c.Sort.IsValid() || c.SessionID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Следует ли сессия за курсором в порядке его сортировки
+func (c *StatsCursor) Precedes(s *model.Session) bool {
+	var cmp int
+	if c.Sort.ByAmount() {
+		cmp = compareInt64(s.Amount.Minor, c.Amount)
+	} else {
+		cmp = compareTime(s.CreatedAt, c.CreatedAt)
+	}
+	if cmp == 0 {
+		cmp = compareInt64(int64(s.SessionID), int64(c.SessionID))
+	}
+
+	if c.Sort.Descending() {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+// Упорядочивает сессии так же, как запрос статистики с этой сортировкой
+func (s StatsSort) Less(a, b *model.Session) bool {
+	return (&StatsCursor{Sort: s, CreatedAt: a.CreatedAt, Amount: a.Amount.Minor, SessionID: a.SessionID}).Precedes(b)
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+// Шаг временного ряда сводной статистики
+type StatsInterval string
+
+const (
+	IntervalHour  StatsInterval = "hour"
+	IntervalDay   StatsInterval = "day"
+	IntervalWeek  StatsInterval = "week"
+	IntervalMonth StatsInterval = "month"
+)
+
+func (i StatsInterval) IsValid() bool {
+	switch i {
+	case IntervalHour, IntervalDay, IntervalWeek, IntervalMonth:
+		return true
+	}
+	return false
+}
+
+// Начало интервала, в который попадает момент t, по местному времени loc.
+// Недели начинаются с понедельника
+func (i StatsInterval) Truncate(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch i {
+	case IntervalHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case IntervalWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case IntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// Сутки и месяцы отсчитываются по календарю, поэтому при переходе
+// на летнее время интервал может быть короче или длиннее 24 часов
+func (i StatsInterval) next(t time.Time) time.Time {
+	switch i {
+	case IntervalHour:
+		return t.Add(time.Hour)
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// Границы интервалов, покрывающих период [begin, end): i-й интервал
+// начинается в bounds[i] и заканчивается перед bounds[i+1]
+func StatsBuckets(begin, end time.Time, interval StatsInterval, loc *time.Location, max int) ([]time.Time, error) {
+	bounds := []time.Time{interval.Truncate(begin, loc)}
+	for bounds[len(bounds)-1].Before(end) {
+		if len(bounds) > max {
+			return nil, ErrTooManyBuckets
+		}
+		bounds = append(bounds, interval.next(bounds[len(bounds)-1]))
+	}
+	return bounds, nil
+}
@@ -0,0 +1,107 @@
+package store_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Тестирование кодирования курсора статистики
+func TestStatsCursor_Encode(t *testing.T) {
+	s := &model.Session{
+		SessionID: 42,
+		Amount:    model.Money{Minor: 1000, Currency: "RUB"},
+		CreatedAt: time.Date(2020, 7, 19, 6, 56, 53, 0, time.UTC),
+	}
+
+	c, err := store.DecodeStatsCursor(store.NewStatsCursor(store.SortAmountDesc, s).Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, store.SortAmountDesc, c.Sort)
+	assert.Equal(t, int64(1000), c.Amount)
+	assert.Equal(t, uint(42), c.SessionID)
+	assert.True(t, s.CreatedAt.Equal(c.CreatedAt))
+
+	for _, value := range []string{"", "not a cursor", "e30"} {
+		_, err := store.DecodeStatsCursor(value)
+		assert.Equal(t, store.ErrInvalidCursor, err, value)
+	}
+}
+
+// Тестирование границ интервалов временного ряда статистики
+func TestStatsBuckets(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	testCases := []struct {
+		name     string
+		begin    time.Time
+		end      time.Time
+		interval store.StatsInterval
+		loc      *time.Location
+		expected []time.Time
+	}{
+		{
+			name:     "hours",
+			begin:    time.Date(2020, 7, 18, 10, 30, 0, 0, time.UTC),
+			end:      time.Date(2020, 7, 18, 12, 0, 0, 0, time.UTC),
+			interval: store.IntervalHour,
+			loc:      time.UTC,
+			expected: []time.Time{
+				time.Date(2020, 7, 18, 10, 0, 0, 0, time.UTC),
+				time.Date(2020, 7, 18, 11, 0, 0, 0, time.UTC),
+				time.Date(2020, 7, 18, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "days across daylight saving time",
+			begin:    time.Date(2020, 3, 28, 0, 0, 0, 0, berlin),
+			end:      time.Date(2020, 3, 29, 12, 0, 0, 0, berlin),
+			interval: store.IntervalDay,
+			loc:      berlin,
+			expected: []time.Time{
+				time.Date(2020, 3, 28, 0, 0, 0, 0, berlin),
+				time.Date(2020, 3, 29, 0, 0, 0, 0, berlin),
+				time.Date(2020, 3, 30, 0, 0, 0, 0, berlin),
+			},
+		},
+		{
+			name:     "weeks start on monday",
+			begin:    time.Date(2020, 7, 19, 0, 0, 0, 0, time.UTC),
+			end:      time.Date(2020, 7, 21, 0, 0, 0, 0, time.UTC),
+			interval: store.IntervalWeek,
+			loc:      time.UTC,
+			expected: []time.Time{
+				time.Date(2020, 7, 13, 0, 0, 0, 0, time.UTC),
+				time.Date(2020, 7, 20, 0, 0, 0, 0, time.UTC),
+				time.Date(2020, 7, 27, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:     "months in local time",
+			begin:    time.Date(2020, 6, 30, 23, 0, 0, 0, time.UTC),
+			end:      time.Date(2020, 7, 15, 0, 0, 0, 0, time.UTC),
+			interval: store.IntervalMonth,
+			loc:      berlin,
+			expected: []time.Time{
+				time.Date(2020, 7, 1, 0, 0, 0, 0, berlin),
+				time.Date(2020, 8, 1, 0, 0, 0, 0, berlin),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bounds, err := store.StatsBuckets(tc.begin, tc.end, tc.interval, tc.loc, 100)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, bounds)
+		})
+	}
+
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = store.StatsBuckets(begin, begin.AddDate(1, 0, 0), store.IntervalHour, time.UTC, 1000)
+	assert.Equal(t, store.ErrTooManyBuckets, err)
+}
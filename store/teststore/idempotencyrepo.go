@@ -0,0 +1,64 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"time"
+)
+
+type IdempotencyRepo struct {
+	store *Store
+	keys  map[string]*model.IdempotencyKey
+}
+
+func (r *IdempotencyRepo) Reserve(k *model.IdempotencyKey) (*model.IdempotencyKey, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	id := k.Scope + "/" + k.Key
+	if existing, ok := r.keys[id]; ok && existing.ExpiresAt.After(k.CreatedAt) {
+		found := *existing
+		return &found, nil
+	}
+
+	stored := *k
+	stored.StatusCode = 0
+	stored.Response = nil
+	r.keys[id] = &stored
+
+	return nil, nil
+}
+
+func (r *IdempotencyRepo) Complete(k *model.IdempotencyKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if stored, ok := r.keys[k.Scope+"/"+k.Key]; ok {
+		stored.StatusCode = k.StatusCode
+		stored.Response = append([]byte(nil), k.Response...)
+	}
+
+	return nil
+}
+
+func (r *IdempotencyRepo) Delete(k *model.IdempotencyKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.keys, k.Scope+"/"+k.Key)
+	return nil
+}
+
+func (r *IdempotencyRepo) DeleteExpired(at time.Time) (int, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	deleted := 0
+	for id, stored := range r.keys {
+		if !stored.ExpiresAt.After(at) {
+			delete(r.keys, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,126 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"sort"
+	"time"
+)
+
+type MerchantRepo struct {
+	store     *Store
+	merchants map[uint]*model.Merchant
+	keys      map[string]*model.APIKey
+	lastID    uint
+	lastKeyID uint
+}
+
+func (r *MerchantRepo) Create(m *model.Merchant) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	if err := m.BeforeCreate(); err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.lastID++
+	m.MerchantID = r.lastID
+
+	stored := *m
+	r.merchants[m.MerchantID] = &stored
+	return nil
+}
+
+func (r *MerchantRepo) Find(id uint) (*model.Merchant, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stored, ok := r.merchants[id]
+	if !ok {
+		return nil, store.ErrNoMerchant
+	}
+
+	m := *stored
+	return &m, nil
+}
+
+func (r *MerchantRepo) UpdateWebhookSecret(m *model.Merchant) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, ok := r.merchants[m.MerchantID]
+	if !ok {
+		return store.ErrNoMerchant
+	}
+
+	stored.WebhookSecret = m.WebhookSecret
+	return nil
+}
+
+func (r *MerchantRepo) CreateKey(k *model.APIKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.merchants[k.MerchantID]; !ok {
+		return store.ErrNoMerchant
+	}
+
+	r.lastKeyID++
+	k.KeyID = r.lastKeyID
+
+	stored := *k
+	r.keys[k.Prefix] = &stored
+	return nil
+}
+
+func (r *MerchantRepo) FindKey(prefix string) (*model.APIKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stored, ok := r.keys[prefix]
+	if !ok {
+		return nil, store.ErrNoAPIKey
+	}
+
+	k := *stored
+	return &k, nil
+}
+
+func (r *MerchantRepo) FindKeys(m *model.Merchant) ([]model.APIKey, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var keys []model.APIKey
+	for _, stored := range r.keys {
+		if stored.MerchantID == m.MerchantID {
+			keys = append(keys, *stored)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].KeyID < keys[j].KeyID
+	})
+
+	return keys, nil
+}
+
+func (r *MerchantRepo) RevokeKey(k *model.APIKey, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, ok := r.keys[k.Prefix]
+	if !ok {
+		return store.ErrNoAPIKey
+	}
+
+	if stored.RevokedAt == nil {
+		revokedAt := at
+		stored.RevokedAt = &revokedAt
+	}
+	k.RevokedAt = stored.RevokedAt
+	return nil
+}
@@ -0,0 +1,71 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"time"
+)
+
+// События хранятся по индексу EventID-1, на месте удаленных остается nil
+type OutboxRepo struct {
+	store  *Store
+	events []*model.OutboxEvent
+}
+
+func (r *OutboxRepo) FindUnpublished(limit int) ([]model.OutboxEvent, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var events []model.OutboxEvent
+	for _, e := range r.events {
+		if len(events) == limit {
+			break
+		}
+		if e != nil && e.PublishedAt == nil {
+			events = append(events, *e)
+		}
+	}
+	return events, nil
+}
+
+func (r *OutboxRepo) MarkPublished(e *model.OutboxEvent, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if e.EventID > 0 && int(e.EventID) <= len(r.events) && r.events[e.EventID-1] != nil {
+		publishedAt := at
+		r.events[e.EventID-1].PublishedAt = &publishedAt
+	}
+
+	e.PublishedAt = &at
+	return nil
+}
+
+func (r *OutboxRepo) DeletePublished(before time.Time, limit int) (int, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	deleted := 0
+	for i, e := range r.events {
+		if deleted == limit {
+			break
+		}
+		if e != nil && e.PublishedAt != nil && !e.PublishedAt.After(before) {
+			r.events[i] = nil
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Добавляет событие в outbox. Вызывающий должен держать блокировку хранилища,
+// под которой меняется сессия, чтобы событие и изменение были атомарны
+func (r *OutboxRepo) add(eventType string, s *model.Session, payload interface{}, at time.Time) error {
+	event, err := model.NewSessionEvent(eventType, s, payload, at)
+	if err != nil {
+		return err
+	}
+
+	event.EventID = uint(len(r.events) + 1)
+	r.events = append(r.events, event)
+	return nil
+}
@@ -0,0 +1,50 @@
+package teststore_test
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования удаления старых опубликованных событий
+func TestOutboxRepo_DeletePublished(t *testing.T) {
+	st := teststore.New()
+
+	now := time.Now().UTC()
+	for _, token := range []string{"first", "second", "third"} {
+		s := &model.Session{SessionToken: token, Amount: model.Money{Minor: 10000, Currency: "RUB"}, CreatedAt: now}
+		assert.NoError(t, st.Session().Create(s))
+	}
+
+	events, err := st.Outbox().FindUnpublished(10)
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+	assert.NoError(t, st.Outbox().MarkPublished(&events[0], now.Add(-2*time.Hour)))
+	assert.NoError(t, st.Outbox().MarkPublished(&events[1], now.Add(-time.Hour)))
+
+	deleted, err := st.Outbox().DeletePublished(now, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	deleted, err = st.Outbox().DeletePublished(now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	deleted, err = st.Outbox().DeletePublished(now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+
+	// Неопубликованное событие остается и публикуется после удаления соседей
+	events, err = st.Outbox().FindUnpublished(10)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "third", events[0].SessionToken)
+		assert.NoError(t, st.Outbox().MarkPublished(&events[0], now))
+	}
+
+	events, err = st.Outbox().FindUnpublished(10)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
@@ -0,0 +1,129 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+)
+
+type RefundRepo struct {
+	store   *Store
+	refunds map[uint][]model.Refund
+	lastID  uint
+}
+
+func (r *RefundRepo) Create(s *model.Session, rf *model.Refund) error {
+	if rf.Amount.Minor <= 0 {
+		return model.ErrAmountNotPositive
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored := r.store.sessionRepo.findByID(s.SessionID)
+	if stored == nil {
+		return store.ErrNoSession
+	}
+
+	if stored.Status != model.StatusPaid {
+		return store.ErrSessionNotPaid
+	}
+
+	if r.reserved(s.SessionID)+rf.Amount.Minor > stored.Captured.Minor {
+		return store.ErrRefundExceedsAmount
+	}
+
+	r.lastID++
+	rf.RefundID = r.lastID
+	rf.SessionID = s.SessionID
+	rf.Status = model.RefundPending
+	rf.Amount.Currency = stored.Amount.Currency
+	r.refunds[s.SessionID] = append(r.refunds[s.SessionID], *rf)
+
+	s.Status = stored.Status
+	return nil
+}
+
+func (r *RefundRepo) Complete(s *model.Session, rf *model.Refund) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored := r.store.sessionRepo.findByID(rf.SessionID)
+	if stored == nil {
+		return store.ErrNoSession
+	}
+
+	pending := r.find(rf)
+	if pending == nil || pending.Status != model.RefundPending {
+		return store.ErrRefundNotPending
+	}
+	pending.Status = model.RefundSucceeded
+	rf.Status = pending.Status
+
+	if stored.Status == model.StatusPaid && r.refunded(rf.SessionID) == stored.Captured.Minor {
+		stored.Status = model.StatusRefunded
+		if err := r.store.outboxRepo.add(model.OutboxSessionStatusChanged, stored, stored, rf.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	s.Status = stored.Status
+	return r.store.outboxRepo.add(model.OutboxRefundCreated, stored, rf, rf.CreatedAt)
+}
+
+func (r *RefundRepo) Fail(rf *model.Refund) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	pending := r.find(rf)
+	if pending == nil || pending.Status != model.RefundPending {
+		return store.ErrRefundNotPending
+	}
+	pending.Status = model.RefundFailed
+	rf.Status = pending.Status
+	return nil
+}
+
+func (r *RefundRepo) FindBySession(s *model.Session) ([]model.Refund, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	if len(r.refunds[s.SessionID]) == 0 {
+		return nil, nil
+	}
+
+	refunds := make([]model.Refund, len(r.refunds[s.SessionID]))
+	copy(refunds, r.refunds[s.SessionID])
+	return refunds, nil
+}
+
+func (r *RefundRepo) find(rf *model.Refund) *model.Refund {
+	refunds := r.refunds[rf.SessionID]
+	for i := range refunds {
+		if refunds[i].RefundID == rf.RefundID {
+			return &refunds[i]
+		}
+	}
+	return nil
+}
+
+// Сумма подтвержденных возвратов
+func (r *RefundRepo) refunded(sessionID uint) int64 {
+	var sum int64
+	for _, rf := range r.refunds[sessionID] {
+		if rf.Status == model.RefundSucceeded {
+			sum += rf.Amount.Minor
+		}
+	}
+	return sum
+}
+
+// Сумма подтвержденных и еще ожидающих ответа эквайера возвратов
+func (r *RefundRepo) reserved(sessionID uint) int64 {
+	var sum int64
+	for _, rf := range r.refunds[sessionID] {
+		if rf.IsReserved() {
+			sum += rf.Amount.Minor
+		}
+	}
+	return sum
+}
@@ -0,0 +1,396 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"sort"
+	"time"
+)
+
+type SessionRepo struct {
+	store    *Store
+	sessions map[string]*model.Session
+	lastID   uint
+}
+
+func (r *SessionRepo) Create(s *model.Session) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if s.Status == "" {
+		s.Status = model.StatusCreated
+	}
+	if s.CaptureMode == "" {
+		s.CaptureMode = model.CaptureAuto
+	}
+	if s.ExpiresAt.IsZero() {
+		s.ExpiresAt = s.CreatedAt.Add(model.DefaultSessionTTL)
+	}
+	s.Captured.Currency = s.Amount.Currency
+
+	r.lastID++
+	s.SessionID = r.lastID
+
+	stored := *s
+	stored.ClosedAt = nil
+	r.sessions[s.SessionToken] = &stored
+
+	return r.store.outboxRepo.add(model.OutboxSessionCreated, &stored, &stored, stored.CreatedAt)
+}
+
+func (r *SessionRepo) FindByToken(scope store.Scope, token string) (*model.Session, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stored, ok := r.sessions[token]
+	if !ok || !scope.Includes(stored.MerchantID) {
+		return nil, store.ErrNoSession
+	}
+
+	s := *stored
+	return &s, nil
+}
+
+func (r *SessionRepo) CommitSession(s *model.Session, closedAt time.Time) error {
+	return r.UpdateStatus(s, model.StatusPaid, closedAt)
+}
+
+func (r *SessionRepo) UpdateStatus(s *model.Session, status model.SessionStatus, at time.Time) error {
+	if !s.Status.CanTransitionTo(status) {
+		return store.ErrInvalidTransition
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, ok := r.sessions[s.SessionToken]
+	if !ok || stored.Status != s.Status {
+		return store.ErrSessionConflict
+	}
+
+	stored.Status = status
+	stored.Captured = s.Captured
+	stored.AuthorizedAt = s.AuthorizedAt
+	stored.AuthorizationID = s.AuthorizationID
+	stored.DeclineReason = s.DeclineReason
+	if status == model.StatusProcessing {
+		processingAt := at
+		stored.ProcessingAt = &processingAt
+	}
+	if !status.IsOpen() && stored.ClosedAt == nil {
+		closedAt := at
+		stored.ClosedAt = &closedAt
+	}
+
+	s.Status = stored.Status
+	s.ProcessingAt = stored.ProcessingAt
+	s.ClosedAt = stored.ClosedAt
+
+	return r.store.outboxRepo.add(model.OutboxSessionStatusChanged, stored, stored, at)
+}
+
+func (r *SessionRepo) ExpireSessions(at time.Time, limit int) ([]model.Session, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var expired []*model.Session
+	for _, stored := range r.sessions {
+		if stored.Status == model.StatusCreated && !stored.ExpiresAt.After(at) {
+			expired = append(expired, stored)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].SessionID < expired[j].SessionID
+	})
+
+	if len(expired) > limit {
+		expired = expired[:limit]
+	}
+
+	var sessions []model.Session
+	for _, stored := range expired {
+		closedAt := at
+		stored.Status = model.StatusExpired
+		stored.ClosedAt = &closedAt
+
+		if err := r.store.outboxRepo.add(model.OutboxSessionStatusChanged, stored, stored, at); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *stored)
+	}
+
+	return sessions, nil
+}
+
+func (r *SessionRepo) FindExpiredAuthorizations(authorizedBefore time.Time, limit int) ([]model.Session, error) {
+	return r.findByStatus(limit, func(stored *model.Session) bool {
+		return stored.Status == model.StatusAuthorized && stored.AuthorizedAt != nil && !stored.AuthorizedAt.After(authorizedBefore)
+	}), nil
+}
+
+func (r *SessionRepo) FindStaleProcessing(startedBefore time.Time, limit int) ([]model.Session, error) {
+	return r.findByStatus(limit, func(stored *model.Session) bool {
+		return stored.Status == model.StatusProcessing && stored.ProcessingAt != nil && !stored.ProcessingAt.After(startedBefore)
+	}), nil
+}
+
+// Копии не больше limit сессий, подходящих под match, по порядку создания
+func (r *SessionRepo) findByStatus(limit int, match func(stored *model.Session) bool) []model.Session {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var sessions []model.Session
+	for _, stored := range r.sessions {
+		if match(stored) {
+			sessions = append(sessions, *stored)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].SessionID < sessions[j].SessionID
+	})
+
+	if len(sessions) > limit {
+		sessions = sessions[:limit]
+	}
+	return sessions
+}
+
+func (r *SessionRepo) GetStats(scope store.Scope, q *store.StatsQuery) ([]model.Session, *store.StatsCursor, error) {
+	order := q.Sort
+	if order == "" {
+		order = store.SortCreatedAtDesc
+	}
+
+	sessions := r.selectStats(scope, q, order)
+	if sessions == nil {
+		return nil, nil, store.ErrNoStats
+	}
+
+	var next *store.StatsCursor
+	if q.Limit > 0 && len(sessions) > q.Limit {
+		sessions = sessions[:q.Limit]
+		next = store.NewStatsCursor(order, &sessions[q.Limit-1])
+	}
+
+	return sessions, next, nil
+}
+
+// Выборка копируется под блокировкой, а fn вызывается уже без нее,
+// чтобы медленный получатель не задерживал запись в хранилище
+func (r *SessionRepo) StreamStats(scope store.Scope, q *store.StatsQuery, fn func(s *model.Session) error) error {
+	order := q.Sort
+	if order == "" {
+		order = store.SortCreatedAtDesc
+	}
+
+	sessions := r.selectStats(scope, q, order)
+	if q.Limit > 0 && len(sessions) > q.Limit {
+		sessions = sessions[:q.Limit]
+	}
+
+	for i := range sessions {
+		if err := fn(&sessions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SessionRepo) selectStats(scope store.Scope, q *store.StatsQuery, order store.StatsSort) []model.Session {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var sessions []model.Session
+	for _, stored := range r.sessions {
+		if !scope.Includes(stored.MerchantID) || !q.Matches(stored) {
+			continue
+		}
+		if q.After != nil && !q.After.Precedes(stored) {
+			continue
+		}
+		sessions = append(sessions, model.Session{
+			SessionID:     stored.SessionID,
+			MerchantID:    stored.MerchantID,
+			Amount:        stored.Amount,
+			Purpose:       stored.Purpose,
+			Status:        stored.Status,
+			CaptureMode:   stored.CaptureMode,
+			DeclineReason: stored.DeclineReason,
+			CreatedAt:     stored.CreatedAt,
+			ExpiresAt:     stored.ExpiresAt,
+			ClosedAt:      stored.ClosedAt,
+		})
+	}
+
+	sortSessions(sessions, order)
+	return sessions
+}
+
+func sortSessions(sessions []model.Session, order store.StatsSort) {
+	sort.Slice(sessions, func(i, j int) bool {
+		return order.Less(&sessions[i], &sessions[j])
+	})
+}
+
+func (r *SessionRepo) GetTotals(scope store.Scope, f *store.StatsFilter) ([]model.CurrencyTotal, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	byCurrency := make(map[string]*model.CurrencyTotal)
+	for _, stored := range r.sessions {
+		if !scope.Includes(stored.MerchantID) || !f.Matches(stored) {
+			continue
+		}
+
+		currency := stored.Amount.Currency
+		t, ok := byCurrency[currency]
+		if !ok {
+			t = &model.CurrencyTotal{
+				Currency:   currency,
+				Amount:     model.Money{Currency: currency},
+				PaidAmount: model.Money{Currency: currency},
+
+				AuthorizedAmount: model.Money{Currency: currency},
+
+				RefundedAmount: model.Money{Currency: currency},
+			}
+			byCurrency[currency] = t
+		}
+
+		t.Count++
+		t.Amount.Minor += stored.Amount.Minor
+		if stored.Status == model.StatusPaid || stored.Status == model.StatusRefunded {
+			t.PaidCount++
+			t.PaidAmount.Minor += stored.Captured.Minor
+		}
+		if stored.Status == model.StatusAuthorized {
+			t.AuthorizedCount++
+			t.AuthorizedAmount.Minor += stored.Amount.Minor
+		}
+		if stored.Status == model.StatusCancelled {
+			t.CancelledCount++
+		}
+		t.RefundedAmount.Minor += r.store.refundRepo.refunded(stored.SessionID)
+	}
+
+	if len(byCurrency) == 0 {
+		return nil, store.ErrNoStats
+	}
+
+	totals := make([]model.CurrencyTotal, 0, len(byCurrency))
+	for _, t := range byCurrency {
+		t.NetAmount = model.Money{Minor: t.PaidAmount.Minor - t.RefundedAmount.Minor, Currency: t.Currency}
+		totals = append(totals, *t)
+	}
+
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].Currency < totals[j].Currency
+	})
+
+	return totals, nil
+}
+
+func (r *SessionRepo) findByID(id uint) *model.Session {
+	for _, stored := range r.sessions {
+		if stored.SessionID == id {
+			return stored
+		}
+	}
+	return nil
+}
+
+func (r *SessionRepo) GetSummary(scope store.Scope, f *store.StatsFilter, bounds []time.Time) (*model.StatsSummary, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	type bucketKey struct {
+		index    int
+		currency string
+	}
+
+	summary := &model.StatsSummary{Statuses: []model.StatusTotal{}, Buckets: []model.StatsBucket{}}
+	statuses := make(map[model.StatusTotal]*model.StatusTotal)
+	buckets := make(map[bucketKey]*model.StatsBucket)
+	var timeToPay time.Duration
+
+	for _, stored := range r.sessions {
+		if !scope.Includes(stored.MerchantID) || !f.Matches(stored) {
+			continue
+		}
+
+		currency := stored.Amount.Currency
+		paid := stored.Status == model.StatusPaid || stored.Status == model.StatusRefunded
+
+		summary.Count++
+		if paid {
+			summary.PaidCount++
+			timeToPay += stored.ClosedAt.Sub(stored.CreatedAt)
+		}
+
+		key := model.StatusTotal{Status: stored.Status, Currency: currency}
+		st, ok := statuses[key]
+		if !ok {
+			st = &model.StatusTotal{Status: stored.Status, Currency: currency, Amount: model.Money{Currency: currency}}
+			statuses[key] = st
+		}
+		st.Count++
+		st.Amount.Minor += stored.Amount.Minor
+
+		index := sort.Search(len(bounds), func(i int) bool {
+			return bounds[i].After(stored.CreatedAt)
+		}) - 1
+		if index < 0 || index >= len(bounds)-1 {
+			continue
+		}
+
+		b, ok := buckets[bucketKey{index, currency}]
+		if !ok {
+			b = &model.StatsBucket{
+				Start:      bounds[index],
+				Currency:   currency,
+				Amount:     model.Money{Currency: currency},
+				PaidAmount: model.Money{Currency: currency},
+			}
+			buckets[bucketKey{index, currency}] = b
+		}
+		b.Count++
+		b.Amount.Minor += stored.Amount.Minor
+		if paid {
+			b.PaidCount++
+			b.PaidAmount.Minor += stored.Captured.Minor
+		}
+	}
+
+	if summary.Count > 0 {
+		summary.ConversionRate = float64(summary.PaidCount) / float64(summary.Count)
+	}
+	if summary.PaidCount > 0 {
+		summary.AvgTimeToPay = timeToPay.Seconds() / float64(summary.PaidCount)
+	}
+
+	for _, st := range statuses {
+		summary.Statuses = append(summary.Statuses, *st)
+	}
+	sort.Slice(summary.Statuses, func(i, j int) bool {
+		a, b := summary.Statuses[i], summary.Statuses[j]
+		if a.Status != b.Status {
+			return a.Status < b.Status
+		}
+		return a.Currency < b.Currency
+	})
+
+	for _, b := range buckets {
+		summary.Buckets = append(summary.Buckets, *b)
+	}
+	sort.Slice(summary.Buckets, func(i, j int) bool {
+		a, b := summary.Buckets[i], summary.Buckets[j]
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.Currency < b.Currency
+	})
+
+	return summary, nil
+}
@@ -0,0 +1,568 @@
+package teststore_test
+
+import (
+	"errors"
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"github.com/bolshagin/xsolla-be-2020/store/teststore"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Функция для тестирования создания платежной сессии
+func TestSessionRepo_Create(t *testing.T) {
+	st := teststore.New()
+
+	s := &model.Session{
+		SessionToken: "1231231223123123",
+		Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+		Purpose:      "test",
+		CreatedAt:    time.Now(),
+	}
+
+	err := st.Session().Create(s)
+
+	assert.NoError(t, err)
+	assert.NotZero(t, s.SessionID)
+}
+
+// Функция для тестирование метода поиска платежной сессии по переданному токену
+func TestSessionRepo_FindByToken(t *testing.T) {
+	st := teststore.New()
+
+	_, err := st.Session().FindByToken(store.AllMerchants(), "1234567")
+	assert.EqualError(t, err, store.ErrNoSession.Error())
+
+	s := &model.Session{
+		Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+		SessionToken: "ca197d71-142c-4bef-abd8-65f0bdd53f0b",
+		Purpose:      "test",
+		CreatedAt:    time.Now(),
+	}
+	st.Session().Create(s)
+
+	s, err = st.Session().FindByToken(store.AllMerchants(), s.SessionToken)
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+}
+
+// Функция для тестирования закрытия платежной сессии
+func TestSessionRepo_CommitSession(t *testing.T) {
+	st := teststore.New()
+
+	s := &model.Session{
+		Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+		SessionToken: "ca197d71-142c-4bef-abd8-65f0bdd53f0b",
+		Purpose:      "test",
+		CreatedAt:    time.Now(),
+	}
+	st.Session().Create(s)
+
+	closedAt := time.Now()
+	assert.NoError(t, st.Session().CommitSession(s, closedAt))
+
+	s, err := st.Session().FindByToken(store.AllMerchants(), s.SessionToken)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StatusPaid, s.Status)
+	assert.True(t, closedAt.Equal(*s.ClosedAt))
+}
+
+// Функция для тестирования получения статистики по сессиям за период
+func TestSessionRepo_GetStats(t *testing.T) {
+	st := teststore.New()
+
+	begin := time.Date(2020, 7, 18, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2020, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	_, _, err := st.Session().GetStats(store.AllMerchants(), &store.StatsQuery{StatsFilter: store.StatsFilter{Begin: begin, End: end}})
+	assert.EqualError(t, err, store.ErrNoStats.Error())
+
+	// Период полуоткрытый: сессия, созданная ровно в end, в него не входит
+	for i, createdAt := range []time.Time{
+		begin,
+		begin.Add(24 * time.Hour),
+		end,
+		end.Add(time.Hour),
+	} {
+		st.Session().Create(&model.Session{
+			SessionToken: string(rune('a' + i)),
+			Amount:       model.Money{Minor: 100, Currency: model.DefaultCurrency},
+			Purpose:      "test",
+			CreatedAt:    createdAt,
+		})
+	}
+
+	sessions, _, err := st.Session().GetStats(store.AllMerchants(), &store.StatsQuery{StatsFilter: store.StatsFilter{Begin: begin, End: end}})
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+	assert.True(t, sessions[0].CreatedAt.After(sessions[1].CreatedAt))
+}
+
+// Функция для тестирования одновременного закрытия одной платежной сессии
+func TestSessionRepo_CommitSessionConcurrent(t *testing.T) {
+	st := teststore.New()
+
+	s := &model.Session{
+		Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+		SessionToken: "ca197d71-142c-4bef-abd8-65f0bdd53f0b",
+		Purpose:      "test",
+		CreatedAt:    time.Now(),
+	}
+	st.Session().Create(s)
+
+	const n = 20
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- st.Session().CommitSession(&model.Session{
+				SessionToken: s.SessionToken,
+				Status:       model.StatusCreated,
+			}, time.Now())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var committed int
+	for err := range errs {
+		if err == nil {
+			committed++
+			continue
+		}
+		assert.EqualError(t, err, store.ErrSessionConflict.Error())
+	}
+	assert.Equal(t, 1, committed)
+}
+
+// Функция для тестирования получения итогов по валютам за период
+func TestSessionRepo_GetTotals(t *testing.T) {
+	st := teststore.New()
+
+	begin := time.Date(2020, 7, 18, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2020, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	_, err := st.Session().GetTotals(store.AllMerchants(), &store.StatsFilter{Begin: begin, End: end})
+	assert.EqualError(t, err, store.ErrNoStats.Error())
+
+	sessions := []*model.Session{
+		{SessionToken: "a", Amount: model.Money{Minor: 1000, Currency: "RUB"}, CreatedAt: begin.Add(time.Hour)},
+		{SessionToken: "b", Amount: model.Money{Minor: 550, Currency: "RUB"}, CreatedAt: begin.Add(2 * time.Hour)},
+		{SessionToken: "c", Amount: model.Money{Minor: 300, Currency: "USD"}, CreatedAt: begin.Add(3 * time.Hour)},
+		{SessionToken: "d", Amount: model.Money{Minor: 700, Currency: "USD"}, CreatedAt: end.Add(time.Hour)},
+	}
+	for _, s := range sessions {
+		st.Session().Create(s)
+	}
+	sessions[0].Captured = sessions[0].Amount
+	assert.NoError(t, st.Session().CommitSession(sessions[0], begin.Add(90*time.Minute)))
+
+	totals, err := st.Session().GetTotals(store.AllMerchants(), &store.StatsFilter{Begin: begin, End: end})
+	assert.NoError(t, err)
+	assert.Equal(t, []model.CurrencyTotal{
+		{
+			Currency:   "RUB",
+			Count:      2,
+			Amount:     model.Money{Minor: 1550, Currency: "RUB"},
+			PaidCount:  1,
+			PaidAmount: model.Money{Minor: 1000, Currency: "RUB"},
+
+			AuthorizedAmount: model.Money{Currency: "RUB"},
+
+			RefundedAmount: model.Money{Currency: "RUB"},
+			NetAmount:      model.Money{Minor: 1000, Currency: "RUB"},
+		},
+		{
+			Currency:   "USD",
+			Count:      1,
+			Amount:     model.Money{Minor: 300, Currency: "USD"},
+			PaidAmount: model.Money{Currency: "USD"},
+
+			AuthorizedAmount: model.Money{Currency: "USD"},
+
+			RefundedAmount: model.Money{Currency: "USD"},
+			NetAmount:      model.Money{Currency: "USD"},
+		},
+	}, totals)
+}
+
+// Функция для тестирования проверки переходов между статусами сессии
+func TestSessionRepo_UpdateStatus(t *testing.T) {
+	st := teststore.New()
+
+	s := &model.Session{
+		Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+		SessionToken: "ca197d71-142c-4bef-abd8-65f0bdd53f0b",
+		Purpose:      "test",
+		CreatedAt:    time.Now(),
+	}
+	st.Session().Create(s)
+	assert.Equal(t, model.StatusCreated, s.Status)
+
+	assert.NoError(t, st.Session().UpdateStatus(s, model.StatusProcessing, time.Now()))
+	assert.Nil(t, s.ClosedAt)
+
+	err := st.Session().UpdateStatus(s, model.StatusCancelled, time.Now())
+	assert.EqualError(t, err, store.ErrInvalidTransition.Error())
+
+	stale := *s
+	assert.NoError(t, st.Session().UpdateStatus(s, model.StatusDeclined, time.Now()))
+	assert.NotNil(t, s.ClosedAt)
+
+	err = st.Session().UpdateStatus(&stale, model.StatusPaid, time.Now())
+	assert.EqualError(t, err, store.ErrSessionConflict.Error())
+
+	found, err := st.Session().FindByToken(store.AllMerchants(), s.SessionToken)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StatusDeclined, found.Status)
+}
+
+// Функция для тестирования пакетной пометки брошенных сессий как истекших
+func TestSessionRepo_ExpireSessions(t *testing.T) {
+	st := teststore.New()
+
+	now := time.Now()
+	tokens := []string{
+		"0f4c1c4e-7d1e-4a8e-9a55-0c2c6d1b0a01",
+		"0f4c1c4e-7d1e-4a8e-9a55-0c2c6d1b0a02",
+		"0f4c1c4e-7d1e-4a8e-9a55-0c2c6d1b0a03",
+	}
+	for i, token := range tokens {
+		s := &model.Session{
+			SessionToken: token,
+			Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+			Purpose:      "test",
+			CreatedAt:    now.Add(time.Duration(i-2) * time.Hour),
+			ExpiresAt:    now.Add(time.Duration(i-1) * time.Minute),
+		}
+		assert.NoError(t, st.Session().Create(s))
+	}
+
+	sessions, err := st.Session().ExpireSessions(now, 1)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, tokens[0], sessions[0].SessionToken)
+	assert.Equal(t, model.StatusExpired, sessions[0].Status)
+
+	sessions, err = st.Session().ExpireSessions(now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+	assert.Equal(t, tokens[1], sessions[0].SessionToken)
+
+	s, err := st.Session().FindByToken(store.AllMerchants(), tokens[1])
+	assert.NoError(t, err)
+	assert.Equal(t, model.StatusExpired, s.Status)
+	assert.NotNil(t, s.ClosedAt)
+
+	s, err = st.Session().FindByToken(store.AllMerchants(), tokens[2])
+	assert.NoError(t, err)
+	assert.Equal(t, model.StatusCreated, s.Status)
+}
+
+// Тестирование поиска авторизаций, не списанных к заданному моменту
+func TestSessionRepo_FindExpiredAuthorizations(t *testing.T) {
+	st := teststore.New()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	tokens := []string{
+		"5b7e4a0e-3c55-4f7a-8d0e-6a1f2b3c4d01",
+		"5b7e4a0e-3c55-4f7a-8d0e-6a1f2b3c4d02",
+		"5b7e4a0e-3c55-4f7a-8d0e-6a1f2b3c4d03",
+	}
+	for i, token := range tokens {
+		s := &model.Session{
+			SessionToken: token,
+			Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+			Purpose:      "test",
+			CaptureMode:  model.CaptureManual,
+			CreatedAt:    now.Add(-3 * time.Hour),
+			ExpiresAt:    now.Add(time.Hour),
+		}
+		assert.NoError(t, st.Session().Create(s))
+		if i == 2 {
+			continue
+		}
+
+		authorizedAt := now.Add(time.Duration(i-2) * time.Hour)
+		assert.NoError(t, st.Session().UpdateStatus(s, model.StatusProcessing, authorizedAt))
+		s.AuthorizationID = token
+		s.AuthorizedAt = &authorizedAt
+		assert.NoError(t, st.Session().UpdateStatus(s, model.StatusAuthorized, authorizedAt))
+	}
+
+	sessions, err := st.Session().FindExpiredAuthorizations(now.Add(-90*time.Minute), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, tokens[0], sessions[0].SessionToken)
+		assert.Equal(t, tokens[0], sessions[0].AuthorizationID)
+		assert.Equal(t, model.StatusAuthorized, sessions[0].Status)
+	}
+
+	sessions, err = st.Session().FindExpiredAuthorizations(now, 1)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+
+	sessions, err = st.Session().FindExpiredAuthorizations(now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+}
+
+// Тестирование поиска сессий, зависших в processing
+func TestSessionRepo_FindStaleProcessing(t *testing.T) {
+	st := teststore.New()
+
+	now := time.Now().UTC()
+	for i, token := range []string{"stale", "recent", "created"} {
+		s := &model.Session{
+			SessionToken: token,
+			Amount:       model.Money{Minor: 1000, Currency: model.DefaultCurrency},
+			CreatedAt:    now.Add(-time.Hour),
+			ExpiresAt:    now.Add(24 * time.Hour),
+		}
+		assert.NoError(t, st.Session().Create(s))
+		if i < 2 {
+			processingAt := now.Add(time.Duration(25*i-30) * time.Minute)
+			assert.NoError(t, st.Session().UpdateStatus(s, model.StatusProcessing, processingAt))
+		}
+	}
+
+	sessions, err := st.Session().FindStaleProcessing(now.Add(-10*time.Minute), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "stale", sessions[0].SessionToken)
+		assert.Equal(t, model.StatusProcessing, sessions[0].Status)
+		if assert.NotNil(t, sessions[0].ProcessingAt) {
+			assert.True(t, sessions[0].ProcessingAt.Equal(now.Add(-30*time.Minute)))
+		}
+	}
+
+	sessions, err = st.Session().FindStaleProcessing(now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	sessions, err = st.Session().FindStaleProcessing(now, 1)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+}
+
+// Функция для тестирования ограничения запросов сессиями одного мерчанта
+func TestSessionRepo_MerchantScope(t *testing.T) {
+	st := teststore.New()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sessions := []*model.Session{
+		{MerchantID: 1, SessionToken: "merchant-1", Amount: model.Money{Minor: 100, Currency: "RUB"}, CreatedAt: now},
+		{MerchantID: 2, SessionToken: "merchant-2", Amount: model.Money{Minor: 200, Currency: "RUB"}, CreatedAt: now},
+		{SessionToken: "legacy", Amount: model.Money{Minor: 300, Currency: "RUB"}, CreatedAt: now},
+	}
+	for _, s := range sessions {
+		assert.NoError(t, st.Session().Create(s))
+	}
+
+	found, err := st.Session().FindByToken(store.MerchantScope(1), "merchant-1")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), found.MerchantID)
+
+	_, err = st.Session().FindByToken(store.MerchantScope(1), "merchant-2")
+	assert.Equal(t, store.ErrNoSession, err)
+
+	_, err = st.Session().FindByToken(store.Scope{}, "legacy")
+	assert.Equal(t, store.ErrNoSession, err)
+
+	_, err = st.Session().FindByToken(store.AllMerchants(), "legacy")
+	assert.NoError(t, err)
+
+	begin, end := now.Add(-time.Hour), now.Add(time.Hour)
+	stats, _, err := st.Session().GetStats(store.MerchantScope(2), &store.StatsQuery{StatsFilter: store.StatsFilter{Begin: begin, End: end}})
+	assert.NoError(t, err)
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, uint(2), stats[0].MerchantID)
+	}
+
+	_, _, err = st.Session().GetStats(store.MerchantScope(3), &store.StatsQuery{StatsFilter: store.StatsFilter{Begin: begin, End: end}})
+	assert.Equal(t, store.ErrNoStats, err)
+
+	totals, err := st.Session().GetTotals(store.MerchantScope(2), &store.StatsFilter{Begin: begin, End: end})
+	assert.NoError(t, err)
+	if assert.Len(t, totals, 1) {
+		assert.Equal(t, int64(200), totals[0].Amount.Minor)
+	}
+
+	totals, err = st.Session().GetTotals(store.AllMerchants(), &store.StatsFilter{Begin: begin, End: end})
+	assert.NoError(t, err)
+	if assert.Len(t, totals, 1) {
+		assert.Equal(t, int64(600), totals[0].Amount.Minor)
+	}
+}
+
+// Функция для тестирования постраничной выборки статистики с фильтрами
+func TestSessionRepo_GetStatsPages(t *testing.T) {
+	st := teststore.New()
+
+	begin := time.Date(2020, 7, 18, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2020, 7, 20, 0, 0, 0, 0, time.UTC)
+
+	// Две пары сессий с одинаковым временем создания и одинаковой суммой
+	// проверяют, что страницы не теряют и не повторяют строки
+	for i, amount := range []int64{500, 100, 300, 300, 200} {
+		s := &model.Session{
+			SessionToken: string(rune('a' + i)),
+			Amount:       model.Money{Minor: amount, Currency: "RUB"},
+			Purpose:      "order #" + string(rune('1'+i)),
+			CreatedAt:    begin.Add(time.Duration(i/2) * time.Hour),
+		}
+		assert.NoError(t, st.Session().Create(s))
+	}
+	assert.NoError(t, st.Session().Create(&model.Session{
+		SessionToken: "f",
+		Amount:       model.Money{Minor: 400, Currency: "USD"},
+		Purpose:      "100% discount",
+		CreatedAt:    begin,
+	}))
+
+	collect := func(q *store.StatsQuery) []string {
+		var purposes []string
+		for {
+			sessions, next, err := st.Session().GetStats(store.AllMerchants(), q)
+			if !assert.NoError(t, err) {
+				return purposes
+			}
+			assert.True(t, len(sessions) <= q.Limit)
+			for _, s := range sessions {
+				purposes = append(purposes, s.Purpose)
+			}
+			if next == nil {
+				return purposes
+			}
+			q.After = next
+		}
+	}
+
+	filter := store.StatsFilter{Begin: begin, End: end, Currency: "RUB"}
+	assert.Equal(t,
+		[]string{"order #5", "order #4", "order #3", "order #2", "order #1"},
+		collect(&store.StatsQuery{StatsFilter: filter, Limit: 2}))
+	assert.Equal(t,
+		[]string{"order #1", "order #2", "order #3", "order #4", "order #5"},
+		collect(&store.StatsQuery{StatsFilter: filter, Sort: store.SortCreatedAtAsc, Limit: 2}))
+	assert.Equal(t,
+		[]string{"order #1", "order #4", "order #3", "order #5", "order #2"},
+		collect(&store.StatsQuery{StatsFilter: filter, Sort: store.SortAmountDesc, Limit: 3}))
+
+	filter = store.StatsFilter{Begin: begin, End: end, MinAmount: 200, MaxAmount: 300, Currency: "RUB"}
+	assert.Equal(t,
+		[]string{"order #5", "order #3", "order #4"},
+		collect(&store.StatsQuery{StatsFilter: filter, Sort: store.SortAmountAsc, Limit: 1}))
+
+	filter = store.StatsFilter{Begin: begin, End: end, Purpose: "100%"}
+	assert.Equal(t, []string{"100% discount"}, collect(&store.StatsQuery{StatsFilter: filter, Limit: 10}))
+
+	filter = store.StatsFilter{Begin: begin, End: end, Purpose: "ORDER #_"}
+	_, _, err := st.Session().GetStats(store.AllMerchants(), &store.StatsQuery{StatsFilter: filter, Limit: 10})
+	assert.Equal(t, store.ErrNoStats, err)
+
+	filter = store.StatsFilter{Begin: begin, End: end, Statuses: []model.SessionStatus{model.StatusCreated}}
+	totals, err := st.Session().GetTotals(store.AllMerchants(), &filter)
+	assert.NoError(t, err)
+	assert.Len(t, totals, 2)
+
+	filter.Statuses = []model.SessionStatus{model.StatusPaid}
+	_, err = st.Session().GetTotals(store.AllMerchants(), &filter)
+	assert.Equal(t, store.ErrNoStats, err)
+}
+
+// Функция для тестирования сводной статистики по сессиям
+func TestSessionRepo_GetSummary(t *testing.T) {
+	st := teststore.New()
+
+	begin := time.Date(2020, 7, 18, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2020, 7, 20, 0, 0, 0, 0, time.UTC)
+	filter := &store.StatsFilter{Begin: begin, End: end}
+
+	summary, err := st.Session().GetSummary(store.AllMerchants(), filter, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, summary.Count)
+	assert.Empty(t, summary.Statuses)
+
+	sessions := []*model.Session{
+		{SessionToken: "a", Amount: model.Money{Minor: 1000, Currency: "RUB"}, CreatedAt: begin.Add(time.Hour)},
+		{SessionToken: "b", Amount: model.Money{Minor: 500, Currency: "RUB"}, CreatedAt: begin.Add(2 * time.Hour)},
+		{SessionToken: "c", Amount: model.Money{Minor: 300, Currency: "USD"}, CreatedAt: begin.Add(25 * time.Hour)},
+		{SessionToken: "d", Amount: model.Money{Minor: 700, Currency: "RUB"}, CreatedAt: begin.Add(26 * time.Hour)},
+	}
+	for _, s := range sessions {
+		assert.NoError(t, st.Session().Create(s))
+	}
+	for i, s := range sessions[:2] {
+		s.Captured = s.Amount
+		assert.NoError(t, st.Session().CommitSession(s, s.CreatedAt.Add(time.Duration(i+1)*time.Minute)))
+	}
+
+	bounds := []time.Time{begin, begin.Add(24 * time.Hour), end}
+	summary, err = st.Session().GetSummary(store.AllMerchants(), filter, bounds)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, summary.Count)
+	assert.Equal(t, 2, summary.PaidCount)
+	assert.InDelta(t, 0.5, summary.ConversionRate, 0.0001)
+	assert.InDelta(t, 90, summary.AvgTimeToPay, 0.0001)
+
+	assert.Equal(t, []model.StatusTotal{
+		{Status: model.StatusCreated, Currency: "RUB", Count: 1, Amount: model.Money{Minor: 700, Currency: "RUB"}},
+		{Status: model.StatusCreated, Currency: "USD", Count: 1, Amount: model.Money{Minor: 300, Currency: "USD"}},
+		{Status: model.StatusPaid, Currency: "RUB", Count: 2, Amount: model.Money{Minor: 1500, Currency: "RUB"}},
+	}, summary.Statuses)
+
+	if assert.Len(t, summary.Buckets, 3) {
+		assert.True(t, begin.Equal(summary.Buckets[0].Start))
+		assert.Equal(t, 2, summary.Buckets[0].Count)
+		assert.Equal(t, 2, summary.Buckets[0].PaidCount)
+		assert.Equal(t, model.Money{Minor: 1500, Currency: "RUB"}, summary.Buckets[0].PaidAmount)
+		assert.Equal(t, "RUB", summary.Buckets[1].Currency)
+		assert.Equal(t, 0, summary.Buckets[1].PaidCount)
+		assert.Equal(t, "USD", summary.Buckets[2].Currency)
+	}
+
+	filter.Currency = "USD"
+	summary, err = st.Session().GetSummary(store.AllMerchants(), filter, bounds)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Count)
+	assert.Equal(t, 0.0, summary.ConversionRate)
+}
+
+// Функция для тестирования потоковой выборки статистики
+func TestSessionRepo_StreamStats(t *testing.T) {
+	st := teststore.New()
+
+	begin := time.Date(2020, 7, 18, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2020, 7, 20, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, st.Session().Create(&model.Session{
+			SessionToken: string(rune('a' + i)),
+			Amount:       model.Money{Minor: int64(100 * (i + 1)), Currency: "RUB"},
+			Purpose:      "test",
+			CreatedAt:    begin.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	q := &store.StatsQuery{StatsFilter: store.StatsFilter{Begin: begin, End: end}, Sort: store.SortAmountAsc}
+
+	var amounts []int64
+	assert.NoError(t, st.Session().StreamStats(store.AllMerchants(), q, func(s *model.Session) error {
+		amounts = append(amounts, s.Amount.Minor)
+		return nil
+	}))
+	assert.Equal(t, []int64{100, 200, 300}, amounts)
+
+	stop := errors.New("stop")
+	calls := 0
+	err := st.Session().StreamStats(store.AllMerchants(), q, func(s *model.Session) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+}
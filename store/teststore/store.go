@@ -0,0 +1,90 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"sync"
+	"time"
+)
+
+type Store struct {
+	mu           sync.RWMutex
+	sessionRepo  *SessionRepo
+	refundRepo   *RefundRepo
+	idemRepo     *IdempotencyRepo
+	webhookRepo  *WebhookRepo
+	outboxRepo   *OutboxRepo
+	merchantRepo *MerchantRepo
+	userRepo     *UserRepo
+	tokenRepo    *TokenRepo
+}
+
+func New() *Store {
+	s := &Store{}
+	s.sessionRepo = &SessionRepo{
+		store:    s,
+		sessions: make(map[string]*model.Session),
+	}
+	s.refundRepo = &RefundRepo{
+		store:   s,
+		refunds: make(map[uint][]model.Refund),
+	}
+	s.idemRepo = &IdempotencyRepo{
+		store: s,
+		keys:  make(map[string]*model.IdempotencyKey),
+	}
+	s.webhookRepo = &WebhookRepo{
+		store:      s,
+		deliveries: make(map[uint]*model.WebhookDelivery),
+	}
+	s.outboxRepo = &OutboxRepo{
+		store: s,
+	}
+	s.merchantRepo = &MerchantRepo{
+		store:     s,
+		merchants: make(map[uint]*model.Merchant),
+		keys:      make(map[string]*model.APIKey),
+	}
+	s.userRepo = &UserRepo{
+		store: s,
+		users: make(map[uint]*model.User),
+	}
+	s.tokenRepo = &TokenRepo{
+		store:   s,
+		refresh: make(map[uint]*model.RefreshToken),
+		revoked: make(map[string]time.Time),
+	}
+	return s
+}
+
+func (s *Store) Session() store.SessionRepository {
+	return s.sessionRepo
+}
+
+func (s *Store) Refund() store.RefundRepository {
+	return s.refundRepo
+}
+
+func (s *Store) Idempotency() store.IdempotencyRepository {
+	return s.idemRepo
+}
+
+func (s *Store) Webhook() store.WebhookRepository {
+	return s.webhookRepo
+}
+
+func (s *Store) Outbox() store.OutboxRepository {
+	return s.outboxRepo
+}
+
+func (s *Store) Merchant() store.MerchantRepository {
+	return s.merchantRepo
+}
+
+func (s *Store) User() store.UserRepository {
+	return s.userRepo
+}
+
+func (s *Store) Token() store.TokenRepository {
+	return s.tokenRepo
+}
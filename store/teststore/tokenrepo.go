@@ -0,0 +1,109 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"time"
+)
+
+type TokenRepo struct {
+	store   *Store
+	refresh map[uint]*model.RefreshToken
+	revoked map[string]time.Time
+	lastID  uint
+}
+
+func (r *TokenRepo) CreateRefresh(t *model.RefreshToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.lastID++
+	t.TokenID = r.lastID
+
+	stored := *t
+	r.refresh[t.TokenID] = &stored
+	return nil
+}
+
+func (r *TokenRepo) FindRefresh(hash string) (*model.RefreshToken, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	for _, stored := range r.refresh {
+		if stored.TokenHash == hash {
+			t := *stored
+			return &t, nil
+		}
+	}
+
+	return nil, store.ErrNoRefreshToken
+}
+
+func (r *TokenRepo) RevokeRefresh(t *model.RefreshToken, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, ok := r.refresh[t.TokenID]
+	if !ok {
+		return store.ErrNoRefreshToken
+	}
+	if stored.RevokedAt != nil {
+		return store.ErrRefreshTokenRevoked
+	}
+
+	revokedAt := at
+	stored.RevokedAt = &revokedAt
+	t.RevokedAt = stored.RevokedAt
+	return nil
+}
+
+func (r *TokenRepo) RevokeUserRefresh(userID uint, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, stored := range r.refresh {
+		if stored.UserID == userID && stored.RevokedAt == nil {
+			revokedAt := at
+			stored.RevokedAt = &revokedAt
+		}
+	}
+
+	return nil
+}
+
+func (r *TokenRepo) Revoke(jti string, expiresAt time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.revoked[jti] = expiresAt
+	return nil
+}
+
+func (r *TokenRepo) IsRevoked(jti string) (bool, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	_, ok := r.revoked[jti]
+	return ok, nil
+}
+
+func (r *TokenRepo) DeleteExpired(at time.Time) (int, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	deleted := 0
+	for id, stored := range r.refresh {
+		if !stored.ExpiresAt.After(at) {
+			delete(r.refresh, id)
+			deleted++
+		}
+	}
+	for jti, expiresAt := range r.revoked {
+		if !expiresAt.After(at) {
+			delete(r.revoked, jti)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
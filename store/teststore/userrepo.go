@@ -0,0 +1,67 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"github.com/bolshagin/xsolla-be-2020/store"
+	"strings"
+)
+
+type UserRepo struct {
+	store  *Store
+	users  map[uint]*model.User
+	lastID uint
+}
+
+func (r *UserRepo) Create(u *model.User) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	if err := u.BeforeCreate(); err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, stored := range r.users {
+		if stored.Email == u.Email {
+			return store.ErrUserExists
+		}
+	}
+
+	r.lastID++
+	u.UserID = r.lastID
+
+	stored := *u
+	r.users[u.UserID] = &stored
+	return nil
+}
+
+func (r *UserRepo) Find(id uint) (*model.User, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stored, ok := r.users[id]
+	if !ok {
+		return nil, store.ErrNoUser
+	}
+
+	u := *stored
+	return &u, nil
+}
+
+func (r *UserRepo) FindByEmail(email string) (*model.User, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	for _, stored := range r.users {
+		if stored.Email == email {
+			u := *stored
+			return &u, nil
+		}
+	}
+
+	return nil, store.ErrNoUser
+}
@@ -0,0 +1,82 @@
+package teststore
+
+import (
+	"github.com/bolshagin/xsolla-be-2020/model"
+	"sort"
+	"time"
+)
+
+type WebhookRepo struct {
+	store      *Store
+	deliveries map[uint]*model.WebhookDelivery
+	lastID     uint
+}
+
+func (r *WebhookRepo) Create(d *model.WebhookDelivery) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if d.Status == "" {
+		d.Status = model.DeliveryPending
+	}
+
+	r.lastID++
+	d.DeliveryID = r.lastID
+
+	stored := *d
+	r.deliveries[d.DeliveryID] = &stored
+	return nil
+}
+
+func (r *WebhookRepo) FindDue(now time.Time, limit int) ([]*model.WebhookDelivery, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var deliveries []*model.WebhookDelivery
+	for _, d := range r.deliveries {
+		if d.Status == model.DeliveryPending && !d.NextAttemptAt.After(now) {
+			found := *d
+			deliveries = append(deliveries, &found)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].NextAttemptAt.Equal(deliveries[j].NextAttemptAt) {
+			return deliveries[i].DeliveryID < deliveries[j].DeliveryID
+		}
+		return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt)
+	})
+
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (r *WebhookRepo) Update(d *model.WebhookDelivery) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.deliveries[d.DeliveryID]; ok {
+		stored := *d
+		r.deliveries[d.DeliveryID] = &stored
+	}
+	return nil
+}
+
+func (r *WebhookRepo) FindBySession(s *model.Session) ([]model.WebhookDelivery, error) {
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	var deliveries []model.WebhookDelivery
+	for _, d := range r.deliveries {
+		if d.SessionID == s.SessionID {
+			deliveries = append(deliveries, *d)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].DeliveryID < deliveries[j].DeliveryID
+	})
+	return deliveries, nil
+}